@@ -0,0 +1,119 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func fired(c <-chan time.Time) (time.Time, bool) {
+	select {
+	case t := <-c:
+		return t, true
+	default:
+		return time.Time{}, false
+	}
+}
+
+func TestFakeTimer(t *testing.T) {
+	start := time.Unix(1000, 0)
+	f := NewFake(start)
+
+	timer := f.NewTimer(time.Second)
+	f.Advance(999 * time.Millisecond)
+	if _, ok := fired(timer.C()); ok {
+		t.Fatal("expected the timer not to fire early")
+	}
+
+	f.Advance(time.Millisecond)
+	at, ok := fired(timer.C())
+	if !ok || !at.Equal(start.Add(time.Second)) {
+		t.Fatalf("expected the timer to fire at %v, got %v %v", start.Add(time.Second), at, ok)
+	}
+	if timer.Stop() {
+		t.Error("expected a fired timer not to be pending")
+	}
+
+	if timer.Reset(time.Second) {
+		t.Error("expected the reset timer not to have been pending")
+	}
+	if !timer.Stop() {
+		t.Error("expected the reset timer to be pending")
+	}
+	f.Advance(time.Hour)
+	if _, ok := fired(timer.C()); ok {
+		t.Error("expected a stopped timer not to fire")
+	}
+	if got := f.Since(start); got != time.Hour+time.Second {
+		t.Errorf("expected %v to have passed, got %v", time.Hour+time.Second, got)
+	}
+}
+
+func TestFakeTicker(t *testing.T) {
+	f := NewFake(time.Unix(0, 0))
+	ticker := f.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	f.Advance(time.Second)
+	if _, ok := fired(ticker.C()); !ok {
+		t.Fatal("expected a tick")
+	}
+
+	// ticks nobody reads are dropped
+	f.Advance(5 * time.Second)
+	at, ok := fired(ticker.C())
+	if !ok || !at.Equal(time.Unix(2, 0)) {
+		t.Fatalf("expected the first missed tick, got %v %v", at, ok)
+	}
+	if _, ok := fired(ticker.C()); ok {
+		t.Error("expected the other ticks to be dropped")
+	}
+
+	ticker.Stop()
+	f.Advance(time.Second)
+	if _, ok := fired(ticker.C()); ok {
+		t.Error("expected a stopped ticker not to tick")
+	}
+}
+
+func TestFakeAfterFunc(t *testing.T) {
+	f := NewFake(time.Unix(0, 0))
+
+	called := make(chan time.Time, 1)
+	timer := f.AfterFunc(time.Second, func() { called <- f.Now() })
+	if timer.C() != nil {
+		t.Error("expected a timer of AfterFunc to have no channel")
+	}
+
+	stopped := f.AfterFunc(time.Second, func() { t.Error("expected a stopped function not to be called") })
+	if !stopped.Stop() {
+		t.Error("expected the function to be pending")
+	}
+
+	f.Advance(2 * time.Second)
+	select {
+	case at := <-called:
+		if !at.Equal(time.Unix(2, 0)) {
+			t.Errorf("expected the function to run once the clock was advanced, got %v", at)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the function to be called")
+	}
+}
+
+func TestBlockUntil(t *testing.T) {
+	f := NewFake(time.Unix(0, 0))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		<-f.NewTimer(time.Minute).C()
+	}()
+
+	f.BlockUntil(1)
+	f.Advance(time.Minute)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the waiting goroutine to wake up")
+	}
+}
@@ -0,0 +1,182 @@
+package heartbeat
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	nonceSize = 16
+	// sealOverhead is what sealing adds to a heartbeat
+	//
+	//	timestamp  8 bytes, unix nanoseconds
+	//	nonce      16 bytes
+	//	hmac       32 bytes, HMAC-SHA256 of the heartbeat, timestamp and nonce
+	sealOverhead = 8 + nonceSize + sha256.Size
+
+	// DefaultMaxClockSkew is how far the timestamp of a sealed heartbeat
+	// may be from the receiver's clock
+	DefaultMaxClockSkew = 30 * time.Second
+
+	// handshakeTimeout bounds the TLS handshake of a heartbeat connection
+	handshakeTimeout = 10 * time.Second
+)
+
+var (
+	ErrUnauthenticated = errors.New("heartbeat not authenticated")
+	ErrTLSOverUDP      = errors.New("mutual TLS needs the TCP transport")
+)
+
+// seal signs data with key, the timestamp and the random nonce let the
+// receiver refuse replayed heartbeats. it panics when the system cannot
+// provide randomness, a repeated nonce would get messages refused.
+func seal(key, data []byte, now time.Time) []byte {
+	buf := make([]byte, len(data), len(data)+sealOverhead)
+	copy(buf, data)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(now.UnixNano()))
+
+	var nonce [nonceSize]byte
+	_, err := rand.Read(nonce[:])
+	if err != nil {
+		panic(fmt.Sprintf("heartbeat: reading a nonce failed: %v", err))
+	}
+	buf = append(buf, nonce[:]...)
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(buf)
+	return mac.Sum(buf)
+}
+
+// open checks a heartbeat sealed with the key of hm and returns its
+// content
+func (hm *HeartbeatClient) open(sealed []byte, now time.Time) ([]byte, error) {
+	return hm.nonces.open(hm.Key, hm.MaxClockSkew, sealed, now)
+}
+
+// nonceCache remembers the nonces of the sealed messages accepted while
+// their timestamps are, so a replayed message is refused
+type nonceCache struct {
+	mu     sync.Mutex
+	seen   map[[nonceSize]byte]time.Time
+	purged time.Time
+}
+
+// open checks a message sealed with key and returns its content. a message
+// is refused when its signature does not match, its timestamp is more than
+// skew, DefaultMaxClockSkew when 0, away or its nonce was seen before.
+func (c *nonceCache) open(key []byte, skew time.Duration, sealed []byte, now time.Time) ([]byte, error) {
+	if skew <= 0 {
+		skew = DefaultMaxClockSkew
+	}
+
+	data, sent, nonce, err := unseal(key, sealed, now, skew)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// a nonce only has to be remembered while its timestamp is accepted
+	if now.Sub(c.purged) > skew {
+		for n, expires := range c.seen {
+			if now.After(expires) {
+				delete(c.seen, n)
+			}
+		}
+		c.purged = now
+	}
+	if _, seen := c.seen[nonce]; seen {
+		return nil, ErrUnauthenticated
+	}
+	if c.seen == nil {
+		c.seen = map[[nonceSize]byte]time.Time{}
+	}
+	c.seen[nonce] = sent.Add(skew)
+
+	return data, nil
+}
+
+// unseal checks the signature and the timestamp of a sealed message and
+// returns its content, when it was sealed and its nonce
+func unseal(key, sealed []byte, now time.Time, skew time.Duration) ([]byte, time.Time, [nonceSize]byte, error) {
+	var nonce [nonceSize]byte
+	if len(sealed) < sealOverhead {
+		return nil, time.Time{}, nonce, ErrUnauthenticated
+	}
+
+	signed, sum := sealed[:len(sealed)-sha256.Size], sealed[len(sealed)-sha256.Size:]
+	mac := hmac.New(sha256.New, key)
+	mac.Write(signed)
+	if !hmac.Equal(mac.Sum(nil), sum) {
+		return nil, time.Time{}, nonce, ErrUnauthenticated
+	}
+
+	data := signed[:len(signed)-8-nonceSize]
+	sent := time.Unix(0, int64(binary.BigEndian.Uint64(signed[len(data):])))
+	copy(nonce[:], signed[len(data)+8:])
+
+	if d := now.Sub(sent); d > skew || d < -skew {
+		return nil, time.Time{}, nonce, ErrUnauthenticated
+	}
+	return data, sent, nonce, nil
+}
+
+// authenticate returns the node ID and the payload of a heartbeat line of
+// a TCP connection. with a key the line is hex encoded and sealed,
+// identities are the IDs the client certificate names on a mutual TLS
+// connection.
+func (hm *HeartbeatClient) authenticate(line string, identities []string) (string, []byte, error) {
+	if hm.Key != nil {
+		sealed, err := hex.DecodeString(line)
+		if err != nil {
+			return "", nil, ErrUnauthenticated
+		}
+		data, err := hm.open(sealed, hm.now())
+		if err != nil {
+			return "", nil, err
+		}
+		line = string(data)
+	}
+
+	id, payload, err := parseBeatLine(line)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if identities != nil && !slices.Contains(identities, id) {
+		return "", nil, fmt.Errorf("%w: the certificate is not for %s", ErrUnauthenticated, id)
+	}
+	return id, payload, nil
+}
+
+// RejectedBeats returns the number of heartbeats refused because they
+// were not authenticated
+func (hm *HeartbeatClient) RejectedBeats() uint64 {
+	return hm.rejected.Load()
+}
+
+func (hm *HeartbeatClient) reject(from string, err error) {
+	hm.rejected.Add(1)
+	hm.logf("heartbeat: rejecting a heartbeat from %s: %v", from, err)
+}
+
+// peerIdentities returns the node IDs the client certificate of a mutual
+// TLS connection vouches for, nil for other connections
+func peerIdentities(state tls.ConnectionState) []string {
+	if len(state.PeerCertificates) == 0 {
+		return nil
+	}
+
+	cert := state.PeerCertificates[0]
+	return append(slices.Clone(cert.DNSNames), cert.Subject.CommonName)
+}
@@ -0,0 +1,187 @@
+package heartbeat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"testing"
+	"time"
+)
+
+var testKey = []byte("0123456789abcdef0123456789abcdef")
+
+func TestSeal(t *testing.T) {
+	hb := NewHeartbeatClient(time.Second, time.Second)
+	hb.Key = testKey
+	now := time.Now()
+
+	sealed := seal(testKey, []byte("node1"), now)
+	data, err := hb.open(sealed, now)
+	if err != nil || string(data) != "node1" {
+		t.Fatalf("expected node1, got %q %v", data, err)
+	}
+
+	_, err = hb.open(sealed, now)
+	if !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("expected a replayed heartbeat to be refused, got %v", err)
+	}
+
+	tampered := seal(testKey, []byte("node1"), now)
+	tampered[0] = 'N'
+	_, err = hb.open(tampered, now)
+	if !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("expected a changed heartbeat to be refused, got %v", err)
+	}
+
+	_, err = hb.open(seal([]byte("another key"), []byte("node1"), now), now)
+	if !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("expected a heartbeat sealed with another key to be refused, got %v", err)
+	}
+
+	_, err = hb.open(seal(testKey, []byte("node1"), now.Add(-time.Minute)), now)
+	if !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("expected a stale heartbeat to be refused, got %v", err)
+	}
+
+	// nonces are forgotten once their heartbeats would be too old anyway
+	later := now.Add(2 * DefaultMaxClockSkew)
+	_, err = hb.open(seal(testKey, []byte("node1"), later), later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hb.nonces.seen) != 1 {
+		t.Errorf("expected the expired nonces to be purged, got %d", len(hb.nonces.seen))
+	}
+}
+
+// startServer starts a HeartbeatClient monitoring the given nodes on an
+// ephemeral port
+func startServer(t *testing.T, configure func(*HeartbeatClient), nodes ...string) *HeartbeatClient {
+	t.Helper()
+
+	server := NewHeartbeatClient(time.Second, time.Second)
+	server.ListenAddress = "127.0.0.1:0"
+	server.Logger = log.New(io.Discard, "", 0)
+	for _, id := range nodes {
+		server.AddNode(id, "")
+	}
+	configure(server)
+
+	err := server.Start(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Stop)
+
+	return server
+}
+
+// startSender sends heartbeats as id to server until the test ends
+func startSender(t *testing.T, id string, server *HeartbeatClient, configure func(*HeartbeatSender)) {
+	t.Helper()
+
+	registry := NewHeartbeatClient(time.Second, time.Second)
+	registry.AddNode("server", server.Addr().String())
+
+	sender := NewHeartbeatSender(id, registry, 10*time.Millisecond)
+	sender.OnError = func(string, error) {}
+	configure(sender)
+	sender.Start()
+	t.Cleanup(sender.Stop)
+}
+
+func TestAuthenticatedHeartbeats(t *testing.T) {
+	for _, transport := range []Transport{TCP, UDP} {
+		t.Run(fmt.Sprint(transport), func(t *testing.T) {
+			server := startServer(t, func(hb *HeartbeatClient) {
+				hb.Transport = transport
+				hb.Key = testKey
+			}, "node1", "forged")
+
+			startSender(t, "node1", server, func(s *HeartbeatSender) {
+				s.Transport = transport
+				s.Key = testKey
+			})
+			startSender(t, "forged", server, func(s *HeartbeatSender) {
+				s.Transport = transport
+				s.Key = []byte("guessed")
+			})
+
+			waitFor(t, "a heartbeat from node1", func() bool { return !lastBeat(server, "node1").IsZero() })
+			waitFor(t, "the forged beats to be counted", func() bool { return server.RejectedBeats() >= 3 })
+			if !lastBeat(server, "forged").IsZero() {
+				t.Error("expected the forged heartbeats to be refused")
+			}
+		})
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca, err := NewCertificateAuthority("cluster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverCert, err := ca.Issue("server", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodeCert, err := ca.Issue("node1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := startServer(t, func(hb *HeartbeatClient) {
+		hb.TLSConfig = ca.TLSConfig(serverCert)
+	}, "node1", "node2", "stranger")
+
+	startSender(t, "node1", server, func(s *HeartbeatSender) {
+		s.TLSConfig = ca.TLSConfig(nodeCert)
+	})
+	// node1's certificate does not vouch for node2
+	startSender(t, "node2", server, func(s *HeartbeatSender) {
+		s.TLSConfig = ca.TLSConfig(nodeCert)
+	})
+
+	// a certificate of another authority is refused during the handshake
+	other, err := NewCertificateAuthority("other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	strangerCert, err := other.Issue("stranger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	strangerConfig := other.TLSConfig(strangerCert)
+	strangerConfig.RootCAs = ca.TLSConfig(nodeCert).RootCAs
+	startSender(t, "stranger", server, func(s *HeartbeatSender) {
+		s.TLSConfig = strangerConfig
+	})
+
+	waitFor(t, "a heartbeat from node1", func() bool { return !lastBeat(server, "node1").IsZero() })
+	waitFor(t, "the refused beats to be counted", func() bool { return server.RejectedBeats() >= 3 })
+
+	// so is a connection without TLS
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(conn, "stranger\n")
+	conn.Close()
+
+	time.Sleep(50 * time.Millisecond)
+	for _, id := range []string{"node2", "stranger"} {
+		if !lastBeat(server, id).IsZero() {
+			t.Errorf("expected the heartbeats claiming to be %s to be refused", id)
+		}
+	}
+
+	hb := NewHeartbeatClient(time.Second, time.Second)
+	hb.Transport = UDP
+	hb.TLSConfig = ca.TLSConfig(serverCert)
+	if !errors.Is(hb.Start(context.Background()), ErrTLSOverUDP) {
+		t.Error("expected mutual TLS over UDP to be refused")
+	}
+}
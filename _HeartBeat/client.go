@@ -1,10 +1,332 @@
 package heartbeat
 
-//
-//
-//
-//
-//
-
-//
-//
\ No newline at end of file
+import (
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	defaultJitter = 0.2
+	maxBackoff    = 30 * time.Second
+)
+
+var errBackingOff = errors.New("waiting before dialing again")
+
+// HeartbeatSender announces its node ID to every peer registered with
+// AddNode on the HeartbeatClient it sends for, so two processes that
+// register each other monitor each other. each peer keeps one connection
+// open between beats, a broken one is dialed again with exponential
+// backoff.
+type HeartbeatSender struct {
+	id       string
+	registry *HeartbeatClient
+	interval time.Duration
+
+	// Jitter spreads the beats by up to that fraction of the interval in
+	// either direction so peers started together do not beat in lockstep
+	Jitter float64
+	// Transport is how the beats are sent, TCP by default
+	Transport Transport
+	// Payload is called for every beat and its result sent along, its Term
+	// is the term of the UDP beats
+	Payload func() Payload
+	// Key seals the beats for a HeartbeatClient expecting the same key
+	Key []byte
+	// TLSConfig makes the TCP connections use mutual TLS, the peer must
+	// present a certificate for its node ID
+	TLSConfig *tls.Config
+	// OnError is called with the peer and the error whenever a beat could
+	// not be sent, failures are logged when it is nil
+	OnError func(peerID string, err error)
+
+	// incarnation tells the beats of this sender from the ones of an
+	// earlier process with the same id
+	incarnation uint64
+
+	mu    sync.Mutex
+	peers map[string]*peer
+	done  chan struct{}
+	wg    sync.WaitGroup
+	acks  sync.WaitGroup
+	// ackHooks are called with the peer and the send time of every
+	// acknowledged UDP beat
+	ackHooks []func(peerID string, sent time.Time)
+}
+
+// peer is the connection to one node, only the goroutine sending to it
+// uses conn
+type peer struct {
+	mu       sync.Mutex
+	id       string
+	address  string
+	conn     net.Conn
+	backoff  time.Duration
+	nextDial time.Time
+	// seq is the sequence number of the last UDP beat
+	seq uint64
+	// sent remembers when the last UDP beats were sent
+	sent [sentBeats]sentBeat
+}
+
+// sentBeats is the number of UDP beats an ack is matched against
+const sentBeats = 64
+
+type sentBeat struct {
+	seq uint64
+	at  time.Time
+}
+
+// NewHeartbeatSender returns a sender announcing id every interval to the
+// nodes of registry
+func NewHeartbeatSender(id string, registry *HeartbeatClient, interval time.Duration) *HeartbeatSender {
+	return &HeartbeatSender{
+		id:       id,
+		registry: registry,
+		interval: interval,
+		Jitter:   defaultJitter,
+		peers:    map[string]*peer{},
+
+		incarnation: newIncarnation(),
+	}
+}
+
+// Start begins sending heartbeats in the background
+func (s *HeartbeatSender) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.done != nil {
+		return
+	}
+	s.done = make(chan struct{})
+
+	s.wg.Add(1)
+	go s.run(s.done)
+}
+
+// Stop stops sending and closes the connections to the peers
+func (s *HeartbeatSender) Stop() {
+	s.mu.Lock()
+	done := s.done
+	s.done = nil
+	s.mu.Unlock()
+
+	if done == nil {
+		return
+	}
+	close(done)
+	s.wg.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, p := range s.peers {
+		p.close()
+		delete(s.peers, id)
+	}
+	s.acks.Wait()
+}
+
+func (s *HeartbeatSender) run(done chan struct{}) {
+	defer s.wg.Done()
+
+	timer := s.registry.clock().NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-timer.C():
+		}
+
+		s.beat()
+		timer.Reset(s.nextInterval())
+	}
+}
+
+// nextInterval returns the interval with the jitter applied
+func (s *HeartbeatSender) nextInterval() time.Duration {
+	if s.Jitter <= 0 {
+		return s.interval
+	}
+
+	spread := float64(s.interval) * s.Jitter
+	return s.interval + time.Duration((rand.Float64()*2-1)*spread)
+}
+
+// beat sends one heartbeat to every peer. the peers are written to at the
+// same time so one that does not answer does not hold up the others
+func (s *HeartbeatSender) beat() {
+	var wg sync.WaitGroup
+	for _, p := range s.currentPeers() {
+		wg.Add(1)
+		go func(p *peer) {
+			defer wg.Done()
+
+			err := s.send(p)
+			if err != nil && !errors.Is(err, errBackingOff) {
+				s.report(p.id, err)
+			}
+		}(p)
+	}
+	wg.Wait()
+}
+
+// currentPeers returns a peer for every node of the registry, peers whose
+// node went away are closed
+func (s *HeartbeatSender) currentPeers() []*peer {
+	s.registry.mu.Lock()
+	addresses := make(map[string]string, len(s.registry.nodes))
+	for id, node := range s.registry.nodes {
+		if id != s.id {
+			addresses[id] = node.address
+		}
+	}
+	s.registry.mu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, p := range s.peers {
+		if address, ok := addresses[id]; !ok || address != p.address {
+			p.close()
+			delete(s.peers, id)
+		}
+	}
+
+	peers := make([]*peer, 0, len(addresses))
+	for id, address := range addresses {
+		p, ok := s.peers[id]
+		if !ok {
+			p = &peer{id: id, address: address}
+			s.peers[id] = p
+		}
+		peers = append(peers, p)
+	}
+
+	return peers
+}
+
+func (s *HeartbeatSender) report(peerID string, err error) {
+	if s.OnError != nil {
+		s.OnError(peerID, err)
+		return
+	}
+
+	log.Printf("heartbeat: sending to %s failed: %v", peerID, err)
+}
+
+// send writes one heartbeat to p, dialing it first if there is no open
+// connection. the interval bounds the dial and the write.
+func (s *HeartbeatSender) send(p *peer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.conn == nil && s.registry.now().Before(p.nextDial) {
+		return errBackingOff
+	}
+	if s.Transport == UDP {
+		return s.sendUDP(p)
+	}
+
+	if p.conn == nil {
+		conn, err := s.dial(p)
+		if err != nil {
+			p.failed(s.interval, s.registry.now())
+			return err
+		}
+		p.conn = conn
+		p.backoff = 0
+	}
+
+	_, payload, err := s.payload()
+	if err != nil {
+		return err
+	}
+
+	line := beatLine(s.id, payload)
+	if s.Key != nil {
+		line = hex.EncodeToString(seal(s.Key, []byte(line), s.registry.now()))
+	}
+
+	p.conn.SetWriteDeadline(time.Now().Add(s.interval))
+	_, err = fmt.Fprintf(p.conn, "%s\n", line)
+	if err != nil {
+		p.conn.Close()
+		p.conn = nil
+		p.failed(s.interval, s.registry.now())
+		return err
+	}
+
+	return nil
+}
+
+// onAck adds a hook called for every acknowledged UDP beat
+func (s *HeartbeatSender) onAck(hook func(peerID string, sent time.Time)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ackHooks = append(s.ackHooks, hook)
+}
+
+func (s *HeartbeatSender) acked(peerID string, sent time.Time) {
+	s.mu.Lock()
+	hooks := s.ackHooks
+	s.mu.Unlock()
+
+	for _, hook := range hooks {
+		hook(peerID, sent)
+	}
+}
+
+// payload returns the term and the encoded payload to send with the next
+// beat, 0 and nil without a Payload function
+func (s *HeartbeatSender) payload() (uint64, []byte, error) {
+	if s.Payload == nil {
+		return 0, nil, nil
+	}
+
+	p := s.Payload()
+	data, err := p.marshal()
+	return p.Term, data, err
+}
+
+// dial opens a TCP connection to p, with TLS the server has to prove it is
+// the node p names
+func (s *HeartbeatSender) dial(p *peer) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: s.interval}
+	if s.TLSConfig == nil {
+		return dialer.Dial("tcp", p.address)
+	}
+
+	cfg := s.TLSConfig.Clone()
+	cfg.ServerName = p.id
+	return tls.DialWithDialer(dialer, "tcp", p.address, cfg)
+}
+
+// failed doubles the time to wait from now before the next dial, starting
+// at the heartbeat interval
+func (p *peer) failed(interval time.Duration, now time.Time) {
+	if p.backoff == 0 {
+		p.backoff = interval
+	} else if p.backoff *= 2; p.backoff > maxBackoff {
+		p.backoff = maxBackoff
+	}
+	p.nextDial = now.Add(p.backoff)
+}
+
+func (p *peer) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+}
@@ -0,0 +1,122 @@
+package heartbeat
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// listen accepts heartbeats for hb on an ephemeral port until the test
+// ends, the accepted connections are counted
+func listen(t *testing.T, hb *HeartbeatClient) (string, *atomic.Int32) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	var accepted atomic.Int32
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			go hb.handleHeartbeat(conn)
+		}
+	}()
+
+	return ln.Addr().String(), &accepted
+}
+
+func lastBeat(hb *HeartbeatClient, id string) time.Time {
+	hb.mu.Lock()
+	node := hb.nodes[id]
+	hb.mu.Unlock()
+
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	return node.LastBeat
+}
+
+func TestSenderMutualMonitoring(t *testing.T) {
+	a := NewHeartbeatClient(time.Second, 10*time.Millisecond)
+	b := NewHeartbeatClient(time.Second, 10*time.Millisecond)
+
+	addrA, acceptedA := listen(t, a)
+	addrB, acceptedB := listen(t, b)
+	a.AddNode("b", addrB)
+	b.AddNode("a", addrA)
+
+	senderA := NewHeartbeatSender("a", a, 10*time.Millisecond)
+	senderB := NewHeartbeatSender("b", b, 10*time.Millisecond)
+	senderA.Start()
+	senderB.Start()
+	defer senderA.Stop()
+	defer senderB.Stop()
+
+	time.Sleep(200 * time.Millisecond)
+
+	if since := time.Since(lastBeat(b, "a")); since > 100*time.Millisecond {
+		t.Errorf("expected b to hear from a recently, last beat %v ago", since)
+	}
+	if since := time.Since(lastBeat(a, "b")); since > 100*time.Millisecond {
+		t.Errorf("expected a to hear from b recently, last beat %v ago", since)
+	}
+
+	if n := acceptedA.Load(); n != 1 {
+		t.Errorf("expected b to keep a single connection to a, got %d", n)
+	}
+	if n := acceptedB.Load(); n != 1 {
+		t.Errorf("expected a to keep a single connection to b, got %d", n)
+	}
+}
+
+func TestSenderReconnects(t *testing.T) {
+	hb := NewHeartbeatClient(time.Second, 10*time.Millisecond)
+	hb.AddNode("peer", "127.0.0.1:1")
+
+	var mu sync.Mutex
+	failures := map[string]int{}
+
+	sender := NewHeartbeatSender("self", hb, 10*time.Millisecond)
+	sender.OnError = func(peerID string, err error) {
+		mu.Lock()
+		failures[peerID]++
+		mu.Unlock()
+	}
+	sender.Start()
+	defer sender.Stop()
+
+	time.Sleep(100 * time.Millisecond)
+
+	mu.Lock()
+	n := failures["peer"]
+	mu.Unlock()
+	if n == 0 {
+		t.Fatal("expected the failed dials to be reported")
+	}
+	// the dials back off, 10ms, 20ms, 40ms, ...
+	if n > 5 {
+		t.Errorf("expected the dials to back off, got %d failures", n)
+	}
+
+	// the peer comes up at a new address
+	peer := NewHeartbeatClient(time.Second, 10*time.Millisecond)
+	peer.AddNode("self", "")
+	addr, _ := listen(t, peer)
+	hb.AddNode("peer", addr)
+
+	deadline := time.Now().Add(time.Second)
+	for lastBeat(peer, "self").IsZero() {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the sender to reach the peer at its new address")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
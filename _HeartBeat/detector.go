@@ -0,0 +1,125 @@
+package heartbeat
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+// Strategy decides when a node stops being considered alive
+type Strategy int
+
+const (
+	// TimeoutStrategy marks a node dead once no heartbeat arrived for the
+	// timeout of the HeartbeatClient
+	TimeoutStrategy Strategy = iota
+	// PhiAccrualStrategy marks a node dead once its suspicion level rises
+	// above the phi threshold
+	PhiAccrualStrategy
+)
+
+const (
+	// DefaultPhiThreshold makes a wrong suspicion about one in 10^8
+	DefaultPhiThreshold = 8.0
+	// defaultWindowSize is the number of inter-arrival times kept per node
+	defaultWindowSize = 100
+	// minStdDevFraction floors the standard deviation at that fraction of
+	// the mean, perfectly regular beats would otherwise make any delay fatal
+	minStdDevFraction = 0.1
+)
+
+var ErrUnknownNode = errors.New("unknown node")
+
+// arrivalWindow is a sliding window over the last inter-arrival times of
+// a node's heartbeats
+type arrivalWindow struct {
+	intervals []float64
+	next      int
+	sum       float64
+	sumSq     float64
+}
+
+func (w *arrivalWindow) add(interval time.Duration, size int) {
+	x := float64(interval)
+	if len(w.intervals) < size {
+		w.intervals = append(w.intervals, x)
+	} else {
+		old := w.intervals[w.next]
+		w.sum -= old
+		w.sumSq -= old * old
+		w.intervals[w.next] = x
+		w.next = (w.next + 1) % size
+	}
+	w.sum += x
+	w.sumSq += x * x
+}
+
+// phi returns the suspicion level after elapsed without a heartbeat, the
+// -log10 of the probability that a heartbeat still arrives later. the
+// inter-arrival times are assumed normally distributed and the normal cdf
+// is approximated with a logistic function.
+func (w *arrivalWindow) phi(elapsed time.Duration) float64 {
+	n := float64(len(w.intervals))
+	mean := w.sum / n
+	stdDev := math.Sqrt(math.Max(w.sumSq/n-mean*mean, 0))
+	stdDev = math.Max(stdDev, mean*minStdDevFraction)
+
+	y := (float64(elapsed) - mean) / stdDev
+	e := math.Exp(-y * (1.5976 + 0.070566*y*y))
+	if float64(elapsed) > mean {
+		return -math.Log10(e / (1 + e))
+	}
+	return -math.Log10(1 - 1/(1+e))
+}
+
+// UseTimeout switches to marking nodes dead after the fixed timeout
+func (hm *HeartbeatClient) UseTimeout() {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+	hm.strategy = TimeoutStrategy
+}
+
+// UsePhiAccrual switches to marking nodes dead once their suspicion level
+// exceeds threshold. a node is judged by the timeout until two heartbeats
+// gave its first inter-arrival time.
+func (hm *HeartbeatClient) UsePhiAccrual(threshold float64) {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+	hm.strategy = PhiAccrualStrategy
+	hm.phiThreshold = threshold
+}
+
+// Suspicion returns the phi suspicion level of node id, 0 while too few
+// heartbeats arrived to judge it
+func (hm *HeartbeatClient) Suspicion(id string) (float64, error) {
+	hm.mu.Lock()
+	node, ok := hm.nodes[id]
+	hm.mu.Unlock()
+	if !ok {
+		return 0, ErrUnknownNode
+	}
+
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	return node.suspicion(hm.now()), nil
+}
+
+func (c *Client) suspicion(now time.Time) float64 {
+	if len(c.arrivals.intervals) == 0 {
+		return 0
+	}
+	return c.arrivals.phi(now.Sub(c.LastBeat))
+}
+
+// alive judges node at now with the strategy of hm, hm.mu and node.mu are
+// held
+func (hm *HeartbeatClient) alive(node *Client, now time.Time) bool {
+	if hm.strategy == PhiAccrualStrategy && len(node.arrivals.intervals) > 0 {
+		return node.suspicion(now) <= hm.phiThreshold
+	}
+	last := node.LastBeat
+	if last.IsZero() {
+		last = node.addedAt
+	}
+	return now.Sub(last) <= hm.timeout
+}
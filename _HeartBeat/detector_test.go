@@ -0,0 +1,107 @@
+package heartbeat
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// beats records heartbeats from id at the given intervals, the last one
+// arriving now
+func beats(hb *HeartbeatClient, id string, intervals ...time.Duration) {
+	node := hb.nodes[id]
+
+	var total time.Duration
+	for _, d := range intervals {
+		total += d
+	}
+
+	at := time.Now().Add(-total)
+	node.LastBeat = at
+	for _, d := range intervals {
+		at = at.Add(d)
+		node.arrivals.add(at.Sub(node.LastBeat), defaultWindowSize)
+		node.LastBeat = at
+	}
+}
+
+func TestPhiRises(t *testing.T) {
+	var w arrivalWindow
+	for i := 0; i < 20; i++ {
+		w.add(time.Second+time.Duration(i%5)*50*time.Millisecond, defaultWindowSize)
+	}
+
+	previous := -1.0
+	for _, elapsed := range []time.Duration{0, time.Second, 1500 * time.Millisecond, 2 * time.Second, 3 * time.Second} {
+		phi := w.phi(elapsed)
+		if phi < previous {
+			t.Errorf("expected phi to rise with the time since the last beat, got %f after %f", phi, previous)
+		}
+		previous = phi
+	}
+
+	if phi := w.phi(time.Second); phi > 1 {
+		t.Errorf("expected little suspicion at the mean interval, got %f", phi)
+	}
+	if phi := w.phi(3 * time.Second); phi < DefaultPhiThreshold {
+		t.Errorf("expected a node silent for three intervals to be suspected, got %f", phi)
+	}
+}
+
+func TestArrivalWindowSlides(t *testing.T) {
+	var w arrivalWindow
+	for i := 0; i < 10; i++ {
+		w.add(time.Hour, 5)
+	}
+	for i := 0; i < 5; i++ {
+		w.add(time.Second, 5)
+	}
+
+	if len(w.intervals) != 5 || w.sum != float64(5*time.Second) {
+		t.Errorf("expected only the last 5 intervals to count, got %d summing to %v", len(w.intervals), time.Duration(w.sum))
+	}
+}
+
+func TestPhiAccrualStrategy(t *testing.T) {
+	hb := NewHeartbeatClient(100*time.Millisecond, time.Second)
+	hb.AddNode("jittery", "localhost:9001")
+	hb.AddNode("silent", "localhost:9002")
+
+	// beats every 100ms give or take 60ms, the last one 150ms ago
+	beats(hb, "jittery", 40*time.Millisecond, 160*time.Millisecond, 100*time.Millisecond, 60*time.Millisecond, 140*time.Millisecond, 150*time.Millisecond)
+	beats(hb, "silent", 100*time.Millisecond, 100*time.Millisecond, 100*time.Millisecond)
+	hb.nodes["jittery"].LastBeat = time.Now().Add(-150 * time.Millisecond)
+	hb.nodes["silent"].LastBeat = time.Now().Add(-time.Second)
+
+	hb.checkNodes()
+	if hb.nodes["jittery"].IsAlive {
+		t.Error("expected the timeout to mark the jittery node dead")
+	}
+
+	hb.UsePhiAccrual(DefaultPhiThreshold)
+	hb.nodes["jittery"].IsAlive = true
+	hb.checkNodes()
+	if !hb.nodes["jittery"].IsAlive {
+		t.Error("expected the jittery node to stay alive under phi accrual")
+	}
+	if hb.nodes["silent"].IsAlive {
+		t.Error("expected the silent node to be marked dead")
+	}
+
+	jittery, err := hb.Suspicion("jittery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	silent, err := hb.Suspicion("silent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jittery >= silent {
+		t.Errorf("expected the silent node to be more suspect, got %f and %f", jittery, silent)
+	}
+
+	_, err = hb.Suspicion("missing")
+	if !errors.Is(err, ErrUnknownNode) {
+		t.Errorf("expected an unknown node error, got %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+package heartbeat
+
+import "time"
+
+// EventType is the kind of change in a node's membership
+type EventType int
+
+const (
+	// Joined is sent when a node is added
+	Joined EventType = iota
+	// Suspect is sent when the failure detector stops trusting a node
+	Suspect
+	// Dead is sent when a suspected node stayed silent for another timeout
+	Dead
+	// Recovered is sent when a suspected or dead node beats again
+	Recovered
+	// Left is sent when a node is removed
+	Left
+)
+
+func (t EventType) String() string {
+	switch t {
+	case Joined:
+		return "joined"
+	case Suspect:
+		return "suspect"
+	case Dead:
+		return "dead"
+	case Recovered:
+		return "recovered"
+	case Left:
+		return "left"
+	}
+	return "unknown"
+}
+
+// Event is a change in the membership of a node
+type Event struct {
+	Type    EventType
+	NodeID  string
+	Address string
+	// LastBeat is when the last heartbeat of the node arrived, zero if it
+	// never beat
+	LastBeat time.Time
+	// Time is when the change was noticed
+	Time time.Time
+}
+
+// nodeState is what the monitor currently believes about a node
+type nodeState int
+
+const (
+	stateAlive nodeState = iota
+	stateSuspect
+	stateDead
+)
+
+// Subscribe returns a channel receiving every membership event from now
+// on, the events of a node arrive in the order they happened. the returned
+// function ends the subscription and closes the channel.
+func (hm *HeartbeatClient) Subscribe() (<-chan Event, func()) {
+	return hm.events.Subscribe()
+}
+
+// emit sends an event about node to every subscriber, hm.mu and node.mu
+// are held so the events of a node are queued in order
+func (hm *HeartbeatClient) emit(t EventType, node *Client, now time.Time) {
+	hm.events.Publish(Event{
+		Type:     t,
+		NodeID:   node.Id,
+		Address:  node.address,
+		LastBeat: node.LastBeat,
+		Time:     now,
+	})
+}
@@ -0,0 +1,132 @@
+package heartbeat
+
+import (
+	"testing"
+	"time"
+
+	"github.com/EncrypteID/Replicate-Partition-Next-Generation-Systems/_Clock"
+)
+
+func next(t *testing.T, events <-chan Event) Event {
+	t.Helper()
+
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("expected an event")
+		return Event{}
+	}
+}
+
+func TestMembershipEvents(t *testing.T) {
+	var timeouts int
+	hb := New(50*time.Millisecond, func() { timeouts++ })
+	fake := clock.NewFake(time.Unix(0, 0))
+	hb.Clock = fake
+
+	events, cancel := hb.Subscribe()
+	defer cancel()
+
+	hb.AddNode("node1", "localhost:9001")
+	e := next(t, events)
+	if e.Type != Joined || e.NodeID != "node1" || e.Address != "localhost:9001" {
+		t.Errorf("expected node1 to join, got %+v", e)
+	}
+
+	hb.heard("node1", nil)
+	fake.Advance(40 * time.Millisecond)
+	hb.checkNodes()
+	fake.Advance(20 * time.Millisecond)
+	hb.checkNodes()
+	e = next(t, events)
+	if e.Type != Suspect || e.LastBeat.IsZero() || e.Time.Before(e.LastBeat) {
+		t.Errorf("expected node1 to be suspected, got %+v", e)
+	}
+	if timeouts != 1 {
+		t.Errorf("expected the timeout callback once, got %d", timeouts)
+	}
+
+	// still suspected, not dead before another timeout passed
+	fake.Advance(40 * time.Millisecond)
+	hb.checkNodes()
+	fake.Advance(10 * time.Millisecond)
+	hb.checkNodes()
+	e = next(t, events)
+	if e.Type != Dead {
+		t.Errorf("expected node1 to be declared dead, got %+v", e)
+	}
+
+	hb.heard("node1", nil)
+	e = next(t, events)
+	if e.Type != Recovered || !hb.nodes["node1"].IsAlive {
+		t.Errorf("expected node1 to recover, got %+v", e)
+	}
+
+	hb.RemoveNode("node1")
+	e = next(t, events)
+	if e.Type != Left || e.NodeID != "node1" {
+		t.Errorf("expected node1 to leave, got %+v", e)
+	}
+
+	select {
+	case e := <-events:
+		t.Errorf("expected no more events, got %+v", e)
+	default:
+	}
+}
+
+func TestNewNodeNotSuspectedBeforeTimeout(t *testing.T) {
+	hb := New(50*time.Millisecond, nil)
+	fake := clock.NewFake(time.Unix(1000, 0))
+	hb.Clock = fake
+
+	events, cancel := hb.Subscribe()
+	defer cancel()
+
+	hb.AddNode("node1", "localhost:9001")
+	e := next(t, events)
+	if e.Type != Joined {
+		t.Errorf("expected node1 to join, got %+v", e)
+	}
+	added := e.Time
+
+	// a node that never beat gets the whole timeout from when it was added
+	hb.checkNodes()
+	fake.Advance(40 * time.Millisecond)
+	hb.checkNodes()
+	fake.Advance(20 * time.Millisecond)
+	hb.checkNodes()
+	e = next(t, events)
+	if e.Type != Suspect || !e.LastBeat.IsZero() || e.Time.Sub(added) != 60*time.Millisecond {
+		t.Errorf("expected node1 to be suspected 60ms after it was added, got %+v", e)
+	}
+}
+
+func TestEventsOrderedForSlowSubscribers(t *testing.T) {
+	hb := NewHeartbeatClient(time.Millisecond, time.Second)
+
+	events, cancel := hb.Subscribe()
+
+	// nobody reads while the events are sent, they queue up
+	for i := 0; i < 100; i++ {
+		hb.AddNode("node1", "localhost:9001")
+		hb.RemoveNode("node1")
+	}
+
+	for i := 0; i < 200; i++ {
+		e := next(t, events)
+		want := Joined
+		if i%2 == 1 {
+			want = Left
+		}
+		if e.Type != want {
+			t.Fatalf("expected event %d to be %v, got %v", i, want, e.Type)
+		}
+	}
+
+	cancel()
+	if _, ok := <-events; ok {
+		t.Error("expected the channel to be closed after cancelling")
+	}
+}
@@ -1,12 +1,24 @@
 package heartbeat
 
 import (
-	"fmt"
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
 	"net"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/EncrypteID/Replicate-Partition-Next-Generation-Systems/_Clock"
+	"github.com/EncrypteID/Replicate-Partition-Next-Generation-Systems/_PubSub"
 )
 
+// defaultCheckPeriod is how often the nodes are checked when the
+// constructor is given no usable period
+const defaultCheckPeriod = time.Second
+
 type Client struct {
 	Id        string
 	address   string
@@ -14,13 +26,67 @@ type Client struct {
 	IsAlive   bool
 	mu        sync.Mutex
 	NewClient error
+	arrivals  arrivalWindow
+	state     nodeState
+	// suspectedAt is when the node became suspect
+	suspectedAt time.Time
+	// incarnation and seq identify the last UDP beat accepted
+	incarnation uint64
+	seq         uint64
+	stats       PeerStats
+	payload     *PeerPayload
+	// lastAcked is when this node last acknowledged a UDP beat of the node
+	lastAcked time.Time
+	// addedAt is when the node was added, the timeout of a node that never
+	// beat counts from it
+	addedAt time.Time
 }
 
 type HeartbeatClient struct {
-	nodes       map[string]*Client
-	timeout     time.Duration
-	checkPeriod time.Duration
-	mu          sync.Mutex
+	// ListenAddress is where Start listens for heartbeats
+	ListenAddress string
+	// Transport is what Start listens with, UDP listens for TCP heartbeats
+	// as well
+	Transport Transport
+	// Logger receives the errors of the listener, the standard logger is
+	// used when it is nil
+	Logger Logger
+	// Key is the shared key heartbeats must be sealed with, beats are not
+	// authenticated when it is nil
+	Key []byte
+	// MaxClockSkew is how old or early a sealed heartbeat may be,
+	// DefaultMaxClockSkew when 0
+	MaxClockSkew time.Duration
+	// TLSConfig makes the TCP listener require mutual TLS, a heartbeat is
+	// only accepted for the node IDs the client certificate names
+	TLSConfig *tls.Config
+	// Clock times the heartbeats and drives the monitor, the senders and
+	// leases of this registry use it too. it is the real clock when nil
+	// and must be set before the first node is added. network deadlines
+	// always use the real clock.
+	Clock clock.Clock
+
+	nodes        map[string]*Client
+	timeout      time.Duration
+	checkPeriod  time.Duration
+	strategy     Strategy
+	phiThreshold float64
+	timeoutFunc  func()
+	events       pubsub.Hub[Event]
+	mu           sync.Mutex
+	// term is the highest term of the UDP beats received
+	term uint64
+
+	listener   net.Listener
+	packetConn net.PacketConn
+	cancel     context.CancelFunc
+	wg         sync.WaitGroup
+	connMu     sync.Mutex
+	conns      map[net.Conn]struct{}
+	closing    bool
+
+	nonces   nonceCache
+	rejected atomic.Uint64
 }
 
 // New creates new Heartbeat with specified duration. timeoutFunc will be called
@@ -28,22 +94,34 @@ type HeartbeatClient struct {
 // call Beat() to reactivate Heartbeat.
 func New(timeout time.Duration, timeoutFunc func()) *HeartbeatClient {
 	hb := &HeartbeatClient{
-		nodes:       map[string]*Client{},
-		timeout:     timeout,
-		checkPeriod: time.Duration(1),
-		mu:          sync.Mutex{},
+		nodes:        map[string]*Client{},
+		timeout:      timeout,
+		checkPeriod:  checkPeriodOrDefault(timeout / 2),
+		phiThreshold: DefaultPhiThreshold,
+		timeoutFunc:  timeoutFunc,
+		mu:           sync.Mutex{},
 	}
 	return hb
 }
 
 func NewHeartbeatClient(timeout, checkPeriod time.Duration) *HeartbeatClient {
 	return &HeartbeatClient{
-		nodes:       make(map[string]*Client),
-		timeout:     timeout,
-		checkPeriod: checkPeriod,
+		nodes:        make(map[string]*Client),
+		timeout:      timeout,
+		checkPeriod:  checkPeriodOrDefault(checkPeriod),
+		phiThreshold: DefaultPhiThreshold,
 	}
 }
 
+// checkPeriodOrDefault returns period, or defaultCheckPeriod when it is
+// not positive, a ticker cannot run without one
+func checkPeriodOrDefault(period time.Duration) time.Duration {
+	if period <= 0 {
+		return defaultCheckPeriod
+	}
+	return period
+}
+
 // func (c *HeartbeatClient) Get(context context.Context, node []string) (*HeartbeatClient, error){
 // 	get := &Client{}
 // 	err := c.Get(context, node)
@@ -57,66 +135,159 @@ func (hb *HeartbeatClient) Beat() {
 	hb.checkPeriod.Hours()
 }
 
+// AddNode registers a node to monitor, a node that is already known only
+// gets its address updated
 func (hm *HeartbeatClient) AddNode(id, address string) {
 	hm.mu.Lock()
 	defer hm.mu.Unlock()
-	hm.nodes[id] = &Client{
+
+	if node, exists := hm.nodes[id]; exists {
+		node.mu.Lock()
+		node.address = address
+		node.mu.Unlock()
+		return
+	}
+
+	now := hm.now()
+	node := &Client{
 		Id:      id,
 		address: address,
 		IsAlive: true,
+		addedAt: now,
 	}
+	hm.nodes[id] = node
+	hm.emit(Joined, node, now)
 }
 
+// RemoveNode stops monitoring a node
+func (hm *HeartbeatClient) RemoveNode(id string) {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+
+	node, exists := hm.nodes[id]
+	if !exists {
+		return
+	}
+	delete(hm.nodes, id)
+
+	node.mu.Lock()
+	hm.emit(Left, node, hm.now())
+	node.mu.Unlock()
+}
+
+// StartHeartbeatListener listens on port and blocks forever.
+//
+// Deprecated: use Start, it can be stopped and reports the bound address.
 func (hm *HeartbeatClient) StartHeartbeatListener(port string) {
 	ln, err := net.Listen("tcp", port)
 	if err != nil {
-		fmt.Println("Error starting heartbeat listener:", err)
+		hm.logf("heartbeat: starting the listener failed: %v", err)
 		return
 	}
 	defer ln.Close()
 
-	for {
-		conn, err := ln.Accept()
+	hm.serve(ln)
+}
+
+// handleHeartbeat records every heartbeat sent over conn, a sender keeps
+// its connection open and writes one line per beat
+func (hm *HeartbeatClient) handleHeartbeat(conn net.Conn) {
+	defer conn.Close()
+
+	var identities []string
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		tlsConn.SetDeadline(time.Now().Add(handshakeTimeout))
+		err := tlsConn.Handshake()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
 		if err != nil {
-			fmt.Println("Error accepting connection:", err)
-			continue
+			hm.reject(conn.RemoteAddr().String(), err)
+			return
 		}
+		tlsConn.SetDeadline(time.Time{})
+		identities = peerIdentities(tlsConn.ConnectionState())
+	}
 
-		go hm.handleHeartbeat(conn)
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		id, payload, err := hm.authenticate(strings.TrimSpace(scanner.Text()), identities)
+		if errors.Is(err, ErrMalformedPayload) {
+			hm.logf("heartbeat: dropping a heartbeat from %s: %v", conn.RemoteAddr(), err)
+			continue
+		}
+		if err != nil {
+			hm.reject(conn.RemoteAddr().String(), err)
+			continue
+		}
+		hm.heard(id, payload)
 	}
 }
 
-func (hm *HeartbeatClient) handleHeartbeat(conn net.Conn) {
-	defer conn.Close()
-	var id string
-	fmt.Fscanf(conn, "%s\n", &id)
-
+// heard records a heartbeat of node id that arrived over TCP, payload is
+// the encoded payload it carried
+func (hm *HeartbeatClient) heard(id string, payload []byte) {
 	hm.mu.Lock()
+	defer hm.mu.Unlock()
+
 	if node, exists := hm.nodes[id]; exists {
+		now := hm.now()
 		node.mu.Lock()
-		node.LastBeat = time.Now()
-		node.IsAlive = true
+		hm.storePayload(node, payload, now)
+		hm.beat(node, now)
 		node.mu.Unlock()
 	}
-	hm.mu.Unlock()
 }
 
-func (hm *HeartbeatClient) StartMonitoring() {
-	ticker := time.NewTicker(hm.checkPeriod)
-	for range ticker.C {
-		hm.checkNodes()
+// beat records a heartbeat of node arriving at now, hm.mu and node.mu are
+// held
+func (hm *HeartbeatClient) beat(node *Client, now time.Time) {
+	if !node.LastBeat.IsZero() {
+		node.arrivals.add(now.Sub(node.LastBeat), defaultWindowSize)
+	}
+	node.LastBeat = now
+	node.IsAlive = true
+	if node.state != stateAlive {
+		node.state = stateAlive
+		hm.emit(Recovered, node, now)
 	}
 }
 
+// StartMonitoring checks the nodes every check period and blocks forever.
+//
+// Deprecated: use Start, it can be stopped.
+func (hm *HeartbeatClient) StartMonitoring() {
+	hm.monitor(context.Background())
+}
+
+// checkNodes suspects the nodes the failure detector no longer trusts and
+// declares the ones that stayed silent for another timeout dead
 func (hm *HeartbeatClient) checkNodes() {
 	hm.mu.Lock()
-	defer hm.mu.Unlock()
 
+	var suspected bool
+	now := hm.now()
 	for _, node := range hm.nodes {
 		node.mu.Lock()
-		if time.Since(node.LastBeat) > hm.timeout {
+		if !hm.alive(node, now) {
 			node.IsAlive = false
+
+			switch {
+			case node.state == stateAlive:
+				node.state = stateSuspect
+				node.suspectedAt = now
+				hm.emit(Suspect, node, now)
+				suspected = true
+			case node.state == stateSuspect && now.Sub(node.suspectedAt) >= hm.timeout:
+				node.state = stateDead
+				hm.emit(Dead, node, now)
+			}
 		}
 		node.mu.Unlock()
 	}
+	hm.mu.Unlock()
+
+	if suspected && hm.timeoutFunc != nil {
+		hm.timeoutFunc()
+	}
 }
@@ -1,6 +1,7 @@
 package heartbeat
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"testing"
@@ -40,19 +41,30 @@ func TestCheckNodes(t *testing.T) {
 
 func TestHandleHeartbeat(t *testing.T) {
 	hb := New(5*time.Second, nil)
+	hb.ListenAddress = "127.0.0.1:0"
 	hb.AddNode("node1", "localhost:9001")
+	hb.nodes["node1"].IsAlive = false
 
-	// Mock heartbeat
-	go func() {
-		ln, _ := net.Listen("tcp", ":9000")
-		conn, _ := ln.Accept()
-		fmt.Fprintf(conn, "node1\n")
-		conn.Close()
-	}()
+	err := hb.Start(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hb.Stop()
 
-	hb.StartHeartbeatListener(":9000")
+	conn, err := net.Dial("tcp", hb.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(conn, "node1\n")
+	conn.Close()
 
-	time.Sleep(1 * time.Second)
+	deadline := time.Now().Add(time.Second)
+	for lastBeat(hb, "node1").IsZero() {
+		if time.Now().After(deadline) {
+			t.Fatal("expected a heartbeat from node1")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
 
 	if !hb.nodes["node1"].IsAlive {
 		t.Errorf("expected node1 to be alive")
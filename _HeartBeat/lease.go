@@ -0,0 +1,160 @@
+package heartbeat
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/EncrypteID/Replicate-Partition-Next-Generation-Systems/_Clock"
+)
+
+var ErrLeaseNeedsUDP = errors.New("leases need the acknowledgements of the UDP transport")
+
+// LeaseConfig is shared by the leader holding a lease and the nodes
+// granting it, they must agree on it
+type LeaseConfig struct {
+	// Duration is how long an acknowledged heartbeat extends the lease
+	Duration time.Duration
+	// MaxDrift bounds how much faster or slower than real time any clock
+	// of the cluster runs, 0.01 allows one percent
+	MaxDrift float64
+}
+
+// holderDuration is how long the leader counts a lease from the moment it
+// sent the acknowledged beat. a clock running fast by MaxDrift makes this
+// end before the grantors' Duration of real time has passed.
+func (c LeaseConfig) holderDuration() time.Duration {
+	return time.Duration(float64(c.Duration) * (1 - c.MaxDrift))
+}
+
+// grantorDuration is how long a node that acknowledged a beat must assume
+// the leader still holds its lease, a clock running slow by MaxDrift makes
+// this last at least Duration of real time
+func (c LeaseConfig) grantorDuration() time.Duration {
+	return time.Duration(float64(c.Duration) * (1 + c.MaxDrift))
+}
+
+// Lease lets a leader serve reads locally without a quorum round trip.
+// every UDP heartbeat a majority of the voters acknowledges extends the
+// lease to Duration, shortened by the drift bound, after the beat was
+// sent. since a beat is sent before it is acknowledged, the lease ends
+// before the LeaseBarrier of any node that acknowledged it, so a new
+// leader that waits for the barriers of a majority cannot overlap it.
+// nodes only acknowledge beats of the highest term they saw, the leader
+// sends its term in the Payload of its beats.
+type Lease struct {
+	cfg   LeaseConfig
+	clock clock.Clock
+	// voters are the peers whose acknowledgements count
+	voters map[string]bool
+	// needed is the number of voters besides the leader a majority takes
+	needed int
+
+	mu sync.Mutex
+	// acked is the send time of the latest acknowledged beat per peer
+	acked map[string]time.Time
+	// revoked is when the lease was given up, earlier beats do not count
+	revoked time.Time
+}
+
+// NewLease returns the lease of a leader sending its heartbeats with
+// sender. voters are the IDs of the voting nodes, the leader included, the
+// acknowledgements of other nodes do not count. the lease is timed with
+// the clock of the sender's registry.
+func NewLease(sender *HeartbeatSender, voters []string, cfg LeaseConfig) (*Lease, error) {
+	if sender.Transport != UDP {
+		return nil, ErrLeaseNeedsUDP
+	}
+
+	l := &Lease{
+		cfg:    cfg,
+		clock:  sender.registry.clock(),
+		voters: map[string]bool{},
+		acked:  map[string]time.Time{},
+	}
+	for _, id := range voters {
+		l.voters[id] = true
+	}
+
+	// a majority is half the voters plus one, the leader counts itself
+	l.needed = len(l.voters)/2 + 1
+	if l.voters[sender.id] {
+		delete(l.voters, sender.id)
+		l.needed--
+	}
+	sender.onAck(l.ack)
+
+	return l, nil
+}
+
+func (l *Lease) ack(peerID string, sent time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.voters[peerID] || sent.Before(l.revoked) {
+		return
+	}
+	if sent.After(l.acked[peerID]) {
+		l.acked[peerID] = sent
+	}
+}
+
+// LeaseExpiry returns when the lease ends, it is in the past when the
+// leader holds no lease
+func (l *Lease) LeaseExpiry() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	needed := l.needed
+	if needed <= 0 {
+		return l.clock.Now().Add(l.cfg.holderDuration())
+	}
+	if len(l.acked) < needed {
+		return time.Time{}
+	}
+
+	sent := make([]time.Time, 0, len(l.acked))
+	for _, t := range l.acked {
+		sent = append(sent, t)
+	}
+	sort.Slice(sent, func(i, j int) bool { return sent[i].After(sent[j]) })
+
+	// the newest beat that a majority acknowledged
+	return sent[needed-1].Add(l.cfg.holderDuration())
+}
+
+// HasLease reports whether the leader may serve reads locally
+func (l *Lease) HasLease() bool {
+	return l.clock.Now().Before(l.LeaseExpiry())
+}
+
+// Revoke gives the lease up, when stepping down. only beats sent
+// afterwards count towards a new lease.
+func (l *Lease) Revoke() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.revoked = l.clock.Now()
+	clear(l.acked)
+}
+
+// LeaseBarrier returns until when leaderID may still hold a lease this
+// node helped to grant. a node taking over the leadership must not act as
+// leader before the barrier of every node in a majority has passed. it is
+// zero when this node never acknowledged a beat of leaderID.
+func (hm *HeartbeatClient) LeaseBarrier(leaderID string, cfg LeaseConfig) (time.Time, error) {
+	hm.mu.Lock()
+	node, ok := hm.nodes[leaderID]
+	hm.mu.Unlock()
+	if !ok {
+		return time.Time{}, ErrUnknownNode
+	}
+
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	if node.lastAcked.IsZero() {
+		return time.Time{}, nil
+	}
+	return node.lastAcked.Add(cfg.grantorDuration()), nil
+}
@@ -0,0 +1,165 @@
+package heartbeat
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/EncrypteID/Replicate-Partition-Next-Generation-Systems/_Clock"
+)
+
+func TestLeaseMajority(t *testing.T) {
+	cfg := LeaseConfig{Duration: time.Second, MaxDrift: 0.1}
+	registry := NewHeartbeatClient(time.Second, time.Second)
+	fake := clock.NewFake(time.Unix(1000, 0))
+	registry.Clock = fake
+	sender := NewHeartbeatSender("leader", registry, time.Second)
+	voters := []string{"leader", "a", "b", "c", "d"}
+
+	_, err := NewLease(sender, voters, cfg)
+	if !errors.Is(err, ErrLeaseNeedsUDP) {
+		t.Errorf("expected a lease without acks to be refused, got %v", err)
+	}
+
+	sender.Transport = UDP
+	l, err := NewLease(sender, voters, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := registry.now()
+	l.ack("a", now.Add(-300*time.Millisecond))
+	l.ack("observer", now)
+	if l.HasLease() {
+		t.Error("expected no lease with 2 of 5 voters")
+	}
+
+	l.ack("b", now.Add(-100*time.Millisecond))
+	l.ack("c", now.Add(-200*time.Millisecond))
+	if !l.HasLease() {
+		t.Fatal("expected a lease with 4 of 5 nodes")
+	}
+
+	// the third newest beat is the newest a majority acknowledged, the
+	// lease is cut short by the drift bound
+	want := now.Add(-200*time.Millisecond + 900*time.Millisecond)
+	if got := l.LeaseExpiry(); !got.Equal(want) {
+		t.Errorf("expected the lease to end at %v, got %v", want, got)
+	}
+
+	fake.Advance(time.Millisecond)
+	l.Revoke()
+	if l.HasLease() {
+		t.Error("expected no lease after revoking it")
+	}
+	l.ack("a", now)
+	l.ack("b", now)
+	if l.HasLease() {
+		t.Error("expected beats sent before revoking not to count")
+	}
+
+	single, err := NewLease(sender, []string{"leader"}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !single.HasLease() {
+		t.Error("expected a single node to hold its lease")
+	}
+}
+
+func TestLeaseFromHeartbeats(t *testing.T) {
+	cfg := LeaseConfig{Duration: 200 * time.Millisecond, MaxDrift: 0.05}
+
+	followers := make([]*HeartbeatClient, 2)
+	registry := NewHeartbeatClient(time.Second, time.Second)
+	for i := range followers {
+		followers[i] = startServer(t, func(hb *HeartbeatClient) {
+			hb.Transport = UDP
+			hb.Key = testKey
+		}, "leader")
+		registry.AddNode(string(rune('a'+i)), followers[i].Addr().String())
+	}
+
+	sender := NewHeartbeatSender("leader", registry, 10*time.Millisecond)
+	sender.Transport = UDP
+	sender.Key = testKey
+	lease, err := NewLease(sender, []string{"leader", "a", "b"}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lease.HasLease() {
+		t.Fatal("expected no lease before any heartbeat")
+	}
+
+	sender.Start()
+	defer sender.Stop()
+	waitFor(t, "the leader to get a lease", lease.HasLease)
+
+	// one follower is enough for a majority of three
+	followers[0].Stop()
+	time.Sleep(2 * cfg.Duration)
+	if !lease.HasLease() {
+		t.Error("expected the lease to be kept with a majority")
+	}
+
+	followers[1].Stop()
+	waitFor(t, "the lease to expire", func() bool { return !lease.HasLease() })
+	expiry := lease.LeaseExpiry()
+
+	// the follower that granted the lease last holds off a new leader
+	// until after it ended
+	barrier, err := followers[1].LeaseBarrier("leader", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !barrier.After(expiry) {
+		t.Errorf("expected the barrier %v to be after the lease expiry %v", barrier, expiry)
+	}
+}
+
+func TestLeaseTerms(t *testing.T) {
+	hb := NewHeartbeatClient(time.Second, time.Second)
+	for _, c := range []struct {
+		term    uint64
+		granted bool
+	}{
+		{1, true},
+		{2, true},
+		{1, false},
+		{2, true},
+		{0, false},
+	} {
+		if got := hb.grants(c.term); got != c.granted {
+			t.Errorf("expected a beat of term %d to be granted: %v", c.term, c.granted)
+		}
+	}
+}
+
+func TestLeaseDeposedLeader(t *testing.T) {
+	cfg := LeaseConfig{Duration: 200 * time.Millisecond, MaxDrift: 0.05}
+
+	registry := NewHeartbeatClient(time.Second, time.Second)
+	for _, id := range []string{"a", "b"} {
+		follower := startServer(t, func(hb *HeartbeatClient) {
+			hb.Transport = UDP
+		}, "leader")
+		// the follower heard from the leader of a later term
+		follower.grants(2)
+		registry.AddNode(id, follower.Addr().String())
+	}
+
+	sender := NewHeartbeatSender("leader", registry, 10*time.Millisecond)
+	sender.Transport = UDP
+	sender.Payload = func() Payload { return Payload{Term: 1} }
+	lease, err := NewLease(sender, []string{"leader", "a", "b"}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sender.Start()
+	defer sender.Stop()
+	time.Sleep(2 * cfg.Duration)
+	if lease.HasLease() {
+		t.Error("expected a leader of an earlier term not to get a lease")
+	}
+}
@@ -0,0 +1,230 @@
+package heartbeat
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/EncrypteID/Replicate-Partition-Next-Generation-Systems/_Clock"
+)
+
+// acceptBackoff is the pause after a failed accept
+const acceptBackoff = 10 * time.Millisecond
+
+var ErrStarted = errors.New("heartbeat monitor already started")
+
+// Logger receives the errors the listener and the monitor cannot return,
+// a *log.Logger is one
+type Logger interface {
+	Printf(format string, v ...any)
+}
+
+// Start listens for heartbeats on ListenAddress and checks the nodes every
+// check period until ctx is done or Stop is called. an address with port 0
+// binds to a free port, Addr returns it. once ctx is done Stop still has to
+// be called before starting again.
+func (hm *HeartbeatClient) Start(ctx context.Context) error {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+
+	if hm.listener != nil {
+		return ErrStarted
+	}
+
+	if hm.TLSConfig != nil && hm.Transport == UDP {
+		return ErrTLSOverUDP
+	}
+
+	ln, err := net.Listen("tcp", hm.ListenAddress)
+	if err != nil {
+		return err
+	}
+
+	var pc net.PacketConn
+	if hm.Transport == UDP {
+		// the same port as the TCP listener, which picked it if it was 0
+		pc, err = net.ListenPacket("udp", ln.Addr().String())
+		if err != nil {
+			ln.Close()
+			return err
+		}
+	}
+
+	hm.connMu.Lock()
+	hm.closing = false
+	hm.connMu.Unlock()
+
+	if hm.TLSConfig != nil {
+		ln = tls.NewListener(ln, hm.TLSConfig)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	hm.listener = ln
+	hm.packetConn = pc
+	hm.cancel = cancel
+
+	hm.wg.Add(3)
+	go func() {
+		defer hm.wg.Done()
+		hm.serve(ln)
+	}()
+	go func() {
+		defer hm.wg.Done()
+		hm.monitor(ctx)
+	}()
+	go func() {
+		defer hm.wg.Done()
+		<-ctx.Done()
+		hm.shutdown(ln, pc)
+	}()
+	if pc != nil {
+		hm.wg.Add(1)
+		go func() {
+			defer hm.wg.Done()
+			hm.serveUDP(pc)
+		}()
+	}
+
+	return nil
+}
+
+// Stop stops listening, closes the connections of the senders and waits
+// for the listener and the monitor to return
+func (hm *HeartbeatClient) Stop() {
+	hm.mu.Lock()
+	cancel := hm.cancel
+	hm.mu.Unlock()
+
+	if cancel == nil {
+		return
+	}
+	cancel()
+	hm.wg.Wait()
+
+	hm.mu.Lock()
+	hm.listener = nil
+	hm.packetConn = nil
+	hm.cancel = nil
+	hm.mu.Unlock()
+}
+
+// Addr returns the address the listener is bound to, nil when it is not
+// started. with UDP the datagrams arrive on the same port.
+func (hm *HeartbeatClient) Addr() net.Addr {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+
+	if hm.listener == nil {
+		return nil
+	}
+	return hm.listener.Addr()
+}
+
+// shutdown closes ln, pc and every connection accepted from ln
+func (hm *HeartbeatClient) shutdown(ln net.Listener, pc net.PacketConn) {
+	ln.Close()
+	if pc != nil {
+		pc.Close()
+	}
+
+	hm.connMu.Lock()
+	defer hm.connMu.Unlock()
+	hm.closing = true
+	for conn := range hm.conns {
+		conn.Close()
+	}
+}
+
+// serve accepts heartbeat connections until ln is closed
+func (hm *HeartbeatClient) serve(ln net.Listener) {
+	var conns sync.WaitGroup
+	defer conns.Wait()
+
+	for {
+		conn, err := ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			hm.logf("heartbeat: accepting a connection failed: %v", err)
+			time.Sleep(acceptBackoff)
+			continue
+		}
+
+		if !hm.track(conn, true) {
+			conn.Close()
+			return
+		}
+
+		conns.Add(1)
+		go func() {
+			defer conns.Done()
+			defer hm.track(conn, false)
+			hm.handleHeartbeat(conn)
+		}()
+	}
+}
+
+// track adds conn to the connections closed on shutdown, or removes it.
+// it reports false when the listener was closed in the meantime.
+func (hm *HeartbeatClient) track(conn net.Conn, add bool) bool {
+	hm.connMu.Lock()
+	defer hm.connMu.Unlock()
+
+	if !add {
+		delete(hm.conns, conn)
+		return true
+	}
+
+	if hm.closing {
+		return false
+	}
+	if hm.conns == nil {
+		hm.conns = map[net.Conn]struct{}{}
+	}
+	hm.conns[conn] = struct{}{}
+	return true
+}
+
+// monitor checks the nodes every check period until ctx is done
+func (hm *HeartbeatClient) monitor(ctx context.Context) {
+	ticker := hm.clock().NewTicker(hm.checkPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			hm.checkNodes()
+		}
+	}
+}
+
+func (hm *HeartbeatClient) clock() clock.Clock {
+	if hm.Clock != nil {
+		return hm.Clock
+	}
+	return clock.Real
+}
+
+func (hm *HeartbeatClient) now() time.Time {
+	return hm.clock().Now()
+}
+
+func (hm *HeartbeatClient) logf(format string, v ...any) {
+	logf(hm.Logger, format, v...)
+}
+
+// logf writes to logger, or the standard logger when it is nil
+func logf(logger Logger, format string, v ...any) {
+	if logger != nil {
+		logger.Printf(format, v...)
+		return
+	}
+	log.Printf(format, v...)
+}
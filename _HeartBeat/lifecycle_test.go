@@ -0,0 +1,181 @@
+package heartbeat
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/EncrypteID/Replicate-Partition-Next-Generation-Systems/_Clock"
+)
+
+// syncBuffer is a log output tests can read while the monitor writes
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func TestStartStop(t *testing.T) {
+	hb := NewHeartbeatClient(50*time.Millisecond, 10*time.Millisecond)
+	hb.ListenAddress = "127.0.0.1:0"
+	hb.AddNode("node1", "")
+
+	if hb.Addr() != nil {
+		t.Error("expected no address before starting")
+	}
+
+	err := hb.Start(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(hb.Start(context.Background()), ErrStarted) {
+		t.Error("expected a second start to fail")
+	}
+
+	events, cancel := hb.Subscribe()
+	defer cancel()
+
+	addr := hb.Addr().String()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "node1\n")
+
+	// the monitor runs on its own and suspects node1 once it falls silent
+	if e := next(t, events); e.Type != Suspect {
+		t.Errorf("expected node1 to be suspected, got %+v", e)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		hb.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("expected Stop to return while a sender is still connected")
+	}
+
+	if hb.Addr() != nil {
+		t.Error("expected no address after stopping")
+	}
+	_, err = net.Dial("tcp", addr)
+	if err == nil {
+		t.Error("expected the listener to be closed")
+	}
+
+	// it can be started again
+	err = hb.Start(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	hb.Stop()
+}
+
+func TestStartStopsWithContext(t *testing.T) {
+	hb := NewHeartbeatClient(time.Second, 10*time.Millisecond)
+	hb.ListenAddress = "127.0.0.1:0"
+
+	var out syncBuffer
+	hb.Logger = log.New(&out, "", 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := hb.Start(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := hb.Addr().String()
+
+	cancel()
+	deadline := time.Now().Add(time.Second)
+	for {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			break
+		}
+		conn.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("expected the listener to close with the context")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	hb.Stop()
+
+	// a busy address is returned by Start, not printed
+	taken := NewHeartbeatClient(time.Second, 10*time.Millisecond)
+	taken.ListenAddress = "127.0.0.1:0"
+	err = taken.Start(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Stop()
+
+	hb.ListenAddress = taken.Addr().String()
+	if hb.Start(context.Background()) == nil {
+		hb.Stop()
+		t.Error("expected listening on a taken address to fail")
+	}
+
+	out.mu.Lock()
+	defer out.mu.Unlock()
+	if out.buf.Len() != 0 {
+		t.Errorf("expected nothing to be logged on a clean shutdown, got %q", out.buf.String())
+	}
+}
+
+func TestMonitorFollowsClock(t *testing.T) {
+	fake := clock.NewFake(time.Unix(0, 0))
+	hb := NewHeartbeatClient(time.Second, 100*time.Millisecond)
+	hb.ListenAddress = "127.0.0.1:0"
+	hb.Clock = fake
+
+	events, cancel := hb.Subscribe()
+	defer cancel()
+	hb.AddNode("node1", "")
+	next(t, events)
+	hb.heard("node1", nil)
+
+	err := hb.Start(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hb.Stop()
+	// the monitor waits on its ticker before the clock moves
+	fake.BlockUntil(1)
+	for i := 0; i < 11; i++ {
+		fake.Advance(100 * time.Millisecond)
+	}
+	e := next(t, events)
+	if e.Type != Suspect || !e.Time.After(e.LastBeat.Add(time.Second)) {
+		t.Errorf("expected node1 to be suspected after the timeout, got %+v", e)
+	}
+}
+
+func TestStartWithoutCheckPeriod(t *testing.T) {
+	for _, hb := range []*HeartbeatClient{New(0, nil), NewHeartbeatClient(time.Second, 0)} {
+		hb.ListenAddress = "127.0.0.1:0"
+		err := hb.Start(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		hb.Stop()
+
+		if hb.checkPeriod != defaultCheckPeriod {
+			t.Errorf("expected the default check period, got %v", hb.checkPeriod)
+		}
+	}
+}
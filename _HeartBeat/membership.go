@@ -0,0 +1,250 @@
+package heartbeat
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/EncrypteID/Replicate-Partition-Next-Generation-Systems/_Clock"
+)
+
+// MemberState is what a SWIM node believes about a member
+type MemberState uint8
+
+const (
+	MemberAlive MemberState = iota
+	MemberSuspect
+	MemberDead
+	MemberLeft
+)
+
+func (s MemberState) String() string {
+	switch s {
+	case MemberAlive:
+		return "alive"
+	case MemberSuspect:
+		return "suspect"
+	case MemberDead:
+		return "dead"
+	case MemberLeft:
+		return "left"
+	}
+	return "unknown"
+}
+
+// Member is a node of a SWIM cluster
+type Member struct {
+	ID          string
+	Address     string
+	State       MemberState
+	Incarnation uint64
+}
+
+// member is the local view of a member. timer declares a suspected member
+// dead after the suspicion timeout unless it refutes, and removes a dead
+// or left one after the dead member timeout.
+type member struct {
+	Member
+	timer clock.Timer
+}
+
+// broadcast is an update waiting to be piggybacked, it is dropped once it
+// was sent often enough to have reached the cluster
+type broadcast struct {
+	u         update
+	transmits int
+}
+
+// overrides reports whether u is newer than what is known about m. a
+// member refutes a suspicion by raising its incarnation, dead and left
+// members only come back with a higher incarnation.
+func overrides(m *member, u update) bool {
+	switch u.state {
+	case MemberAlive:
+		return u.incarnation > m.Incarnation
+	case MemberSuspect:
+		switch m.State {
+		case MemberAlive:
+			return u.incarnation >= m.Incarnation
+		case MemberSuspect:
+			return u.incarnation > m.Incarnation
+		}
+	case MemberDead:
+		if m.State == MemberAlive || m.State == MemberSuspect {
+			return u.incarnation >= m.Incarnation
+		}
+	case MemberLeft:
+		return m.State != MemberLeft && u.incarnation >= m.Incarnation
+	}
+	return false
+}
+
+// apply merges u into the membership and gossips it further when it was
+// news, s.mu is held
+func (s *SWIM) apply(u update, now time.Time) {
+	if u.id == s.id {
+		s.refute(u)
+		return
+	}
+
+	m, known := s.members[u.id]
+	if known && !overrides(m, u) {
+		return
+	}
+	if !known {
+		m = &member{Member: Member{ID: u.id}}
+		s.members[u.id] = m
+	}
+
+	previous := m.State
+	m.State, m.Incarnation = u.state, u.incarnation
+	if u.address != "" {
+		m.Address = u.address
+	}
+	if m.timer != nil {
+		m.timer.Stop()
+		m.timer = nil
+	}
+
+	var events []EventType
+	switch u.state {
+	case MemberAlive:
+		switch {
+		case !known || previous == MemberLeft:
+			events = append(events, Joined)
+		case previous != MemberAlive:
+			events = append(events, Recovered)
+		}
+	case MemberSuspect:
+		if !known {
+			events = append(events, Joined)
+		}
+		if !known || previous == MemberAlive {
+			events = append(events, Suspect)
+		}
+		id, incarnation := u.id, u.incarnation
+		m.timer = s.clock().AfterFunc(s.cfg.SuspicionTimeout, func() {
+			s.suspicionExpired(id, incarnation)
+		})
+	case MemberDead:
+		if known {
+			events = append(events, Dead)
+		}
+	case MemberLeft:
+		if known {
+			events = append(events, Left)
+		}
+	}
+	if u.state == MemberDead || u.state == MemberLeft {
+		id, state, incarnation := u.id, u.state, u.incarnation
+		m.timer = s.clock().AfterFunc(s.cfg.DeadMemberTimeout, func() {
+			s.remove(id, state, incarnation)
+		})
+	}
+
+	for _, t := range events {
+		s.events.Publish(Event{Type: t, NodeID: m.ID, Address: m.Address, Time: now})
+	}
+	s.enqueue(u)
+}
+
+// refute answers gossip about this node, a suspicion or a death is
+// overridden by announcing a higher incarnation. s.mu is held.
+func (s *SWIM) refute(u update) {
+	if u.state == MemberAlive || u.incarnation < s.incarnation || s.left {
+		return
+	}
+
+	s.incarnation = u.incarnation + 1
+	s.enqueue(s.self())
+}
+
+// suspicionExpired declares a member dead that is still suspected with
+// the incarnation it was suspected at
+func (s *SWIM) suspicionExpired(id string, incarnation uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.members[id]
+	if !ok || m.State != MemberSuspect || m.Incarnation != incarnation {
+		return
+	}
+	s.apply(update{state: MemberDead, id: id, address: m.Address, incarnation: incarnation}, s.clock().Now())
+}
+
+// remove forgets a member that stayed dead or left with the incarnation
+// it had then
+func (s *SWIM) remove(id string, state MemberState, incarnation uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.members[id]
+	if !ok || m.State != state || m.Incarnation != incarnation {
+		return
+	}
+	delete(s.members, id)
+}
+
+// self is the update announcing this node, s.mu is held
+func (s *SWIM) self() update {
+	state := MemberAlive
+	if s.left {
+		state = MemberLeft
+	}
+	return update{state: state, id: s.id, address: s.address, incarnation: s.incarnation}
+}
+
+// state is the whole membership as updates, this node first. dead and left
+// members are included so the receiver does not take them back. s.mu is
+// held.
+func (s *SWIM) state() []update {
+	updates := []update{s.self()}
+	for _, m := range s.members {
+		updates = append(updates, update{
+			state:       m.State,
+			id:          m.ID,
+			address:     m.Address,
+			incarnation: m.Incarnation,
+		})
+	}
+	return updates
+}
+
+// enqueue queues u for piggybacking, replacing an older update about the
+// same member. s.mu is held.
+func (s *SWIM) enqueue(u update) {
+	for _, b := range s.queue {
+		if b.u.id == u.id {
+			b.u, b.transmits = u, 0
+			return
+		}
+	}
+	s.queue = append(s.queue, &broadcast{u: u})
+}
+
+// piggyback returns the updates to send along with the next message, the
+// least sent first. an update is sent RetransmitMult times the log of the
+// cluster size before it is dropped. s.mu is held.
+func (s *SWIM) piggyback() []update {
+	limit := s.cfg.RetransmitMult * int(math.Ceil(math.Log10(float64(len(s.members)+2))))
+
+	sort.SliceStable(s.queue, func(i, j int) bool { return s.queue[i].transmits < s.queue[j].transmits })
+
+	n := min(len(s.queue), s.cfg.MaxPiggyback)
+	updates := make([]update, 0, n)
+	for _, b := range s.queue[:n] {
+		updates = append(updates, b.u)
+		b.transmits++
+	}
+
+	kept := s.queue[:0]
+	for _, b := range s.queue {
+		if b.transmits < limit {
+			kept = append(kept, b)
+		}
+	}
+	clear(s.queue[len(kept):])
+	s.queue = kept
+
+	return updates
+}
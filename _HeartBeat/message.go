@@ -0,0 +1,106 @@
+package heartbeat
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+const (
+	messageMagic   = 0x4842 // "HB"
+	messageVersion = 2
+
+	beatMessage = 1
+	ackMessage  = 2
+
+	// messageHeaderSize is the size of the fixed part of a message
+	//
+	//	magic        2 bytes
+	//	version      1 byte
+	//	kind         1 byte
+	//	incarnation  8 bytes
+	//	sequence     8 bytes
+	//	sent         8 bytes, unix nanoseconds
+	//	term         8 bytes
+	//	id length    1 byte
+	//
+	// followed by the id, a 2 byte payload length and the payload
+	messageHeaderSize = 37
+	// maxMessageSize keeps a message within one UDP datagram
+	maxMessageSize = 65507
+)
+
+var (
+	ErrMalformedMessage = errors.New("malformed heartbeat message")
+	ErrMessageTooLarge  = errors.New("heartbeat message too large")
+)
+
+// message is a heartbeat sent over UDP, or the acknowledgement of one. a
+// beat carries the term of its sender, nodes only acknowledge beats of the
+// highest term they saw. an ack echoes the incarnation, sequence, send
+// time and term of the beat and carries the ID of the node acknowledging
+// it.
+type message struct {
+	kind        uint8
+	id          string
+	incarnation uint64
+	seq         uint64
+	sent        time.Time
+	term        uint64
+	payload     []byte
+}
+
+func (m *message) marshal() ([]byte, error) {
+	if len(m.id) > 0xff || messageHeaderSize+len(m.id)+2+len(m.payload) > maxMessageSize {
+		return nil, ErrMessageTooLarge
+	}
+
+	buf := make([]byte, 0, messageHeaderSize+len(m.id)+2+len(m.payload))
+	buf = binary.BigEndian.AppendUint16(buf, messageMagic)
+	buf = append(buf, messageVersion, m.kind)
+	buf = binary.BigEndian.AppendUint64(buf, m.incarnation)
+	buf = binary.BigEndian.AppendUint64(buf, m.seq)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(m.sent.UnixNano()))
+	buf = binary.BigEndian.AppendUint64(buf, m.term)
+	buf = append(buf, byte(len(m.id)))
+	buf = append(buf, m.id...)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(m.payload)))
+	buf = append(buf, m.payload...)
+
+	return buf, nil
+}
+
+func unmarshalMessage(b []byte) (*message, error) {
+	if len(b) < messageHeaderSize || binary.BigEndian.Uint16(b) != messageMagic || b[2] != messageVersion {
+		return nil, ErrMalformedMessage
+	}
+
+	m := &message{
+		kind:        b[3],
+		incarnation: binary.BigEndian.Uint64(b[4:12]),
+		seq:         binary.BigEndian.Uint64(b[12:20]),
+		sent:        time.Unix(0, int64(binary.BigEndian.Uint64(b[20:28]))),
+		term:        binary.BigEndian.Uint64(b[28:36]),
+	}
+	if m.kind != beatMessage && m.kind != ackMessage {
+		return nil, ErrMalformedMessage
+	}
+
+	rest := b[messageHeaderSize:]
+	n := int(b[36])
+	if len(rest) < n+2 {
+		return nil, ErrMalformedMessage
+	}
+	m.id = string(rest[:n])
+	rest = rest[n:]
+
+	size := int(binary.BigEndian.Uint16(rest))
+	if len(rest) != 2+size {
+		return nil, ErrMalformedMessage
+	}
+	if size > 0 {
+		m.payload = append([]byte(nil), rest[2:]...)
+	}
+
+	return m, nil
+}
@@ -0,0 +1,204 @@
+package heartbeat
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// maxPayloadSize keeps a payload within one datagram and one line of a
+	// TCP connection, even sealed
+	maxPayloadSize = 16 << 10
+	// payloadHeaderSize is the size of the fixed part of an encoded payload
+	//
+	//	term          8 bytes
+	//	commit index  8 bytes
+	//	load          8 bytes, float64
+	//	disk usage    8 bytes, float64
+	//	values        2 bytes, count
+	//
+	// followed by the values as 2 byte length prefixed key and value
+	payloadHeaderSize = 34
+)
+
+var (
+	ErrMalformedPayload = errors.New("malformed heartbeat payload")
+	ErrPayloadTooLarge  = errors.New("heartbeat payload too large")
+)
+
+// Payload is the state a node sends along with its heartbeats, so peers
+// can read it without asking
+type Payload struct {
+	// Term is the generation or election term the node is in
+	Term uint64
+	// CommitIndex is the last log index the node knows to be committed
+	CommitIndex uint64
+	// Load is an application defined measure of how busy the node is
+	Load float64
+	// DiskUsage is the fraction of the node's disk in use
+	DiskUsage float64
+	// Values holds application defined state
+	Values map[string]string
+}
+
+// PeerPayload is the latest payload received from a node, its Values are
+// shared and must not be changed
+type PeerPayload struct {
+	Payload
+	// Received is when the heartbeat carrying it arrived
+	Received time.Time
+}
+
+func (p *Payload) marshal() ([]byte, error) {
+	keys := make([]string, 0, len(p.Values))
+	for k := range p.Values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	buf := make([]byte, 0, payloadHeaderSize)
+	buf = binary.BigEndian.AppendUint64(buf, p.Term)
+	buf = binary.BigEndian.AppendUint64(buf, p.CommitIndex)
+	buf = binary.BigEndian.AppendUint64(buf, math.Float64bits(p.Load))
+	buf = binary.BigEndian.AppendUint64(buf, math.Float64bits(p.DiskUsage))
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(keys)))
+	for _, k := range keys {
+		v := p.Values[k]
+		if len(k) > 0xffff || len(v) > 0xffff {
+			return nil, ErrPayloadTooLarge
+		}
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(k)))
+		buf = append(buf, k...)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(v)))
+		buf = append(buf, v...)
+	}
+
+	if len(buf) > maxPayloadSize || len(keys) > 0xffff {
+		return nil, ErrPayloadTooLarge
+	}
+	return buf, nil
+}
+
+func unmarshalPayload(b []byte) (Payload, error) {
+	if len(b) < payloadHeaderSize {
+		return Payload{}, ErrMalformedPayload
+	}
+
+	p := Payload{
+		Term:        binary.BigEndian.Uint64(b[0:8]),
+		CommitIndex: binary.BigEndian.Uint64(b[8:16]),
+		Load:        math.Float64frombits(binary.BigEndian.Uint64(b[16:24])),
+		DiskUsage:   math.Float64frombits(binary.BigEndian.Uint64(b[24:32])),
+	}
+
+	n := int(binary.BigEndian.Uint16(b[32:34]))
+	rest := b[payloadHeaderSize:]
+	if n > 0 {
+		p.Values = make(map[string]string, n)
+	}
+	for i := 0; i < n; i++ {
+		var k, v string
+		var ok bool
+		if k, rest, ok = readLongString(rest); !ok {
+			return Payload{}, ErrMalformedPayload
+		}
+		if v, rest, ok = readLongString(rest); !ok {
+			return Payload{}, ErrMalformedPayload
+		}
+		p.Values[k] = v
+	}
+	if len(rest) != 0 {
+		return Payload{}, ErrMalformedPayload
+	}
+
+	return p, nil
+}
+
+func readLongString(b []byte) (string, []byte, bool) {
+	if len(b) < 2 {
+		return "", nil, false
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return "", nil, false
+	}
+	return string(b[2 : 2+n]), b[2+n:], true
+}
+
+// beatLine returns the line announcing id over TCP, the payload follows
+// the id base64 encoded
+func beatLine(id string, payload []byte) string {
+	if payload == nil {
+		return id
+	}
+	return id + " " + base64.StdEncoding.EncodeToString(payload)
+}
+
+// parseBeatLine splits a heartbeat line into the id and the payload
+func parseBeatLine(line string) (string, []byte, error) {
+	id, encoded, found := strings.Cut(line, " ")
+	if !found {
+		return id, nil, nil
+	}
+
+	payload, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", nil, ErrMalformedPayload
+	}
+	return id, payload, nil
+}
+
+// storePayload keeps the payload that came with a heartbeat of node, a
+// beat without one leaves the last payload in place. node.mu is held.
+func (hm *HeartbeatClient) storePayload(node *Client, payload []byte, now time.Time) {
+	if payload == nil {
+		return
+	}
+
+	p, err := unmarshalPayload(payload)
+	if err != nil {
+		hm.logf("heartbeat: dropping the payload of %s: %v", node.Id, err)
+		return
+	}
+	node.payload = &PeerPayload{Payload: p, Received: now}
+}
+
+// Payload returns the latest payload node id sent, false when it did not
+// send any
+func (hm *HeartbeatClient) Payload(id string) (PeerPayload, bool, error) {
+	hm.mu.Lock()
+	node, ok := hm.nodes[id]
+	hm.mu.Unlock()
+	if !ok {
+		return PeerPayload{}, false, ErrUnknownNode
+	}
+
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	if node.payload == nil {
+		return PeerPayload{}, false, nil
+	}
+	return *node.payload, true, nil
+}
+
+// Payloads returns the latest payload of every node that sent one
+func (hm *HeartbeatClient) Payloads() map[string]PeerPayload {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+
+	payloads := make(map[string]PeerPayload, len(hm.nodes))
+	for id, node := range hm.nodes {
+		node.mu.Lock()
+		if node.payload != nil {
+			payloads[id] = *node.payload
+		}
+		node.mu.Unlock()
+	}
+
+	return payloads
+}
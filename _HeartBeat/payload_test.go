@@ -0,0 +1,93 @@
+package heartbeat
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPayloadEncoding(t *testing.T) {
+	p := Payload{
+		Term:        3,
+		CommitIndex: 1042,
+		Load:        0.75,
+		DiskUsage:   0.5,
+		Values:      map[string]string{"zone": "eu-west-1", "version": "1.4.2"},
+	}
+
+	data, err := p.marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := unmarshalPayload(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, p) {
+		t.Errorf("expected %+v, got %+v", p, got)
+	}
+
+	for i := 0; i < len(data); i++ {
+		if _, err := unmarshalPayload(data[:i]); !errors.Is(err, ErrMalformedPayload) {
+			t.Fatalf("expected a payload cut at %d to be refused, got %v", i, err)
+		}
+	}
+
+	big := Payload{Values: map[string]string{"blob": strings.Repeat("x", maxPayloadSize)}}
+	if _, err := big.marshal(); !errors.Is(err, ErrPayloadTooLarge) {
+		t.Errorf("expected an oversized payload to be refused, got %v", err)
+	}
+}
+
+func TestPayloadPiggybacking(t *testing.T) {
+	for _, transport := range []Transport{TCP, UDP} {
+		t.Run(fmt.Sprint(transport), func(t *testing.T) {
+			server := startServer(t, func(hb *HeartbeatClient) {
+				hb.Transport = transport
+				hb.Key = testKey
+			}, "leader", "follower")
+
+			var term uint64 = 7
+			startSender(t, "leader", server, func(s *HeartbeatSender) {
+				s.Transport = transport
+				s.Key = testKey
+				s.Payload = func() Payload {
+					return Payload{Term: term, CommitIndex: 99, Load: 0.25, Values: map[string]string{"role": "leader"}}
+				}
+			})
+			startSender(t, "follower", server, func(s *HeartbeatSender) {
+				s.Transport = transport
+				s.Key = testKey
+			})
+
+			waitFor(t, "the payload of the leader", func() bool {
+				_, ok, _ := server.Payload("leader")
+				return ok
+			})
+			waitFor(t, "a heartbeat from the follower", func() bool { return !lastBeat(server, "follower").IsZero() })
+
+			p, _, err := server.Payload("leader")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Term != 7 || p.CommitIndex != 99 || p.Load != 0.25 || p.Values["role"] != "leader" {
+				t.Errorf("expected the leader's state, got %+v", p)
+			}
+			if time.Since(p.Received) > time.Second {
+				t.Errorf("expected a recent payload, got one from %v", p.Received)
+			}
+
+			payloads := server.Payloads()
+			if _, ok := payloads["follower"]; ok || len(payloads) != 1 {
+				t.Errorf("expected only the leader to have sent a payload, got %v", payloads)
+			}
+
+			if _, _, err := server.Payload("missing"); !errors.Is(err, ErrUnknownNode) {
+				t.Errorf("expected an unknown node error, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package heartbeat
+
+import (
+	"sync"
+
+	"github.com/EncrypteID/Replicate-Partition-Next-Generation-Systems/_Membership"
+)
+
+// Follow makes the members of reg the nodes hm monitors, self is the ID of
+// the local node and is not monitored. nodes that are not members are
+// removed, and members joining or leaving later are added and removed
+// until the returned function is called.
+func (hm *HeartbeatClient) Follow(reg *membership.Registry, self string) func() {
+	snapshot, changes, cancel := reg.Subscribe()
+
+	members := map[string]bool{}
+	for _, m := range snapshot.Members {
+		if m.ID != self {
+			members[m.ID] = true
+			hm.AddNode(m.ID, m.Address)
+		}
+	}
+	for _, id := range hm.nodeIDs() {
+		if !members[id] {
+			hm.RemoveNode(id)
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for c := range changes {
+			if c.Member.ID == self {
+				continue
+			}
+			if c.Type == membership.Left {
+				hm.RemoveNode(c.Member.ID)
+			} else {
+				hm.AddNode(c.Member.ID, c.Member.Address)
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+func (hm *HeartbeatClient) nodeIDs() []string {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+
+	ids := make([]string, 0, len(hm.nodes))
+	for id := range hm.nodes {
+		ids = append(ids, id)
+	}
+	return ids
+}
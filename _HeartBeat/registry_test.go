@@ -0,0 +1,50 @@
+package heartbeat
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/EncrypteID/Replicate-Partition-Next-Generation-Systems/_Membership"
+)
+
+func TestFollowRegistry(t *testing.T) {
+	reg, err := membership.Open(filepath.Join(t.TempDir(), "members.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg.Join(membership.JoinRequest{Member: membership.Member{ID: "self", Address: "localhost:9000"}})
+	reg.Join(membership.JoinRequest{Member: membership.Member{ID: "node1", Address: "localhost:9001"}})
+
+	hb := NewHeartbeatClient(time.Second, time.Second)
+	hb.AddNode("stale", "localhost:9999")
+	events, cancel := hb.Subscribe()
+	defer cancel()
+
+	stop := hb.Follow(reg, "self")
+	defer stop()
+
+	for _, want := range []Event{{Type: Joined, NodeID: "node1"}, {Type: Left, NodeID: "stale"}} {
+		e := next(t, events)
+		if e.Type != want.Type || e.NodeID != want.NodeID {
+			t.Errorf("expected %s %s, got %+v", want.NodeID, want.Type, e)
+		}
+	}
+
+	reg.Join(membership.JoinRequest{Member: membership.Member{ID: "node2", Address: "localhost:9002"}})
+	reg.Join(membership.JoinRequest{Member: membership.Member{ID: "node1", Address: "localhost:9011"}})
+	reg.Leave(membership.LeaveRequest{ID: "node2"})
+	reg.Leave(membership.LeaveRequest{ID: "self"})
+
+	for _, want := range []Event{{Type: Joined, NodeID: "node2"}, {Type: Left, NodeID: "node2"}} {
+		e := next(t, events)
+		if e.Type != want.Type || e.NodeID != want.NodeID {
+			t.Errorf("expected %s %s, got %+v", want.NodeID, want.Type, e)
+		}
+	}
+
+	stop()
+	if ids := hb.nodeIDs(); len(ids) != 1 || hb.nodes["node1"].address != "localhost:9011" {
+		t.Errorf("expected only node1 at its new address, got %v", ids)
+	}
+}
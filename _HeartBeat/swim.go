@@ -0,0 +1,575 @@
+package heartbeat
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/EncrypteID/Replicate-Partition-Next-Generation-Systems/_Clock"
+	"github.com/EncrypteID/Replicate-Partition-Next-Generation-Systems/_PubSub"
+)
+
+var (
+	ErrNotStarted = errors.New("SWIM node not started")
+	ErrJoinFailed = errors.New("no seed node answered")
+)
+
+// SWIMConfig tunes the SWIM membership protocol
+type SWIMConfig struct {
+	// ListenAddress is the UDP address the node binds to
+	ListenAddress string
+	// AdvertiseAddress is the address the other members reach the node at,
+	// the bound address when empty
+	AdvertiseAddress string
+	// ProbeInterval is the time between probes of a random member
+	ProbeInterval time.Duration
+	// ProbeTimeout is how long a direct ping waits for its ack before
+	// other members are asked to probe indirectly
+	ProbeTimeout time.Duration
+	// IndirectProbes is the number of members asked to probe indirectly
+	IndirectProbes int
+	// SuspicionTimeout is how long a suspected member has to refute before
+	// it is declared dead
+	SuspicionTimeout time.Duration
+	// DeadMemberTimeout is how long a dead or left member is remembered,
+	// so older gossip about it cannot bring it back, before it is removed
+	DeadMemberTimeout time.Duration
+	// RetransmitMult times the log of the cluster size is how often an
+	// update is piggybacked
+	RetransmitMult int
+	// MaxPiggyback is the number of updates sent along with a message
+	MaxPiggyback int
+	// SyncInterval is the time between the full membership exchanges with
+	// a random member that catch up on missed gossip, there are none when
+	// it is 0
+	SyncInterval time.Duration
+	// Key is the shared key every message is sealed with, messages are not
+	// authenticated when it is nil
+	Key []byte
+	// MaxClockSkew is how old or early a sealed message may be,
+	// DefaultMaxClockSkew when 0
+	MaxClockSkew time.Duration
+	// Clock times the probes, suspicions and removals, the real clock when
+	// it is nil
+	Clock clock.Clock
+	// Logger receives the errors the protocol cannot return, the standard
+	// logger is used when it is nil
+	Logger Logger
+}
+
+// DefaultSWIMConfig returns a configuration suited to a LAN
+func DefaultSWIMConfig() SWIMConfig {
+	return SWIMConfig{
+		ListenAddress:     ":0",
+		ProbeInterval:     time.Second,
+		ProbeTimeout:      500 * time.Millisecond,
+		IndirectProbes:    3,
+		SuspicionTimeout:  5 * time.Second,
+		DeadMemberTimeout: 30 * time.Second,
+		RetransmitMult:    4,
+		MaxPiggyback:      8,
+		SyncInterval:      30 * time.Second,
+	}
+}
+
+// SWIM keeps the membership of a cluster with the SWIM protocol. every
+// probe interval one member, taken in a shuffled round robin, is pinged.
+// when no ack arrives in time IndirectProbes other members ping it on this
+// node's behalf, and when they fail too it is suspected. a suspected
+// member that does not refute by raising its incarnation within the
+// suspicion timeout is declared dead. membership changes travel
+// piggybacked on the protocol messages, so new nodes only need to know one
+// seed to join. every message names its sender, and every sync interval
+// the whole membership is exchanged with a random member, so a node that
+// missed gossip catches up.
+type SWIM struct {
+	id  string
+	cfg SWIMConfig
+
+	mu          sync.Mutex
+	conn        net.PacketConn
+	address     string
+	incarnation uint64
+	left        bool
+	members     map[string]*member
+	probeOrder  []string
+	queue       []*broadcast
+	seq         uint64
+	pending     map[uint64]func(*swimMessage)
+	events      pubsub.Hub[Event]
+	nonces      nonceCache
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewSWIM returns a SWIM node with the given id
+func NewSWIM(id string, cfg SWIMConfig) *SWIM {
+	return &SWIM{
+		id:          id,
+		cfg:         cfg,
+		incarnation: newIncarnation(),
+		members:     map[string]*member{},
+		pending:     map[uint64]func(*swimMessage){},
+	}
+}
+
+// Start binds the node and runs the protocol until ctx is done or Stop is
+// called. the node is alone until it joins a cluster or is joined.
+func (s *SWIM) Start(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn != nil {
+		return ErrStarted
+	}
+
+	conn, err := net.ListenPacket("udp", s.cfg.ListenAddress)
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	s.address = s.cfg.AdvertiseAddress
+	if s.address == "" {
+		s.address = conn.LocalAddr().String()
+	}
+	s.left = false
+
+	ctx, cancel := context.WithCancel(ctx)
+	s.cancel = cancel
+
+	s.wg.Add(3)
+	go func() {
+		defer s.wg.Done()
+		s.read(conn)
+	}()
+	go func() {
+		defer s.wg.Done()
+		s.probeLoop(ctx)
+	}()
+	go func() {
+		defer s.wg.Done()
+		<-ctx.Done()
+		conn.Close()
+	}()
+	if s.cfg.SyncInterval > 0 {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.syncLoop(ctx)
+		}()
+	}
+
+	return nil
+}
+
+// Stop stops the protocol without telling the cluster, the other members
+// will find the node dead. call Leave first to leave gracefully.
+func (s *SWIM) Stop() {
+	s.mu.Lock()
+	cancel := s.cancel
+	s.mu.Unlock()
+
+	if cancel == nil {
+		return
+	}
+	cancel()
+	s.wg.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conn = nil
+	s.cancel = nil
+	for _, m := range s.members {
+		if m.timer != nil {
+			m.timer.Stop()
+			m.timer = nil
+		}
+	}
+}
+
+// Addr returns the address the node is bound to, nil when it is not
+// started
+func (s *SWIM) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.LocalAddr()
+}
+
+// Join announces the node to the seeds and learns the members they know,
+// it succeeds when one of them answers
+func (s *SWIM) Join(seeds ...string) error {
+	if s.Addr() == nil {
+		return ErrNotStarted
+	}
+
+	joined := false
+	for _, seed := range seeds {
+		answered := make(chan struct{}, 1)
+		seq := s.expect(func(*swimMessage) {
+			select {
+			case answered <- struct{}{}:
+			default:
+			}
+		})
+
+		s.mu.Lock()
+		updates := s.state()
+		s.mu.Unlock()
+		s.send(seed, &swimMessage{kind: joinKind, seq: seq, from: s.id, updates: updates})
+
+		timeout := s.clock().NewTimer(s.cfg.ProbeInterval)
+		select {
+		case <-answered:
+			joined = true
+		case <-timeout.C():
+			logf(s.cfg.Logger, "heartbeat: seed %s did not answer", seed)
+		}
+		timeout.Stop()
+		s.forget(seq)
+	}
+
+	if !joined {
+		return ErrJoinFailed
+	}
+	return nil
+}
+
+// Leave tells the cluster that the node leaves, the update is sent to a
+// few members at once and gossiped by them. Stop the node afterwards.
+func (s *SWIM) Leave() error {
+	if s.Addr() == nil {
+		return ErrNotStarted
+	}
+
+	s.mu.Lock()
+	s.left = true
+	s.incarnation++
+	s.enqueue(s.self())
+	targets := s.randomMembers(s.cfg.RetransmitMult, "")
+	s.mu.Unlock()
+
+	for _, m := range targets {
+		s.send(m.Address, &swimMessage{kind: pingKind, seq: s.nextSeq(), from: s.id, target: m.ID})
+	}
+	return nil
+}
+
+// Members returns the members this node believes alive or suspected,
+// itself included, sorted by ID
+func (s *SWIM) Members() []Member {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u := s.self()
+	members := []Member{{ID: u.id, Address: u.address, State: u.state, Incarnation: u.incarnation}}
+	for _, m := range s.members {
+		if m.State == MemberAlive || m.State == MemberSuspect {
+			members = append(members, m.Member)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].ID < members[j].ID })
+
+	return members
+}
+
+func (s *SWIM) clock() clock.Clock {
+	if s.cfg.Clock != nil {
+		return s.cfg.Clock
+	}
+	return clock.Real
+}
+
+// Subscribe returns a channel receiving the membership events of the
+// cluster, see HeartbeatClient.Subscribe
+func (s *SWIM) Subscribe() (<-chan Event, func()) {
+	return s.events.Subscribe()
+}
+
+func (s *SWIM) probeLoop(ctx context.Context) {
+	ticker := s.clock().NewTicker(s.cfg.ProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			s.probe(ctx)
+		}
+	}
+}
+
+// syncLoop exchanges the whole membership with a random member every sync
+// interval
+func (s *SWIM) syncLoop(ctx context.Context) {
+	ticker := s.clock().NewTicker(s.cfg.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			s.pushPull()
+		}
+	}
+}
+
+// pushPull sends the membership to a random member, which merges it and
+// answers with its own. an update that stopped being gossiped before it
+// reached every member still spreads this way.
+func (s *SWIM) pushPull() {
+	s.mu.Lock()
+	targets := s.randomMembers(1, "")
+	updates := s.state()
+	s.mu.Unlock()
+
+	if len(targets) == 0 {
+		return
+	}
+	s.send(targets[0].Address, &swimMessage{kind: pushPullKind, seq: s.nextSeq(), from: s.id, updates: updates})
+}
+
+// probe pings the next member and suspects it when neither the ping nor
+// the indirect probes are answered within the probe interval
+func (s *SWIM) probe(ctx context.Context) {
+	target, ok := s.nextTarget()
+	if !ok {
+		return
+	}
+
+	acked := make(chan struct{}, 1)
+	seq := s.expect(func(*swimMessage) {
+		select {
+		case acked <- struct{}{}:
+		default:
+		}
+	})
+	defer s.forget(seq)
+
+	s.send(target.Address, &swimMessage{kind: pingKind, seq: seq, from: s.id, target: target.ID})
+
+	timeout := s.clock().NewTimer(s.cfg.ProbeTimeout)
+	defer timeout.Stop()
+	select {
+	case <-acked:
+		return
+	case <-ctx.Done():
+		return
+	case <-timeout.C():
+	}
+
+	s.mu.Lock()
+	relays := s.randomMembers(s.cfg.IndirectProbes, target.ID)
+	s.mu.Unlock()
+	for _, relay := range relays {
+		s.send(relay.Address, &swimMessage{
+			kind:          pingReqKind,
+			seq:           seq,
+			from:          s.id,
+			target:        target.ID,
+			targetAddress: target.Address,
+		})
+	}
+
+	timeout.Reset(s.cfg.ProbeInterval - s.cfg.ProbeTimeout)
+	select {
+	case <-acked:
+		return
+	case <-ctx.Done():
+		return
+	case <-timeout.C():
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.apply(update{state: MemberSuspect, id: target.ID, address: target.Address, incarnation: target.Incarnation}, s.clock().Now())
+}
+
+// nextTarget returns the next member to probe, the members are probed in
+// a random order that is shuffled again after every round
+func (s *SWIM) nextTarget() (Member, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for attempts := 0; attempts < 2; attempts++ {
+		for len(s.probeOrder) > 0 {
+			id := s.probeOrder[0]
+			s.probeOrder = s.probeOrder[1:]
+
+			m, ok := s.members[id]
+			if ok && (m.State == MemberAlive || m.State == MemberSuspect) {
+				return m.Member, true
+			}
+		}
+
+		for id, m := range s.members {
+			if m.State == MemberAlive || m.State == MemberSuspect {
+				s.probeOrder = append(s.probeOrder, id)
+			}
+		}
+		rand.Shuffle(len(s.probeOrder), func(i, j int) {
+			s.probeOrder[i], s.probeOrder[j] = s.probeOrder[j], s.probeOrder[i]
+		})
+	}
+
+	return Member{}, false
+}
+
+// randomMembers returns up to n alive members other than except, s.mu is
+// held
+func (s *SWIM) randomMembers(n int, except string) []Member {
+	var candidates []Member
+	for id, m := range s.members {
+		if id != except && m.State == MemberAlive {
+			candidates = append(candidates, m.Member)
+		}
+	}
+	rand.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+
+	return candidates[:min(n, len(candidates))]
+}
+
+func (s *SWIM) nextSeq() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	return s.seq
+}
+
+// expect registers f to be called with the answer to the message sent
+// with the returned sequence number
+func (s *SWIM) expect(f func(*swimMessage)) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	s.pending[s.seq] = f
+	return s.seq
+}
+
+func (s *SWIM) forget(seq uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.pending, seq)
+}
+
+// send writes m to address with the updates waiting to be piggybacked.
+// the messages that do not carry the whole membership start with the
+// sender, so a member that does not know it yet learns it from any of
+// them.
+func (s *SWIM) send(address string, m *swimMessage) {
+	s.mu.Lock()
+	conn := s.conn
+	switch m.kind {
+	case joinKind, syncKind, pushPullKind:
+	default:
+		m.updates = append(append([]update{s.self()}, m.updates...), s.piggyback()...)
+	}
+	s.mu.Unlock()
+
+	if conn == nil {
+		return
+	}
+
+	data, err := m.marshal()
+	if err == nil && s.cfg.Key != nil {
+		data = seal(s.cfg.Key, data, s.clock().Now())
+		if len(data) > maxMessageSize {
+			err = ErrMessageTooLarge
+		}
+	}
+	if err != nil {
+		logf(s.cfg.Logger, "heartbeat: encoding a message for %s failed: %v", address, err)
+		return
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err == nil {
+		_, err = conn.WriteTo(data, addr)
+	}
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		logf(s.cfg.Logger, "heartbeat: sending to %s failed: %v", address, err)
+	}
+}
+
+// read handles the datagrams arriving on conn until it is closed
+func (s *SWIM) read(conn net.PacketConn) {
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			logf(s.cfg.Logger, "heartbeat: reading a datagram failed: %v", err)
+			continue
+		}
+
+		data := buf[:n]
+		if s.cfg.Key != nil {
+			data, err = s.nonces.open(s.cfg.Key, s.cfg.MaxClockSkew, data, s.clock().Now())
+			if err != nil {
+				logf(s.cfg.Logger, "heartbeat: rejecting a datagram from %s: %v", addr, err)
+				continue
+			}
+		}
+
+		m, err := unmarshalSWIM(data)
+		if err != nil {
+			logf(s.cfg.Logger, "heartbeat: dropping a datagram from %s: %v", addr, err)
+			continue
+		}
+		s.handle(m, addr.String())
+	}
+}
+
+func (s *SWIM) handle(m *swimMessage, from string) {
+	now := s.clock().Now()
+
+	s.mu.Lock()
+	for _, u := range m.updates {
+		s.apply(u, now)
+	}
+	answer := s.pending[m.seq]
+	s.mu.Unlock()
+
+	switch m.kind {
+	case pingKind:
+		if m.target == s.id {
+			s.send(from, &swimMessage{kind: ackKind, seq: m.seq, from: s.id})
+		}
+
+	case ackKind, syncKind:
+		if answer != nil {
+			answer(m)
+		}
+
+	case pingReqKind:
+		// probe the target for the requester and pass its ack on, answers
+		// are only read by this goroutine so seq is set before the ack
+		var seq uint64
+		seq = s.expect(func(*swimMessage) {
+			s.forget(seq)
+			s.send(from, &swimMessage{kind: ackKind, seq: m.seq, from: s.id})
+		})
+		s.clock().AfterFunc(s.cfg.ProbeInterval, func() { s.forget(seq) })
+		s.send(m.targetAddress, &swimMessage{kind: pingKind, seq: seq, from: s.id, target: m.target})
+
+	case joinKind, pushPullKind:
+		s.mu.Lock()
+		updates := s.state()
+		s.mu.Unlock()
+		s.send(from, &swimMessage{kind: syncKind, seq: m.seq, from: s.id, updates: updates})
+	}
+}
@@ -0,0 +1,566 @@
+package heartbeat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/EncrypteID/Replicate-Partition-Next-Generation-Systems/_Clock"
+)
+
+func testSWIMConfig() SWIMConfig {
+	cfg := DefaultSWIMConfig()
+	cfg.ListenAddress = "127.0.0.1:0"
+	cfg.ProbeInterval = 50 * time.Millisecond
+	cfg.ProbeTimeout = 20 * time.Millisecond
+	cfg.SuspicionTimeout = 250 * time.Millisecond
+	cfg.DeadMemberTimeout = time.Second
+	return cfg
+}
+
+// droppingConn loses the datagrams written to the address to
+type droppingConn struct {
+	net.PacketConn
+	to string
+}
+
+func (c *droppingConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if addr.String() == c.to {
+		return len(p), nil
+	}
+	return c.PacketConn.WriteTo(p, addr)
+}
+
+// dropTo makes s lose every message it sends to address
+func dropTo(s *SWIM, address string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.conn = &droppingConn{PacketConn: s.conn, to: address}
+}
+
+// startCluster starts n SWIM nodes that join through the first one
+func startCluster(t *testing.T, n int) []*SWIM {
+	t.Helper()
+
+	nodes := make([]*SWIM, n)
+	for i := range nodes {
+		nodes[i] = NewSWIM(fmt.Sprintf("node-%d", i), testSWIMConfig())
+		err := nodes[i].Start(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(nodes[i].Stop)
+
+		if i > 0 {
+			err = nodes[i].Join(nodes[0].Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	return nodes
+}
+
+// fakeCluster is a SWIM cluster on a fake clock. it only advances the
+// clock once no member waits for an answer from a running member, so a
+// slow machine cannot get a healthy member suspected.
+type fakeCluster struct {
+	t     *testing.T
+	clock *clock.Fake
+	nodes []*SWIM
+
+	mu sync.Mutex
+	// down holds the addresses of the stopped members, pings the address
+	// every ping of a member went to by sequence number
+	down  map[string]bool
+	pings map[*SWIM]map[uint64]string
+}
+
+// fakeConn remembers where the pings of its member go and loses what it
+// sends to a stopped member
+type fakeConn struct {
+	net.PacketConn
+	c *fakeCluster
+	s *SWIM
+}
+
+func (c *fakeConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	c.c.mu.Lock()
+	defer c.c.mu.Unlock()
+
+	if m, err := unmarshalSWIM(p); err == nil && m.kind == pingKind {
+		c.c.pings[c.s][m.seq] = addr.String()
+	}
+	if c.c.down[addr.String()] {
+		return len(p), nil
+	}
+	return c.PacketConn.WriteTo(p, addr)
+}
+
+// startFakeCluster starts n SWIM nodes on one fake clock that join
+// through the first one
+func startFakeCluster(t *testing.T, n int) *fakeCluster {
+	t.Helper()
+
+	c := &fakeCluster{
+		t:     t,
+		clock: clock.NewFake(time.Unix(1000, 0)),
+		down:  map[string]bool{},
+		pings: map[*SWIM]map[uint64]string{},
+	}
+	for i := 0; i < n; i++ {
+		cfg := testSWIMConfig()
+		cfg.Clock = c.clock
+		// longer than the longest gap between two probes of a member, so
+		// every member suspects a dead one before it is declared dead
+		cfg.SuspicionTimeout = time.Second
+		s := NewSWIM(fmt.Sprintf("node-%d", i), cfg)
+		err := s.Start(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(s.Stop)
+
+		s.mu.Lock()
+		s.conn = &fakeConn{PacketConn: s.conn, c: c, s: s}
+		s.mu.Unlock()
+		c.pings[s] = map[uint64]string{}
+		c.nodes = append(c.nodes, s)
+
+		if i > 0 {
+			err = s.Join(c.nodes[0].Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	return c
+}
+
+// stop stops s, what the others send it is lost from now on
+func (c *fakeCluster) stop(s *SWIM) {
+	c.mu.Lock()
+	c.down[s.Addr().String()] = true
+	c.mu.Unlock()
+
+	s.Stop()
+}
+
+// settle waits until the members only wait for answers to pings sent to
+// stopped members
+func (c *fakeCluster) settle() {
+	c.t.Helper()
+
+	waitFor(c.t, "the members to get their answers", func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		for _, s := range c.nodes {
+			s.mu.Lock()
+			for seq := range s.pending {
+				if !c.down[c.pings[s][seq]] {
+					s.mu.Unlock()
+					return false
+				}
+			}
+			s.mu.Unlock()
+		}
+		return true
+	})
+}
+
+// runUntil advances the clock in steps shorter than the probe timeout
+// until cond holds
+func (c *fakeCluster) runUntil(what string, cond func() bool) {
+	c.t.Helper()
+
+	step := testSWIMConfig().ProbeTimeout / 2
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			c.t.Fatalf("expected %s", what)
+		}
+		c.settle()
+		c.clock.Advance(step)
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// waitFor polls cond until it holds or the deadline passes
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func memberIDs(s *SWIM, state MemberState) []string {
+	var ids []string
+	for _, m := range s.Members() {
+		if m.State == state {
+			ids = append(ids, m.ID)
+		}
+	}
+	return ids
+}
+
+func TestSWIMMessage(t *testing.T) {
+	m := &swimMessage{
+		kind:          pingReqKind,
+		seq:           9,
+		from:          "node-1",
+		target:        "node-2",
+		targetAddress: "127.0.0.1:7000",
+		updates: []update{
+			{state: MemberSuspect, id: "node-2", address: "127.0.0.1:7000", incarnation: 3},
+			{state: MemberLeft, id: "node-3", incarnation: 1},
+		},
+	}
+
+	data, err := m.marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := unmarshalSWIM(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, m) {
+		t.Errorf("expected %+v, got %+v", m, got)
+	}
+
+	for i := 0; i < len(data); i++ {
+		if _, err := unmarshalSWIM(data[:i]); err == nil {
+			t.Fatalf("expected a message cut at %d to be refused", i)
+		}
+	}
+}
+
+func TestSWIMUpdatePrecedence(t *testing.T) {
+	for _, c := range []struct {
+		state       MemberState
+		incarnation uint64
+		u           update
+		want        bool
+	}{
+		{MemberAlive, 1, update{state: MemberAlive, incarnation: 1}, false},
+		{MemberAlive, 1, update{state: MemberAlive, incarnation: 2}, true},
+		{MemberAlive, 1, update{state: MemberSuspect, incarnation: 1}, true},
+		{MemberSuspect, 1, update{state: MemberSuspect, incarnation: 1}, false},
+		{MemberSuspect, 1, update{state: MemberAlive, incarnation: 1}, false},
+		{MemberSuspect, 1, update{state: MemberAlive, incarnation: 2}, true},
+		{MemberSuspect, 2, update{state: MemberDead, incarnation: 2}, true},
+		{MemberDead, 2, update{state: MemberSuspect, incarnation: 3}, false},
+		{MemberDead, 2, update{state: MemberAlive, incarnation: 3}, true},
+		{MemberAlive, 2, update{state: MemberLeft, incarnation: 2}, true},
+		{MemberLeft, 2, update{state: MemberDead, incarnation: 2}, false},
+	} {
+		m := &member{Member: Member{State: c.state, Incarnation: c.incarnation}}
+		if got := overrides(m, c.u); got != c.want {
+			t.Errorf("%v at %d, %v at %d: expected %v", c.state, c.incarnation, c.u.state, c.u.incarnation, c.want)
+		}
+	}
+}
+
+func TestSWIMRefutesSuspicion(t *testing.T) {
+	s := NewSWIM("node-0", testSWIMConfig())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	incarnation := s.incarnation
+	s.apply(update{state: MemberSuspect, id: "node-0", incarnation: incarnation}, time.Now())
+	if s.incarnation != incarnation+1 {
+		t.Fatalf("expected the incarnation to be raised, got %d", s.incarnation-incarnation)
+	}
+	if len(s.queue) != 1 || s.queue[0].u.state != MemberAlive || s.queue[0].u.incarnation != s.incarnation {
+		t.Errorf("expected the refutation to be gossiped, got %+v", s.queue)
+	}
+
+	// an old suspicion is ignored
+	s.apply(update{state: MemberSuspect, id: "node-0", incarnation: incarnation}, time.Now())
+	if s.incarnation != incarnation+1 {
+		t.Error("expected a stale suspicion not to raise the incarnation")
+	}
+}
+
+// state returns what s believes about member id, false when it does not
+// know it
+func state(s *SWIM, id string) (MemberState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.members[id]
+	if !ok {
+		return 0, false
+	}
+	return m.State, true
+}
+
+func TestSWIMSuspicionTimeout(t *testing.T) {
+	fake := clock.NewFake(time.Unix(1000, 0))
+	cfg := testSWIMConfig()
+	cfg.Clock = fake
+	s := NewSWIM("node-0", cfg)
+	defer s.Stop()
+
+	events, cancel := s.Subscribe()
+	defer cancel()
+
+	s.mu.Lock()
+	s.apply(update{state: MemberAlive, id: "node-1", address: "node-1", incarnation: 1}, fake.Now())
+	s.apply(update{state: MemberSuspect, id: "node-1", incarnation: 1}, fake.Now())
+	s.mu.Unlock()
+
+	fake.Advance(cfg.SuspicionTimeout - time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	if st, _ := state(s, "node-1"); st != MemberSuspect {
+		t.Fatalf("expected node-1 to be suspected until the timeout, got %v", st)
+	}
+
+	fake.Advance(time.Millisecond)
+	waitFor(t, "node-1 to be declared dead", func() bool {
+		st, _ := state(s, "node-1")
+		return st == MemberDead
+	})
+
+	var seen []EventType
+	for len(seen) < 3 {
+		seen = append(seen, next(t, events).Type)
+	}
+	if !reflect.DeepEqual(seen, []EventType{Joined, Suspect, Dead}) {
+		t.Errorf("expected node-1 to join, be suspected and die, got %v", seen)
+	}
+
+	fake.Advance(cfg.DeadMemberTimeout)
+	waitFor(t, "node-1 to be removed", func() bool {
+		_, ok := state(s, "node-1")
+		return !ok
+	})
+}
+
+func TestSWIMRemovesDeadMembers(t *testing.T) {
+	fake := clock.NewFake(time.Unix(1000, 0))
+	cfg := testSWIMConfig()
+	cfg.Clock = fake
+	s := NewSWIM("node-0", cfg)
+	defer s.Stop()
+
+	known := func(id string) bool {
+		_, ok := state(s, id)
+		return ok
+	}
+
+	s.mu.Lock()
+	s.apply(update{state: MemberAlive, id: "node-1", address: "node-1", incarnation: 1}, time.Now())
+	s.apply(update{state: MemberDead, id: "node-1", incarnation: 1}, time.Now())
+	s.apply(update{state: MemberAlive, id: "node-2", address: "node-2", incarnation: 1}, time.Now())
+	s.apply(update{state: MemberLeft, id: "node-2", incarnation: 1}, time.Now())
+	s.apply(update{state: MemberAlive, id: "node-3", address: "node-3", incarnation: 1}, time.Now())
+	s.apply(update{state: MemberDead, id: "node-3", incarnation: 1}, time.Now())
+
+	// node-3 comes back before it is forgotten
+	s.apply(update{state: MemberAlive, id: "node-3", address: "node-3", incarnation: 2}, time.Now())
+	s.mu.Unlock()
+
+	fake.Advance(cfg.DeadMemberTimeout)
+	waitFor(t, "dead and left members to be removed", func() bool {
+		return !known("node-1") && !known("node-2")
+	})
+	if !known("node-3") {
+		t.Error("expected a member that came back to be kept")
+	}
+}
+
+// sees reports whether every node of nodes sees n members
+func sees(nodes []*SWIM, n int, state ...MemberState) bool {
+	for _, s := range nodes {
+		if len(s.Members()) != n {
+			return false
+		}
+		for _, st := range state {
+			if len(memberIDs(s, st)) != n {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func TestSWIMMembership(t *testing.T) {
+	c := startFakeCluster(t, 5)
+	nodes := c.nodes
+
+	// every node learns of every other through gossip from the seed
+	c.runUntil("every node to see all 5 nodes", func() bool {
+		return sees(nodes, 5, MemberAlive)
+	})
+
+	events, cancel := nodes[1].Subscribe()
+	defer cancel()
+
+	// node-4 crashes, it is suspected and then declared dead everywhere
+	c.stop(nodes[4])
+	c.runUntil("every node to drop node-4", func() bool {
+		return sees(nodes[:4], 4)
+	})
+
+	var seen []EventType
+	for len(seen) < 2 {
+		e := next(t, events)
+		if e.NodeID == "node-4" {
+			seen = append(seen, e.Type)
+		}
+	}
+	if seen[0] != Suspect || seen[1] != Dead {
+		t.Errorf("expected node-4 to be suspected and then dead, got %v", seen)
+	}
+
+	// node-3 leaves gracefully
+	err := nodes[3].Leave()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.stop(nodes[3])
+	// node-3 told every remaining node itself
+	waitFor(t, "every node to see node-3 leave", func() bool {
+		return sees(nodes[:3], 3)
+	})
+	for {
+		e := next(t, events)
+		if e.NodeID == "node-3" {
+			if e.Type != Left {
+				t.Errorf("expected node-3 to leave, got %v", e.Type)
+			}
+			break
+		}
+	}
+}
+
+func TestSWIMIndirectProbe(t *testing.T) {
+	nodes := startCluster(t, 3)
+	for _, n := range nodes {
+		waitFor(t, n.id+" to see all 3 nodes", func() bool {
+			return len(memberIDs(n, MemberAlive)) == 3
+		})
+	}
+
+	// node-0 cannot reach node-2 directly, node-1 probes it instead
+	dropTo(nodes[0], nodes[2].Addr().String())
+
+	events, cancel := nodes[0].Subscribe()
+	defer cancel()
+
+	time.Sleep(20 * testSWIMConfig().ProbeInterval)
+
+	select {
+	case e := <-events:
+		t.Errorf("expected node-2 to stay alive through indirect probes, got %v for %s", e.Type, e.NodeID)
+	default:
+	}
+}
+
+func TestSWIMSealed(t *testing.T) {
+	start := func(id string, key []byte) *SWIM {
+		cfg := testSWIMConfig()
+		cfg.Key = key
+		s := NewSWIM(id, cfg)
+		err := s.Start(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(s.Stop)
+		return s
+	}
+
+	seed := start("node-0", testKey)
+	err := start("node-1", testKey).Join(seed.Addr().String())
+	if err != nil {
+		t.Fatalf("expected a node with the key to join, got %v", err)
+	}
+
+	for _, key := range [][]byte{nil, []byte("another key")} {
+		err = start("node-2", key).Join(seed.Addr().String())
+		if !errors.Is(err, ErrJoinFailed) {
+			t.Errorf("expected a node without the key not to join, got %v", err)
+		}
+	}
+	if ids := memberIDs(seed, MemberAlive); !reflect.DeepEqual(ids, []string{"node-0", "node-1"}) {
+		t.Errorf("expected only node-1 to be a member, got %v", ids)
+	}
+}
+
+func TestSWIMLearnsSender(t *testing.T) {
+	cfg := testSWIMConfig()
+	cfg.Clock = clock.NewFake(time.Unix(1000, 0))
+	a, b := NewSWIM("node-0", cfg), NewSWIM("node-1", cfg)
+	for _, s := range []*SWIM{a, b} {
+		err := s.Start(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(s.Stop)
+	}
+
+	// node-1 never joined, node-0 learns it from a ping and node-1 learns
+	// node-0 from the ack
+	b.send(a.Addr().String(), &swimMessage{kind: pingKind, seq: b.nextSeq(), from: b.id, target: a.id})
+	waitFor(t, "node-0 to learn node-1", func() bool {
+		st, ok := state(a, "node-1")
+		return ok && st == MemberAlive
+	})
+	waitFor(t, "node-1 to learn node-0", func() bool {
+		st, ok := state(b, "node-0")
+		return ok && st == MemberAlive
+	})
+}
+
+func TestSWIMPushPull(t *testing.T) {
+	fake := clock.NewFake(time.Unix(1000, 0))
+	start := func(id string) *SWIM {
+		cfg := testSWIMConfig()
+		cfg.Clock = fake
+		// membership only spreads through joins and syncs
+		cfg.ProbeInterval = time.Hour
+		cfg.MaxPiggyback = 0
+		cfg.SyncInterval = time.Second
+		s := NewSWIM(id, cfg)
+		err := s.Start(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(s.Stop)
+		return s
+	}
+
+	seed, a, b := start("node-0"), start("node-1"), start("node-2")
+	for _, s := range []*SWIM{a, b} {
+		err := s.Join(seed.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok := state(a, "node-2"); ok {
+		t.Fatal("expected node-1 not to know node-2 before a sync")
+	}
+
+	// node-1 only knows the seed, it learns node-2 from the seed's answer
+	fake.Advance(time.Second)
+	waitFor(t, "node-1 to learn node-2", func() bool {
+		st, ok := state(a, "node-2")
+		return ok && st == MemberAlive
+	})
+}
@@ -0,0 +1,158 @@
+package heartbeat
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+const (
+	swimMagic   = 0x5357 // "SW"
+	swimVersion = 1
+
+	pingKind     = 1
+	ackKind      = 2
+	pingReqKind  = 3
+	joinKind     = 4
+	syncKind     = 5
+	pushPullKind = 6
+
+	// swimHeaderSize is the size of the fixed part of a SWIM message
+	//
+	//	magic    2 bytes
+	//	version  1 byte
+	//	kind     1 byte
+	//	seq      8 bytes
+	//
+	// followed by the sender, the target and the target address as 1 byte
+	// length prefixed strings, a 2 byte update count and the updates
+	swimHeaderSize = 12
+)
+
+// update is a piece of membership gossip, what one node believes about
+// another
+type update struct {
+	state       MemberState
+	id          string
+	address     string
+	incarnation uint64
+}
+
+// swimMessage is a datagram of the SWIM protocol. a ping names its target
+// so a node that took over the address of another does not answer for it,
+// a ping-req asks the receiver to probe the target on behalf of the
+// sender. every message piggybacks membership updates.
+type swimMessage struct {
+	kind          uint8
+	seq           uint64
+	from          string
+	target        string
+	targetAddress string
+	updates       []update
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = append(buf, byte(len(s)))
+	return append(buf, s...)
+}
+
+func readString(b []byte) (string, []byte, bool) {
+	if len(b) < 1 || len(b) < 1+int(b[0]) {
+		return "", nil, false
+	}
+	n := int(b[0])
+	return string(b[1 : 1+n]), b[1+n:], true
+}
+
+func (m *swimMessage) marshal() ([]byte, error) {
+	for _, s := range []string{m.from, m.target, m.targetAddress} {
+		if len(s) > 0xff {
+			return nil, ErrMessageTooLarge
+		}
+	}
+
+	buf := make([]byte, 0, 128)
+	buf = binary.BigEndian.AppendUint16(buf, swimMagic)
+	buf = append(buf, swimVersion, m.kind)
+	buf = binary.BigEndian.AppendUint64(buf, m.seq)
+	buf = appendString(buf, m.from)
+	buf = appendString(buf, m.target)
+	buf = appendString(buf, m.targetAddress)
+
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(m.updates)))
+	for _, u := range m.updates {
+		if len(u.id) > 0xff || len(u.address) > 0xff {
+			return nil, ErrMessageTooLarge
+		}
+		buf = append(buf, byte(u.state))
+		buf = binary.BigEndian.AppendUint64(buf, u.incarnation)
+		buf = appendString(buf, u.id)
+		buf = appendString(buf, u.address)
+	}
+
+	if len(buf) > maxMessageSize {
+		return nil, ErrMessageTooLarge
+	}
+	return buf, nil
+}
+
+func unmarshalSWIM(b []byte) (*swimMessage, error) {
+	if len(b) < swimHeaderSize || binary.BigEndian.Uint16(b) != swimMagic || b[2] != swimVersion {
+		return nil, ErrMalformedMessage
+	}
+
+	m := &swimMessage{
+		kind: b[3],
+		seq:  binary.BigEndian.Uint64(b[4:12]),
+	}
+	if m.kind < pingKind || m.kind > pushPullKind {
+		return nil, ErrMalformedMessage
+	}
+
+	rest := b[swimHeaderSize:]
+	var ok bool
+	for _, s := range []*string{&m.from, &m.target, &m.targetAddress} {
+		*s, rest, ok = readString(rest)
+		if !ok {
+			return nil, ErrMalformedMessage
+		}
+	}
+
+	if len(rest) < 2 {
+		return nil, ErrMalformedMessage
+	}
+	n := int(binary.BigEndian.Uint16(rest))
+	rest = rest[2:]
+
+	m.updates = make([]update, n)
+	for i := range m.updates {
+		if len(rest) < 9 {
+			return nil, ErrMalformedMessage
+		}
+		u := &m.updates[i]
+		u.state = MemberState(rest[0])
+		u.incarnation = binary.BigEndian.Uint64(rest[1:9])
+		if u.state > MemberLeft {
+			return nil, ErrMalformedMessage
+		}
+
+		u.id, rest, ok = readString(rest[9:])
+		if !ok {
+			return nil, ErrMalformedMessage
+		}
+		u.address, rest, ok = readString(rest)
+		if !ok {
+			return nil, ErrMalformedMessage
+		}
+	}
+	if len(rest) != 0 {
+		return nil, ErrMalformedMessage
+	}
+
+	return m, nil
+}
+
+// newIncarnation starts the incarnations of a process above the ones of
+// any earlier process with the same id
+func newIncarnation() uint64 {
+	return uint64(time.Now().UnixNano())
+}
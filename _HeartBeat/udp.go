@@ -0,0 +1,294 @@
+package heartbeat
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// Transport is how heartbeats travel
+type Transport int
+
+const (
+	// TCP sends the node ID as a line over a persistent connection
+	TCP Transport = iota
+	// UDP sends sequence numbered binary messages that the receiver
+	// acknowledges, a listener using it still accepts TCP heartbeats on
+	// the same port
+	UDP
+)
+
+func (t Transport) String() string {
+	switch t {
+	case TCP:
+		return "tcp"
+	case UDP:
+		return "udp"
+	}
+	return "unknown"
+}
+
+// rttWeight is the weight of a new sample in the smoothed round trip time
+const rttWeight = 8
+
+// PeerStats describes the heartbeats of a peer
+type PeerStats struct {
+	// Received counts the UDP beats accepted from the peer
+	Received uint64
+	// Lost counts the UDP beats missing between the accepted ones
+	Lost uint64
+	// Dropped counts the UDP beats that arrived out of order or twice
+	Dropped uint64
+	// RTT is the smoothed round trip time of the beats sent to the peer
+	// over UDP, 0 until the first acknowledgement
+	RTT time.Duration
+}
+
+// LossRate returns the fraction of the beats of the peer that were lost
+func (s PeerStats) LossRate() float64 {
+	if s.Received+s.Lost == 0 {
+		return 0
+	}
+	return float64(s.Lost) / float64(s.Received+s.Lost)
+}
+
+// Stats returns the heartbeat statistics of node id
+func (hm *HeartbeatClient) Stats(id string) (PeerStats, error) {
+	hm.mu.Lock()
+	node, ok := hm.nodes[id]
+	hm.mu.Unlock()
+	if !ok {
+		return PeerStats{}, ErrUnknownNode
+	}
+
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	return node.stats, nil
+}
+
+// serveUDP answers the beats arriving on pc until it is closed
+func (hm *HeartbeatClient) serveUDP(pc net.PacketConn) {
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			hm.logf("heartbeat: reading a datagram failed: %v", err)
+			continue
+		}
+
+		data := buf[:n]
+		if hm.Key != nil {
+			data, err = hm.open(data, hm.now())
+			if err != nil {
+				hm.reject(addr.String(), err)
+				continue
+			}
+		}
+
+		m, err := unmarshalMessage(data)
+		if err != nil || m.kind != beatMessage {
+			hm.logf("heartbeat: dropping a datagram from %s: %v", addr, ErrMalformedMessage)
+			continue
+		}
+		if !hm.receive(m, hm.now()) || !hm.grants(m.term) {
+			continue
+		}
+
+		ack := message{kind: ackMessage, incarnation: m.incarnation, seq: m.seq, sent: m.sent, term: m.term}
+		data, err = ack.marshal()
+		if err == nil && hm.Key != nil {
+			data = seal(hm.Key, data, hm.now())
+		}
+		if err == nil {
+			_, err = pc.WriteTo(data, addr)
+		}
+		if err != nil {
+			hm.logf("heartbeat: acknowledging %s failed: %v", m.id, err)
+			continue
+		}
+		hm.acknowledged(m.id, hm.now())
+	}
+}
+
+// receive records a beat that arrived over UDP. beats of an older
+// incarnation of the sender, and beats not newer than the last accepted
+// one are dropped.
+func (hm *HeartbeatClient) receive(m *message, now time.Time) bool {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+
+	node, ok := hm.nodes[m.id]
+	if !ok {
+		return false
+	}
+
+	node.mu.Lock()
+	defer node.mu.Unlock()
+
+	switch {
+	case m.incarnation > node.incarnation:
+		// the sender restarted, its sequence starts over
+		node.incarnation, node.seq = m.incarnation, 0
+	case m.incarnation < node.incarnation || m.seq <= node.seq:
+		node.stats.Dropped++
+		return false
+	}
+
+	if node.seq > 0 {
+		node.stats.Lost += m.seq - node.seq - 1
+	}
+	node.seq = m.seq
+	node.stats.Received++
+	hm.storePayload(node, m.payload, now)
+	hm.beat(node, now)
+
+	return true
+}
+
+// grants reports whether a beat of term is acknowledged. only beats of
+// the highest term seen are, so a deposed leader cannot extend its lease
+// once a node heard from its successor.
+func (hm *HeartbeatClient) grants(term uint64) bool {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+
+	if term < hm.term {
+		return false
+	}
+	hm.term = term
+	return true
+}
+
+// recordRTT adds a round trip time to the beats of node id
+func (hm *HeartbeatClient) recordRTT(id string, rtt time.Duration) {
+	hm.mu.Lock()
+	node, ok := hm.nodes[id]
+	hm.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	if node.stats.RTT == 0 {
+		node.stats.RTT = rtt
+		return
+	}
+	node.stats.RTT += (rtt - node.stats.RTT) / rttWeight
+}
+
+// sendUDP sends the next beat of p, conn is dialed first if needed.
+// p.mu is held.
+func (s *HeartbeatSender) sendUDP(p *peer) error {
+	if p.conn == nil {
+		conn, err := net.Dial("udp", p.address)
+		if err != nil {
+			p.failed(s.interval, s.registry.now())
+			return err
+		}
+		p.conn = conn
+		p.backoff = 0
+
+		s.acks.Add(1)
+		go s.readAcks(p, conn)
+	}
+
+	p.seq++
+	now := s.registry.now()
+	p.sent[p.seq%sentBeats] = sentBeat{seq: p.seq, at: now}
+	m := message{
+		kind:        beatMessage,
+		id:          s.id,
+		incarnation: s.incarnation,
+		seq:         p.seq,
+		sent:        now,
+	}
+	term, payload, err := s.payload()
+	if err != nil {
+		return err
+	}
+	m.term, m.payload = term, payload
+
+	data, err := m.marshal()
+	if err != nil {
+		return err
+	}
+	if s.Key != nil {
+		data = seal(s.Key, data, s.registry.now())
+		if len(data) > maxMessageSize {
+			return ErrMessageTooLarge
+		}
+	}
+
+	p.conn.SetWriteDeadline(time.Now().Add(s.interval))
+	_, err = p.conn.Write(data)
+	if err != nil {
+		p.conn.Close()
+		p.conn = nil
+		p.failed(s.interval, s.registry.now())
+		return err
+	}
+
+	return nil
+}
+
+// readAcks measures the round trip time of the beats acknowledged on conn
+// and tells the ack hooks about them until conn is closed
+func (s *HeartbeatSender) readAcks(p *peer, conn net.Conn) {
+	defer s.acks.Done()
+
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, err := conn.Read(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			// an unreachable peer is reported by the next write
+			continue
+		}
+
+		data := buf[:n]
+		if s.Key != nil {
+			// a replayed ack is harmless, it names a beat that was acked
+			data, _, _, err = unseal(s.Key, data, s.registry.now(), DefaultMaxClockSkew)
+			if err != nil {
+				continue
+			}
+		}
+
+		m, err := unmarshalMessage(data)
+		if err != nil || m.kind != ackMessage || m.incarnation != s.incarnation {
+			continue
+		}
+
+		// only the local record of when the beat was sent is trusted
+		p.mu.Lock()
+		beat := p.sent[m.seq%sentBeats]
+		p.mu.Unlock()
+		if beat.seq != m.seq {
+			continue
+		}
+
+		s.registry.recordRTT(p.id, s.registry.clock().Since(beat.at))
+		s.acked(p.id, beat.at)
+	}
+}
+
+// acknowledged records that this node acknowledged a beat of node id at
+// now
+func (hm *HeartbeatClient) acknowledged(id string, now time.Time) {
+	hm.mu.Lock()
+	node, ok := hm.nodes[id]
+	hm.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	node.mu.Lock()
+	node.lastAcked = now
+	node.mu.Unlock()
+}
@@ -0,0 +1,139 @@
+package heartbeat
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMessage(t *testing.T) {
+	m := message{
+		kind:        beatMessage,
+		id:          "node1",
+		incarnation: 7,
+		seq:         42,
+		sent:        time.Unix(0, 1234567890),
+		term:        3,
+		payload:     []byte("load=0.5"),
+	}
+
+	data, err := m.marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := unmarshalMessage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.kind != m.kind || got.id != m.id || got.incarnation != m.incarnation || got.seq != m.seq ||
+		!got.sent.Equal(m.sent) || got.term != m.term || !bytes.Equal(got.payload, m.payload) {
+		t.Errorf("expected %+v, got %+v", m, got)
+	}
+
+	for _, bad := range [][]byte{nil, data[:len(data)-1], append(data, 0), []byte("node1\n")} {
+		_, err := unmarshalMessage(bad)
+		if !errors.Is(err, ErrMalformedMessage) {
+			t.Errorf("expected %q to be refused, got %v", bad, err)
+		}
+	}
+
+	m.payload = make([]byte, maxMessageSize)
+	_, err = m.marshal()
+	if !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("expected an oversized payload to be refused, got %v", err)
+	}
+}
+
+func TestReceiveOrdering(t *testing.T) {
+	hb := NewHeartbeatClient(time.Second, time.Second)
+	hb.AddNode("node1", "")
+
+	receive := func(incarnation, seq uint64) bool {
+		return hb.receive(&message{kind: beatMessage, id: "node1", incarnation: incarnation, seq: seq}, time.Now())
+	}
+
+	for _, c := range []struct {
+		incarnation, seq uint64
+		accepted         bool
+	}{
+		{1, 1, true},
+		{1, 2, true},
+		{1, 2, false}, // duplicate
+		{1, 5, true},  // 3 and 4 lost
+		{1, 4, false}, // late
+		{0, 9, false}, // an older process
+		{2, 1, true},  // restarted, the sequence starts over
+		{2, 2, true},
+	} {
+		if got := receive(c.incarnation, c.seq); got != c.accepted {
+			t.Errorf("incarnation %d seq %d: expected accepted %v, got %v", c.incarnation, c.seq, c.accepted, got)
+		}
+	}
+
+	stats, err := hb.Stats("node1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Received != 5 || stats.Lost != 2 || stats.Dropped != 3 {
+		t.Errorf("expected 5 received, 2 lost and 3 dropped, got %+v", stats)
+	}
+	if rate := stats.LossRate(); rate != 2.0/7 {
+		t.Errorf("expected a loss rate of 2/7, got %f", rate)
+	}
+
+	if hb.receive(&message{kind: beatMessage, id: "stranger", incarnation: 1, seq: 1}, time.Now()) {
+		t.Error("expected a beat from an unknown node to be ignored")
+	}
+}
+
+func TestUDPTransport(t *testing.T) {
+	server := NewHeartbeatClient(time.Second, 10*time.Millisecond)
+	server.ListenAddress = "127.0.0.1:0"
+	server.Transport = UDP
+	server.AddNode("udp", "")
+	server.AddNode("tcp", "")
+
+	err := server.Start(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	registry := NewHeartbeatClient(time.Second, 10*time.Millisecond)
+	registry.AddNode("server", server.Addr().String())
+
+	udp := NewHeartbeatSender("udp", registry, 10*time.Millisecond)
+	udp.Transport = UDP
+	udp.Payload = func() Payload { return Payload{Values: map[string]string{"greeting": "hello"}} }
+	udp.Start()
+	defer udp.Stop()
+
+	// the listener still accepts TCP beats
+	tcp := NewHeartbeatSender("tcp", registry, 10*time.Millisecond)
+	tcp.Start()
+	defer tcp.Stop()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		stats, err := registry.Stats("server")
+		if err != nil {
+			t.Fatal(err)
+		}
+		received, _ := server.Stats("udp")
+		if stats.RTT > 0 && received.Received >= 5 && !lastBeat(server, "tcp").IsZero() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected UDP beats to be acknowledged and TCP beats to arrive, got %+v and %+v", stats, received)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	payload, ok, err := server.Payload("udp")
+	if err != nil || !ok || payload.Values["greeting"] != "hello" {
+		t.Errorf("expected the payload to arrive, got %+v %v %v", payload, ok, err)
+	}
+}
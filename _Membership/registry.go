@@ -0,0 +1,307 @@
+// Package membership keeps the list of the cluster's members on disk. the
+// members join and leave through a Registry, every change gets the next
+// version so the packages following the registry can tell which state
+// they hold.
+package membership
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/EncrypteID/Replicate-Partition-Next-Generation-Systems/_PubSub"
+)
+
+var (
+	ErrInvalidMember   = errors.New("a member needs an ID")
+	ErrNotMember       = errors.New("not a member of the cluster")
+	ErrVersionMismatch = errors.New("membership changed since the expected version")
+)
+
+// Role is what a member does in the cluster
+type Role string
+
+const (
+	// Voter counts towards quorums, the role of a member joining without one
+	Voter Role = "voter"
+	// Observer receives the cluster's data but does not vote
+	Observer Role = "observer"
+)
+
+// Member is a node of the cluster
+type Member struct {
+	ID      string `json:"id"`
+	Address string `json:"address"`
+	Role    Role   `json:"role"`
+	// Zone is the failure domain the member runs in, a rack or an
+	// availability zone
+	Zone string `json:"zone,omitempty"`
+	// Version is the membership version the member last changed at
+	Version uint64 `json:"version"`
+}
+
+// ChangeType is the kind of a membership change
+type ChangeType int
+
+const (
+	// Joined is a member added to the cluster
+	Joined ChangeType = iota
+	// Updated is a member that joined again with another address, role or
+	// zone
+	Updated
+	// Left is a member removed from the cluster
+	Left
+)
+
+func (t ChangeType) String() string {
+	switch t {
+	case Joined:
+		return "joined"
+	case Updated:
+		return "updated"
+	case Left:
+		return "left"
+	}
+	return "unknown"
+}
+
+// Change is one versioned change of the membership
+type Change struct {
+	Type   ChangeType
+	Member Member
+	// Version is the membership version the change produced
+	Version uint64
+}
+
+// Snapshot is the membership at a version
+type Snapshot struct {
+	Version uint64
+	// Members are ordered by ID
+	Members []Member
+}
+
+// JoinRequest adds a member, or changes the address, role or zone of one
+type JoinRequest struct {
+	Member Member
+	// IfVersion applies the request only while the membership is at that
+	// version, 0 applies it whatever the version
+	IfVersion uint64
+}
+
+// LeaveRequest removes a member
+type LeaveRequest struct {
+	ID string
+	// IfVersion applies the request only while the membership is at that
+	// version, 0 applies it whatever the version
+	IfVersion uint64
+}
+
+// Registry is the membership of the cluster persisted to a file, it is the
+// source of truth the heartbeat monitor and the quorums follow
+type Registry struct {
+	path string
+
+	mu      sync.Mutex
+	version uint64
+	members map[string]Member
+	changes pubsub.Hub[Change]
+}
+
+// state is the content of the registry file
+type state struct {
+	Version uint64   `json:"version"`
+	Members []Member `json:"members"`
+}
+
+// Open loads the registry persisted at path, a missing file is an empty
+// membership
+func Open(path string) (*Registry, error) {
+	r := &Registry{path: path, members: map[string]Member{}}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var s state
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	r.version = s.Version
+	for _, m := range s.Members {
+		r.members[m.ID] = m
+	}
+
+	return r, nil
+}
+
+// Join applies a join request and returns the resulting version. joining
+// again unchanged is not a change and keeps the version.
+func (r *Registry) Join(req JoinRequest) (uint64, error) {
+	m := req.Member
+	if m.ID == "" {
+		return 0, ErrInvalidMember
+	}
+	if m.Role == "" {
+		m.Role = Voter
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if req.IfVersion != 0 && req.IfVersion != r.version {
+		return r.version, ErrVersionMismatch
+	}
+
+	change := Joined
+	if old, ok := r.members[m.ID]; ok {
+		m.Version = old.Version
+		if old == m {
+			return r.version, nil
+		}
+		change = Updated
+	}
+
+	m.Version = r.version + 1
+	members := cloneMembers(r.members)
+	members[m.ID] = m
+	if err := r.commit(m.Version, members); err != nil {
+		return r.version, err
+	}
+
+	r.changes.Publish(Change{Type: change, Member: m, Version: m.Version})
+	return r.version, nil
+}
+
+// Leave applies a leave request and returns the resulting version
+func (r *Registry) Leave(req LeaveRequest) (uint64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if req.IfVersion != 0 && req.IfVersion != r.version {
+		return r.version, ErrVersionMismatch
+	}
+
+	m, ok := r.members[req.ID]
+	if !ok {
+		return r.version, ErrNotMember
+	}
+
+	m.Version = r.version + 1
+	members := cloneMembers(r.members)
+	delete(members, req.ID)
+	if err := r.commit(m.Version, members); err != nil {
+		return r.version, err
+	}
+
+	r.changes.Publish(Change{Type: Left, Member: m, Version: m.Version})
+	return r.version, nil
+}
+
+// commit persists the membership at version and then makes it current, a
+// failed write leaves the registry as it was. r.mu is held.
+func (r *Registry) commit(version uint64, members map[string]Member) error {
+	s := state{Version: version, Members: sortedMembers(members)}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFile(r.path, data); err != nil {
+		return err
+	}
+
+	r.version = version
+	r.members = members
+	return nil
+}
+
+// Version returns the version of the current membership
+func (r *Registry) Version() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.version
+}
+
+// Member returns the member id, false when it is not a member
+func (r *Registry) Member(id string) (Member, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m, ok := r.members[id]
+	return m, ok
+}
+
+// Snapshot returns the current membership
+func (r *Registry) Snapshot() Snapshot {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return Snapshot{Version: r.version, Members: sortedMembers(r.members)}
+}
+
+// Subscribe returns the current membership and a channel receiving every
+// later change in version order. the returned function ends the
+// subscription and closes the channel.
+func (r *Registry) Subscribe() (Snapshot, <-chan Change, func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	changes, cancel := r.changes.Subscribe()
+	return Snapshot{Version: r.version, Members: sortedMembers(r.members)}, changes, cancel
+}
+
+func cloneMembers(members map[string]Member) map[string]Member {
+	clone := make(map[string]Member, len(members)+1)
+	for id, m := range members {
+		clone[id] = m
+	}
+	return clone
+}
+
+func sortedMembers(members map[string]Member) []Member {
+	sorted := make([]Member, 0, len(members))
+	for _, m := range members {
+		sorted = append(sorted, m)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+	return sorted
+}
+
+// writeFile replaces the file at path with data, a crash leaves either the
+// old or the new content
+func writeFile(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	// the rename is durable once the directory is synced
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
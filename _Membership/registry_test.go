@@ -0,0 +1,123 @@
+package membership
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func next(t *testing.T, changes <-chan Change) Change {
+	t.Helper()
+
+	select {
+	case c := <-changes:
+		return c
+	case <-time.After(time.Second):
+		t.Fatal("expected a change")
+		return Change{}
+	}
+}
+
+func TestJoinLeave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "members.json")
+	r, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := r.Join(JoinRequest{Member: Member{ID: "node1", Address: "localhost:9001", Zone: "a"}})
+	if err != nil || v != 1 {
+		t.Fatalf("expected version 1, got %d %v", v, err)
+	}
+	m, _ := r.Member("node1")
+	if m.Role != Voter || m.Version != 1 {
+		t.Errorf("expected a voter changed at version 1, got %+v", m)
+	}
+
+	// joining again unchanged is no change
+	v, _ = r.Join(JoinRequest{Member: Member{ID: "node1", Address: "localhost:9001", Role: Voter, Zone: "a"}})
+	if v != 1 {
+		t.Errorf("expected the version to stay 1, got %d", v)
+	}
+
+	r.Join(JoinRequest{Member: Member{ID: "node2", Address: "localhost:9002", Role: Observer, Zone: "b"}})
+	v, _ = r.Join(JoinRequest{Member: Member{ID: "node1", Address: "localhost:9011", Zone: "a"}})
+	if v != 3 {
+		t.Errorf("expected the address change to make version 3, got %d", v)
+	}
+
+	if _, err := r.Leave(LeaveRequest{ID: "node3"}); !errors.Is(err, ErrNotMember) {
+		t.Errorf("expected ErrNotMember, got %v", err)
+	}
+	if _, err := r.Leave(LeaveRequest{ID: "node2", IfVersion: 2}); !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("expected ErrVersionMismatch, got %v", err)
+	}
+	v, err = r.Leave(LeaveRequest{ID: "node2", IfVersion: 3})
+	if err != nil || v != 4 {
+		t.Fatalf("expected version 4, got %d %v", v, err)
+	}
+
+	if _, err := r.Join(JoinRequest{}); !errors.Is(err, ErrInvalidMember) {
+		t.Errorf("expected ErrInvalidMember, got %v", err)
+	}
+
+	// the membership survives a restart
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := reopened.Snapshot()
+	want := Member{ID: "node1", Address: "localhost:9011", Role: Voter, Zone: "a", Version: 3}
+	if s.Version != 4 || len(s.Members) != 1 || s.Members[0] != want {
+		t.Errorf("expected %+v at version 4, got %+v", want, s)
+	}
+}
+
+func TestFailedWriteKeepsMembership(t *testing.T) {
+	r, err := Open(filepath.Join(t.TempDir(), "missing", "members.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.Join(JoinRequest{Member: Member{ID: "node1"}}); err == nil {
+		t.Fatal("expected the write to fail")
+	}
+	if _, ok := r.Member("node1"); ok || r.Version() != 0 {
+		t.Errorf("expected the failed join not to apply, got version %d", r.Version())
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	r, err := Open(filepath.Join(t.TempDir(), "members.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Join(JoinRequest{Member: Member{ID: "node1"}})
+
+	s, changes, cancel := r.Subscribe()
+	defer cancel()
+	if s.Version != 1 || len(s.Members) != 1 {
+		t.Fatalf("expected node1 at version 1, got %+v", s)
+	}
+
+	r.Join(JoinRequest{Member: Member{ID: "node2"}})
+	r.Join(JoinRequest{Member: Member{ID: "node1", Zone: "b"}})
+	r.Leave(LeaveRequest{ID: "node2"})
+
+	want := []struct {
+		typ ChangeType
+		id  string
+	}{{Joined, "node2"}, {Updated, "node1"}, {Left, "node2"}}
+	for i, w := range want {
+		c := next(t, changes)
+		if c.Type != w.typ || c.Member.ID != w.id || c.Version != uint64(i+2) {
+			t.Errorf("expected %s %s at version %d, got %+v", w.id, w.typ, i+2, c)
+		}
+	}
+
+	cancel()
+	if _, ok := <-changes; ok {
+		t.Error("expected the channel to close")
+	}
+}
@@ -0,0 +1,116 @@
+// Package pubsub hands values to subscribers without ever waiting for a
+// slow one. the heartbeat monitor publishes its membership events and the
+// membership registry its changes through it.
+package pubsub
+
+import "sync"
+
+// subscriber queues the values of one subscription so publishing never
+// waits for a slow reader, the queue is delivered in order
+type subscriber[T any] struct {
+	mu     sync.Mutex
+	queue  []T
+	closed bool
+	signal chan struct{}
+	out    chan T
+	done   chan struct{}
+}
+
+func newSubscriber[T any]() *subscriber[T] {
+	s := &subscriber[T]{
+		signal: make(chan struct{}, 1),
+		out:    make(chan T),
+		done:   make(chan struct{}),
+	}
+	go s.deliver()
+
+	return s
+}
+
+func (s *subscriber[T]) push(v T) {
+	s.mu.Lock()
+	if !s.closed {
+		s.queue = append(s.queue, v)
+	}
+	s.mu.Unlock()
+
+	select {
+	case s.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (s *subscriber[T]) deliver() {
+	defer close(s.out)
+
+	for {
+		s.mu.Lock()
+		queue := s.queue
+		s.queue = nil
+		s.mu.Unlock()
+
+		for _, v := range queue {
+			select {
+			case s.out <- v:
+			case <-s.done:
+				return
+			}
+		}
+
+		select {
+		case <-s.signal:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *subscriber[T]) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.closed {
+		s.closed = true
+		close(s.done)
+	}
+}
+
+// Hub hands the values published to it to its subscribers, the zero value
+// is ready to use
+type Hub[T any] struct {
+	mu          sync.Mutex
+	subscribers map[*subscriber[T]]struct{}
+}
+
+// Subscribe returns a channel receiving every value published from now
+// on. the returned function ends the subscription and closes the channel.
+func (h *Hub[T]) Subscribe() (<-chan T, func()) {
+	s := newSubscriber[T]()
+
+	h.mu.Lock()
+	if h.subscribers == nil {
+		h.subscribers = map[*subscriber[T]]struct{}{}
+	}
+	h.subscribers[s] = struct{}{}
+	h.mu.Unlock()
+
+	cancel := func() {
+		h.mu.Lock()
+		delete(h.subscribers, s)
+		h.mu.Unlock()
+		s.close()
+	}
+
+	return s.out, cancel
+}
+
+// Publish queues v for every subscriber, values published one after the
+// other are delivered in that order
+func (h *Hub[T]) Publish(v T) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for s := range h.subscribers {
+		s.push(v)
+	}
+}
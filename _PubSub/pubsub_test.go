@@ -0,0 +1,42 @@
+package pubsub
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHub(t *testing.T) {
+	var h Hub[int]
+
+	slow, cancelSlow := h.Subscribe()
+	fast, cancel := h.Subscribe()
+	defer cancel()
+
+	// nobody reads slow, publishing must not wait for it
+	for i := 1; i <= 100; i++ {
+		h.Publish(i)
+	}
+
+	for i := 1; i <= 100; i++ {
+		select {
+		case v := <-fast:
+			if v != i {
+				t.Fatalf("expected %d, got %d", i, v)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected value %d", i)
+		}
+	}
+
+	if v := <-slow; v != 1 {
+		t.Errorf("expected the slow subscriber to start at 1, got %d", v)
+	}
+	cancelSlow()
+	for range slow {
+	}
+
+	h.Publish(101)
+	if v := <-fast; v != 101 {
+		t.Errorf("expected 101 after a subscription ended, got %d", v)
+	}
+}
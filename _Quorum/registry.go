@@ -0,0 +1,85 @@
+package quorum
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/EncrypteID/Replicate-Partition-Next-Generation-Systems/_Membership"
+)
+
+// Follow builds the quorums from the voters of reg and keeps them in step
+// with the members joining and leaving until the returned function is
+// called. observers do not count towards a quorum. the quorum sizes are
+// recomputed from the voters on every change, replacing the ones q was
+// created with.
+func (q *Quorums) Follow(reg *membership.Registry) func() {
+	snapshot, changes, cancel := reg.Subscribe()
+
+	members := map[string]membership.Member{}
+	for _, m := range snapshot.Members {
+		members[m.ID] = m
+	}
+	q.setMembers(members)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for c := range changes {
+			if c.Type == membership.Left {
+				delete(members, c.Member.ID)
+			} else {
+				members[c.Member.ID] = c.Member
+			}
+			q.setMembers(members)
+		}
+	}()
+
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+// setMembers makes the voters of members the nodes of q, a node that stays
+// keeps its status. a write needs a majority of the voters and a read the
+// rest plus one, so every read overlaps every write.
+func (q *Quorums) setMembers(members map[string]membership.Member) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	existing := make(map[string]*Node, len(q.nodes))
+	for _, node := range q.nodes {
+		existing[node.ID] = node
+	}
+
+	nodes := make([]*Node, 0, len(members))
+	for id, m := range members {
+		if m.Role != membership.Voter {
+			continue
+		}
+		node, ok := existing[id]
+		if !ok {
+			node = &Node{ID: id, isAlive: true}
+		}
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+
+	q.nodes = nodes
+	q.NumPeers = len(nodes)
+	q.writeQuorum = len(nodes)/2 + 1
+	q.readQuorum = len(nodes) - q.writeQuorum + 1
+}
+
+// Members returns the IDs of the nodes the quorums are built from
+func (q *Quorums) Members() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	ids := make([]string, len(q.nodes))
+	for i, node := range q.nodes {
+		ids[i] = node.ID
+	}
+	return ids
+}
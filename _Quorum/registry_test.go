@@ -0,0 +1,86 @@
+package quorum
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/EncrypteID/Replicate-Partition-Next-Generation-Systems/_Membership"
+)
+
+func TestFollowRegistry(t *testing.T) {
+	reg, err := membership.Open(filepath.Join(t.TempDir(), "members.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		reg.Join(membership.JoinRequest{Member: membership.Member{ID: id}})
+	}
+	reg.Join(membership.JoinRequest{Member: membership.Member{ID: "o", Role: membership.Observer}})
+
+	quorum := NewQuorum(2, 2, 0)
+	stop := quorum.Follow(reg)
+	defer stop()
+
+	if got := quorum.Members(); !reflect.DeepEqual(got, []string{"a", "b", "c"}) {
+		t.Fatalf("expected the voters a, b and c, got %v", got)
+	}
+	if quorum.readQuorum != 2 || quorum.writeQuorum != 2 {
+		t.Errorf("expected quorums of 2 of 3 voters, got read %d write %d", quorum.readQuorum, quorum.writeQuorum)
+	}
+
+	quorum.setNodeStatus("a", false)
+	quorum.setNodeStatus("b", false)
+	if quorum.read() {
+		t.Error("expected read quorum to fail")
+	}
+
+	reg.Join(membership.JoinRequest{Member: membership.Member{ID: "d"}})
+	reg.Leave(membership.LeaveRequest{ID: "c"})
+
+	deadline := time.Now().Add(time.Second)
+	for !reflect.DeepEqual(quorum.Members(), []string{"a", "b", "d"}) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the voters a, b and d, got %v", quorum.Members())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// a and b are still down
+	if quorum.write() {
+		t.Error("expected write quorum to fail")
+	}
+	quorum.setNodeStatus("a", true)
+	if !quorum.write() || quorum.NumPeers != 3 {
+		t.Errorf("expected write quorum to succeed with 3 peers, got %d", quorum.NumPeers)
+	}
+}
+
+func TestFollowQuorumSizes(t *testing.T) {
+	for _, c := range []struct {
+		voters      int
+		read, write int
+	}{
+		{1, 1, 1},
+		{2, 1, 2},
+		{3, 2, 2},
+		{4, 2, 3},
+		{5, 3, 3},
+	} {
+		members := map[string]membership.Member{}
+		for i := 0; i < c.voters; i++ {
+			id := string(rune('a' + i))
+			members[id] = membership.Member{ID: id, Role: membership.Voter}
+		}
+
+		quorum := NewQuorum(1, 1, 0)
+		quorum.setMembers(members)
+		if quorum.readQuorum != c.read || quorum.writeQuorum != c.write {
+			t.Errorf("expected read %d and write %d of %d voters, got %d and %d", c.read, c.write, c.voters, quorum.readQuorum, quorum.writeQuorum)
+		}
+		if quorum.readQuorum+quorum.writeQuorum <= c.voters {
+			t.Errorf("expected reads and writes of %d voters to overlap", c.voters)
+		}
+	}
+}
@@ -0,0 +1,318 @@
+// Package client talks to a log server started with cmd/logserver. a
+// Client is safe for concurrent use, requests from all goroutines are
+// pipelined over one connection and matched to their responses by ID.
+// a lost connection is dialed again on the next request.
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	segmentedlog "github.com/EncrypteID/Replicate-Partition-Next-Generation-Systems/_Segmented-Log"
+	"github.com/EncrypteID/Replicate-Partition-Next-Generation-Systems/_Segmented-Log/logpb"
+)
+
+const (
+	minBackoff = 50 * time.Millisecond
+	maxBackoff = 5 * time.Second
+)
+
+var (
+	ErrClosed = errors.New("client is closed")
+	// ErrConnectionLost is returned when the connection broke while a
+	// request was in flight, the request may or may not have been applied
+	ErrConnectionLost = errors.New("connection to the log server was lost")
+)
+
+// ServerError is an error the server answered a request with
+type ServerError struct {
+	Message string
+}
+
+func (e *ServerError) Error() string {
+	return "logserver: " + e.Message
+}
+
+type Client struct {
+	addr string
+	done chan struct{}
+
+	// dialMu makes concurrent requests share one dial, mu guards the rest
+	dialMu  sync.Mutex
+	backoff time.Duration
+
+	mu     sync.Mutex
+	conn   *conn
+	nextID uint64
+	closed bool
+}
+
+// conn is one connection to the server with the requests waiting on it
+type conn struct {
+	net.Conn
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	pending map[uint64]chan *logpb.Response
+	err     error
+}
+
+// Dial connects to the log server at addr
+func Dial(ctx context.Context, addr string) (*Client, error) {
+	c := &Client{addr: addr, done: make(chan struct{})}
+
+	_, err := c.connection(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// connection returns the current connection, dialing a new one with
+// exponential backoff if there is none
+func (c *Client) connection(ctx context.Context) (*conn, error) {
+	c.dialMu.Lock()
+	defer c.dialMu.Unlock()
+
+	for {
+		c.mu.Lock()
+		cn, closed := c.conn, c.closed
+		c.mu.Unlock()
+
+		if closed {
+			return nil, ErrClosed
+		}
+		if cn != nil {
+			return cn, nil
+		}
+
+		var d net.Dialer
+		nc, err := d.DialContext(ctx, "tcp", c.addr)
+		if err == nil {
+			c.backoff = 0
+			cn = &conn{Conn: nc, pending: map[uint64]chan *logpb.Response{}}
+
+			c.mu.Lock()
+			if c.closed {
+				c.mu.Unlock()
+				nc.Close()
+				return nil, ErrClosed
+			}
+			c.conn = cn
+			c.mu.Unlock()
+
+			go c.readResponses(cn)
+			return cn, nil
+		}
+
+		if c.backoff == 0 {
+			c.backoff = minBackoff
+		} else if c.backoff *= 2; c.backoff > maxBackoff {
+			c.backoff = maxBackoff
+		}
+
+		timer := time.NewTimer(c.backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-c.done:
+			timer.Stop()
+			return nil, ErrClosed
+		case <-timer.C:
+		}
+	}
+}
+
+// readResponses hands every response read from cn to the request waiting
+// for it until the connection breaks, the waiting requests then fail
+func (c *Client) readResponses(cn *conn) {
+	for {
+		resp := &logpb.Response{}
+		err := logpb.ReadFrame(cn, resp)
+		if err != nil {
+			c.drop(cn, err)
+			return
+		}
+
+		cn.mu.Lock()
+		ch, ok := cn.pending[resp.Id]
+		delete(cn.pending, resp.Id)
+		cn.mu.Unlock()
+
+		if ok {
+			ch <- resp
+		}
+	}
+}
+
+// drop closes a broken connection and fails the requests waiting on it
+func (c *Client) drop(cn *conn, err error) {
+	c.mu.Lock()
+	if c.conn == cn {
+		c.conn = nil
+	}
+	c.mu.Unlock()
+
+	cn.Close()
+
+	cn.mu.Lock()
+	if cn.err == nil {
+		cn.err = err
+	}
+	for id, ch := range cn.pending {
+		delete(cn.pending, id)
+		close(ch)
+	}
+	cn.mu.Unlock()
+}
+
+// do sends req and waits for its response
+func (c *Client) do(ctx context.Context, req *logpb.Request) (*logpb.Response, error) {
+	cn, err := c.connection(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.nextID++
+	req.Id = c.nextID
+	c.mu.Unlock()
+
+	ch := make(chan *logpb.Response, 1)
+	cn.mu.Lock()
+	if cn.err != nil {
+		cn.mu.Unlock()
+		return nil, ErrConnectionLost
+	}
+	cn.pending[req.Id] = ch
+	cn.mu.Unlock()
+
+	cn.writeMu.Lock()
+	if deadline, ok := ctx.Deadline(); ok {
+		cn.SetWriteDeadline(deadline)
+	} else {
+		cn.SetWriteDeadline(time.Time{})
+	}
+	err = logpb.WriteFrame(cn, req)
+	cn.writeMu.Unlock()
+	if err != nil {
+		c.drop(cn, err)
+		return nil, ErrConnectionLost
+	}
+
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			return nil, ErrConnectionLost
+		}
+		if resp.Error != "" {
+			return nil, &ServerError{Message: resp.Error}
+		}
+		return resp, nil
+	case <-ctx.Done():
+		cn.mu.Lock()
+		delete(cn.pending, req.Id)
+		cn.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// retry sends an idempotent request, it is sent once more on a new
+// connection if the first one broke
+func (c *Client) retry(ctx context.Context, req *logpb.Request) (*logpb.Response, error) {
+	resp, err := c.do(ctx, req)
+	if errors.Is(err, ErrConnectionLost) {
+		return c.do(ctx, req)
+	}
+
+	return resp, err
+}
+
+// Produce appends records as one batch and returns the recordID of the
+// first one. it is not retried, after ErrConnectionLost the records may
+// have been appended.
+func (c *Client) Produce(ctx context.Context, records []segmentedlog.Record) (uint64, error) {
+	req := &logpb.ProduceRequest{}
+	for _, r := range records {
+		req.Records = append(req.Records, logpb.FromRecord(r))
+	}
+
+	resp, err := c.do(ctx, &logpb.Request{Produce: req})
+	if err != nil {
+		return 0, err
+	}
+
+	return resp.Produce.BaseId, nil
+}
+
+// Fetch returns records from fromID on, up to maxBytes of keys and values
+// but at least one. if there are none yet it waits up to maxWait for them.
+// the last recordID the server can serve is returned as well.
+func (c *Client) Fetch(ctx context.Context, fromID uint64, maxBytes uint64, maxWait time.Duration) ([]segmentedlog.Record, uint64, error) {
+	resp, err := c.retry(ctx, &logpb.Request{Fetch: &logpb.FetchRequest{
+		FromId:    fromID,
+		MaxBytes:  maxBytes,
+		MaxWaitMs: maxWait.Milliseconds(),
+	}})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	records := make([]segmentedlog.Record, len(resp.Fetch.Records))
+	for i, r := range resp.Fetch.Records {
+		records[i] = r.ToRecord()
+	}
+
+	return records, resp.Fetch.LastId, nil
+}
+
+// ListSegments returns the manifest entries of every segment of the log
+func (c *Client) ListSegments(ctx context.Context) ([]segmentedlog.SegmentInfo, error) {
+	resp, err := c.retry(ctx, &logpb.Request{ListSegments: &logpb.ListSegmentsRequest{}})
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]segmentedlog.SegmentInfo, len(resp.ListSegments.Segments))
+	for i, info := range resp.ListSegments.Segments {
+		infos[i] = info.ToSegmentInfo()
+	}
+
+	return infos, nil
+}
+
+// Truncate deletes the segments holding only records before beforeID and
+// returns the first recordID still stored
+func (c *Client) Truncate(ctx context.Context, beforeID uint64) (uint64, error) {
+	resp, err := c.retry(ctx, &logpb.Request{Truncate: &logpb.TruncateRequest{BeforeId: beforeID}})
+	if err != nil {
+		return 0, err
+	}
+
+	return resp.Truncate.FirstId, nil
+}
+
+// Close closes the connection, requests in flight fail
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	close(c.done)
+	cn := c.conn
+	c.conn = nil
+	c.mu.Unlock()
+
+	if cn != nil {
+		c.drop(cn, ErrClosed)
+	}
+
+	return nil
+}
@@ -0,0 +1,233 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	segmentedlog "github.com/EncrypteID/Replicate-Partition-Next-Generation-Systems/_Segmented-Log"
+	"github.com/EncrypteID/Replicate-Partition-Next-Generation-Systems/_Segmented-Log/server"
+)
+
+// serve runs a server for l on addr until the returned stop is called
+func serve(t *testing.T, l *segmentedlog.Log, addr string) (string, func()) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := server.New(l)
+	go srv.Serve(ln)
+
+	return ln.Addr().String(), func() { srv.Close() }
+}
+
+func openLog(t *testing.T) *segmentedlog.Log {
+	t.Helper()
+
+	// small segments so a few records already roll them
+	cfg := segmentedlog.DefaultConfig()
+	cfg.Segment.MaxStoreSizeBytes = 1 << 10
+	cfg.Segment.MaxIndexSizeBytes = 1 << 10
+	cfg.Segment.IndexIntervalBytes = 0
+
+	l, err := segmentedlog.Open(t.TempDir(), &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	return l
+}
+
+func TestProduceFetch(t *testing.T) {
+	ctx := context.Background()
+	addr, stop := serve(t, openLog(t), "127.0.0.1:0")
+	defer stop()
+
+	c, err := Dial(ctx, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	id, err := c.Produce(ctx, []segmentedlog.Record{
+		{Key: []byte("a"), Value: []byte("one"), Headers: []segmentedlog.Header{{Key: "h", Value: []byte("v")}}},
+		{Key: []byte("a")},
+	})
+	if err != nil || id != 1 {
+		t.Fatalf("expected the batch at 1, got %d %v", id, err)
+	}
+
+	records, last, err := c.Fetch(ctx, 1, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last != 2 || len(records) != 2 {
+		t.Fatalf("expected 2 records up to 2, got %d up to %d", len(records), last)
+	}
+	if string(records[0].Value) != "one" || records[0].Headers[0].Key != "h" || records[0].Timestamp.IsZero() {
+		t.Errorf("unexpected first record %+v", records[0])
+	}
+	if records[1].Value != nil {
+		t.Errorf("expected the tombstone to stay a tombstone, got %q", records[1].Value)
+	}
+
+	// a fetch past the end waits for the next produce
+	fetched := make(chan []segmentedlog.Record, 1)
+	go func() {
+		records, _, err := c.Fetch(ctx, 3, 0, 5*time.Second)
+		if err != nil {
+			t.Error(err)
+		}
+		fetched <- records
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	_, err = c.Produce(ctx, []segmentedlog.Record{{Value: []byte("three")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case records := <-fetched:
+		if len(records) != 1 || string(records[0].Value) != "three" {
+			t.Errorf("expected the long poll to return record 3, got %+v", records)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("long poll did not return after the produce")
+	}
+
+	records, _, err = c.Fetch(ctx, 4, 0, 20*time.Millisecond)
+	if err != nil || len(records) != 0 {
+		t.Errorf("expected an empty fetch once the wait is over, got %d %v", len(records), err)
+	}
+}
+
+func TestPipelining(t *testing.T) {
+	ctx := context.Background()
+	addr, stop := serve(t, openLog(t), "127.0.0.1:0")
+	defer stop()
+
+	c, err := Dial(ctx, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	ids := make(chan uint64, 100)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			id, err := c.Produce(ctx, []segmentedlog.Record{{Value: []byte(fmt.Sprintf("record-%d", i))}})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			ids <- id
+		}(i)
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := map[uint64]bool{}
+	for id := range ids {
+		if seen[id] {
+			t.Errorf("id %d handed out twice", id)
+		}
+		seen[id] = true
+	}
+	if len(seen) != 100 {
+		t.Errorf("expected 100 ids, got %d", len(seen))
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	l := openLog(t)
+	addr, stop := serve(t, l, "127.0.0.1:0")
+
+	c, err := Dial(ctx, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	_, err = c.Produce(ctx, []segmentedlog.Record{{Value: []byte("before")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stop()
+	short, cancelShort := context.WithTimeout(ctx, 200*time.Millisecond)
+	_, err = c.Produce(short, []segmentedlog.Record{{Value: []byte("lost")}})
+	cancelShort()
+	if err == nil {
+		t.Fatal("expected a produce to a stopped server to fail")
+	}
+
+	_, stop = serve(t, l, addr)
+	defer stop()
+
+	records, _, err := c.Fetch(ctx, 1, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || string(records[0].Value) != "before" {
+		t.Errorf("expected the record produced before the restart, got %+v", records)
+	}
+}
+
+func TestListSegmentsTruncate(t *testing.T) {
+	ctx := context.Background()
+	addr, stop := serve(t, openLog(t), "127.0.0.1:0")
+	defer stop()
+
+	c, err := Dial(ctx, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for i := 1; i <= 100; i++ {
+		_, err := c.Produce(ctx, []segmentedlog.Record{{Value: []byte(fmt.Sprintf("record-%d", i))}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	infos, err := c.ListSegments(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) < 2 || infos[0].State != segmentedlog.SegmentSealed || infos[len(infos)-1].State != segmentedlog.SegmentActive {
+		t.Fatalf("expected sealed segments and an active one, got %+v", infos)
+	}
+
+	first, err := c.Truncate(ctx, infos[1].BaseID)
+	if err != nil || first != infos[1].BaseID {
+		t.Fatalf("expected the log to start at %d, got %d %v", infos[1].BaseID, first, err)
+	}
+
+	records, _, err := c.Fetch(ctx, 1, 1, 0)
+	if err != nil || len(records) != 1 || records[0].ID != first {
+		t.Errorf("expected the fetch to start at %d, got %+v %v", first, records, err)
+	}
+
+	c.Close()
+	_, err = c.ListSegments(ctx)
+	if !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+}
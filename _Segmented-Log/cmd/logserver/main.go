@@ -0,0 +1,67 @@
+// Command logserver runs a segmented log as a standalone service. it
+// serves Produce, Fetch, ListSegments and Truncate over TCP, see the
+// server and logpb packages for the protocol and the client package for a
+// Go client.
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	segmentedlog "github.com/EncrypteID/Replicate-Partition-Next-Generation-Systems/_Segmented-Log"
+	"github.com/EncrypteID/Replicate-Partition-Next-Generation-Systems/_Segmented-Log/server"
+)
+
+func main() {
+	addr := flag.String("addr", ":7070", "address to listen on")
+	dir := flag.String("dir", "data", "directory holding the log")
+	config := flag.String("config", "", "JSON or YAML log config, SEGMENTEDLOG_* variables override it")
+	flag.Parse()
+
+	cfg, err := segmentedlog.LoadConfig(*config)
+	if err != nil {
+		log.Fatalf("logserver: %v", err)
+	}
+
+	l, err := segmentedlog.Open(*dir, &cfg)
+	if err != nil {
+		log.Fatalf("logserver: opening %s: %v", *dir, err)
+	}
+
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		l.Close()
+		log.Fatalf("logserver: %v", err)
+	}
+
+	srv := server.New(l)
+	closed := make(chan struct{})
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		<-signals
+
+		srv.Close()
+		close(closed)
+	}()
+
+	log.Printf("logserver: serving %s on %s", *dir, ln.Addr())
+	err = srv.Serve(ln)
+	if err != server.ErrServerClosed {
+		log.Printf("logserver: %v", err)
+		srv.Close()
+	} else {
+		// Serve returns once the listener is closed, Close returns once
+		// the requests still being answered are done with the log
+		<-closed
+	}
+
+	err = l.Close()
+	if err != nil {
+		log.Fatalf("logserver: closing the log: %v", err)
+	}
+}
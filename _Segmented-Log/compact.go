@@ -0,0 +1,269 @@
+package segmentedlog
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// a compacted segment is first written next to the original under the
+// cleaned suffix. once it is complete a swap marker is created, from that
+// point the cleaned files replace the original even if the process crashes
+// before the renames are done, Open finishes them
+const (
+	cleanedSuffix = ".cleaned"
+	swapSuffix    = ".swap"
+)
+
+var segmentSuffixes = []string{indexSuffix, storeSuffix, timeIndexSuffix, keyIndexSuffix}
+
+var ErrCompactVerifiable = errors.New("a log with a merkle tree cannot be compacted")
+
+// Compact rewrites the sealed segments so only the latest record of every
+// key survives. records without a key are always kept and tombstones are
+// kept until they are older than Compaction.TombstoneRetention. record IDs
+// do not change, compacted segments simply have gaps.
+func (l *Log) Compact() error {
+	if l.tree != nil {
+		return ErrCompactVerifiable
+	}
+
+	l.maintenanceMu.Lock()
+	defer l.maintenanceMu.Unlock()
+
+	// sealed segments are never written again, so they can be read without
+	// holding the log lock while appends continue on the active segment
+	v := l.view.Load()
+	sealed := v.segments[:len(v.segments)-1]
+
+	latest := make(map[string]uint64)
+	for _, s := range sealed {
+		err := s.scan(func(b *batch) error {
+			for _, r := range b.records {
+				if r.Key != nil {
+					latest[string(r.Key)] = r.ID
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	deadline := time.Now().Add(-l.cfg.Compaction.TombstoneRetention)
+	keep := func(r Record) bool {
+		if r.Key == nil {
+			return true
+		}
+		if latest[string(r.Key)] != r.ID {
+			return false
+		}
+
+		return r.Value != nil || r.Timestamp.After(deadline)
+	}
+
+	for _, s := range sealed {
+		err := l.compactSegment(s, keep)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// compactSegment rewrites s without the records rejected by keep and swaps
+// the result in place of s
+func (l *Log) compactSegment(s *segmentedlog, keep func(Record) bool) error {
+	var dropped, kept int
+	err := s.scan(func(b *batch) error {
+		for _, r := range b.records {
+			if keep(r) {
+				kept++
+			} else {
+				dropped++
+			}
+		}
+		return nil
+	})
+	if err != nil || dropped == 0 {
+		return err
+	}
+
+	base := s.baseID()
+	if kept == 0 {
+		return l.replaceSegment(s, nil)
+	}
+
+	cleanedPath := l.segmentPath(base) + cleanedSuffix
+	cleaned, err := createSegment(cleanedPath, base, l.cfg)
+	if err != nil {
+		return err
+	}
+
+	err = s.scan(func(b *batch) error {
+		nb := &batch{attributes: b.attributes}
+		for _, r := range b.records {
+			if !keep(r) {
+				continue
+			}
+
+			ts := timestamp(r.Timestamp)
+			if len(nb.records) == 0 {
+				nb.baseID = r.ID
+				nb.baseTimestamp = ts
+			}
+			if ts < nb.baseTimestamp {
+				nb.baseTimestamp = ts
+			}
+			if ts > nb.maxTimestamp {
+				nb.maxTimestamp = ts
+			}
+			nb.records = append(nb.records, r)
+		}
+		if len(nb.records) == 0 {
+			return nil
+		}
+
+		return cleaned.writeBatch(nb)
+	})
+	if err == nil {
+		err = cleaned.seal()
+	}
+	if err == nil {
+		err = cleaned.close()
+	}
+	if err != nil {
+		_ = cleaned.remove()
+		return err
+	}
+
+	marker, err := os.Create(l.segmentPath(base) + swapSuffix)
+	if err != nil {
+		return err
+	}
+	err = marker.Sync()
+	if err != nil {
+		marker.Close()
+		return err
+	}
+	err = marker.Close()
+	if err != nil {
+		return err
+	}
+
+	return l.replaceSegment(s, func() (*segmentedlog, error) {
+		err := finishSwap(l.segmentPath(base))
+		if err != nil {
+			return nil, err
+		}
+
+		return openSealedSegment(l.segmentPath(base), base, l.cfg)
+	})
+}
+
+// replaceSegment closes s and puts the sealed segment returned by open in
+// its place, its manifest entry gets the new size and checksum. a nil open
+// deletes s from the log.
+func (l *Log) replaceSegment(s *segmentedlog, open func() (*segmentedlog, error)) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	pos := -1
+	for i, seg := range l.segments {
+		if seg == s {
+			pos = i
+		}
+	}
+	if pos == -1 {
+		return errors.New("segment is no longer part of the log")
+	}
+
+	// s is retired rather than closed, readers that loaded it before the
+	// new view was published keep reading the files they opened
+	if open == nil {
+		err := s.remove()
+		if err != nil {
+			return err
+		}
+
+		l.segments = append(l.segments[:pos], l.segments[pos+1:]...)
+		delete(l.infos, s.baseID())
+		l.publish()
+		s.retire()
+
+		return l.saveManifest()
+	}
+
+	ns, err := open()
+	if err != nil {
+		return err
+	}
+
+	l.segments[pos] = ns
+	l.publish()
+	s.retire()
+
+	err = l.updateChecksum(ns, l.infos[ns.baseID()])
+	if err != nil {
+		return err
+	}
+
+	return l.saveManifest()
+}
+
+// finishSwap moves the cleaned files of the segment at path over the
+// originals and drops the swap marker. it is safe to call again after a
+// crash, files that were already moved are skipped
+func finishSwap(path string) error {
+	for _, suffix := range segmentSuffixes {
+		err := os.Rename(path+cleanedSuffix+suffix, path+suffix)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	return os.Remove(path + swapSuffix)
+}
+
+// recoverCompaction completes swaps that were committed before a crash and
+// removes cleaned files of compactions that never got that far. it returns
+// the base IDs of the swapped segments, their manifest entries are stale.
+func recoverCompaction(dir string) (map[uint64]bool, error) {
+	markers, err := filepath.Glob(filepath.Join(dir, "*"+swapSuffix))
+	if err != nil {
+		return nil, err
+	}
+
+	swapped := map[uint64]bool{}
+	for _, marker := range markers {
+		path := strings.TrimSuffix(marker, swapSuffix)
+		err = finishSwap(path)
+		if err != nil {
+			return nil, err
+		}
+
+		id, err := strconv.ParseUint(filepath.Base(path), 10, 64)
+		if err == nil {
+			swapped[id] = true
+		}
+	}
+
+	leftovers, err := filepath.Glob(filepath.Join(dir, "*"+cleanedSuffix+".*"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range leftovers {
+		err = os.Remove(file)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return swapped, nil
+}
@@ -0,0 +1,145 @@
+package segmentedlog
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCompact(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, &testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	latest := map[string]uint64{}
+	for i := 0; i < 60; i++ {
+		key := fmt.Sprintf("key-%d", i%3)
+		id, err := l.AppendBatch([]Record{{Key: []byte(key), Value: []byte(fmt.Sprint(i))}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		latest[key] = id
+	}
+
+	unkeyed, err := l.Append([]byte("no key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old := time.Now().Add(-48 * time.Hour)
+	tombstone, err := l.AppendBatch([]Record{{Key: []byte("gone"), Timestamp: old}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fresh, err := l.AppendBatch([]Record{{Key: []byte("deleted")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// push everything above out of the active segment
+	for i := 0; i < 40; i++ {
+		_, err := l.Append([]byte("filler"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	segments := len(l.segments)
+	err = l.Compact()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l.segments) >= segments {
+		t.Errorf("expected fully compacted segments to be dropped, had %d, got %d", segments, len(l.segments))
+	}
+
+	for key, id := range latest {
+		r, err := l.Read(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(r.Key) != key || r.ID != id {
+			t.Errorf("expected %s at %d, got %s at %d", key, id, r.Key, r.ID)
+		}
+	}
+
+	_, err = l.Read(1)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("expected superseded record to be removed, got %v", err)
+	}
+	_, err = l.Read(tombstone)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("expected expired tombstone to be removed, got %v", err)
+	}
+	_, err = l.Read(unkeyed)
+	if err != nil {
+		t.Errorf("expected record without key to be kept, got %v", err)
+	}
+	r, err := l.Read(fresh)
+	if err != nil || r.Value != nil {
+		t.Errorf("expected fresh tombstone to be kept, got %v", err)
+	}
+
+	err = l.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err = Open(dir, &testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	for key, id := range latest {
+		r, err := l.Read(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(r.Key) != key {
+			t.Errorf("expected %s after reopen, got %s", key, r.Key)
+		}
+	}
+}
+
+func TestOpenRemovesUncommittedCompaction(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, &testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = l.Append([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = l.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leftover := l.segmentPath(1) + cleanedSuffix + storeSuffix
+	err = os.WriteFile(leftover, []byte("partial"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err = Open(dir, &testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	_, err = os.Stat(leftover)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Error("expected cleaned files without a swap marker to be removed")
+	}
+
+	r, err := l.Read(1)
+	if err != nil || string(r.Value) != "hello" {
+		t.Errorf("expected original record to survive, got %q %v", r.Value, err)
+	}
+}
@@ -0,0 +1,216 @@
+package segmentedlog
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stressLog runs one appender against readers calling Read, ReadFromTime
+// and an Iterator until the appender has written records records. extra
+// runs in a loop next to them, for maintenance that retires segments.
+// gone reports whether a missing record may have been deleted on purpose.
+func stressLog(t *testing.T, l *Log, records int, extra func() error, gone func(id uint64) bool) {
+	t.Helper()
+
+	done := make(chan struct{})
+	errs := make(chan error, 16)
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(done)
+
+		next := uint64(1)
+		for next <= uint64(records) {
+			batch := make([]Record, 1+rand.Intn(4))
+			for i := range batch {
+				batch[i].Value = []byte(fmt.Sprintf("record-%d", next+uint64(i)))
+			}
+
+			id, err := l.AppendBatch(batch)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if id != next {
+				errs <- fmt.Errorf("expected batch at %d, got %d", next, id)
+				return
+			}
+			next += uint64(len(batch))
+		}
+	}()
+
+	check := func(id uint64, r Record, err error) error {
+		if errors.Is(err, ErrRecordNotFound) && gone != nil && gone(id) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading %d: %w", id, err)
+		}
+		if want := fmt.Sprintf("record-%d", id); string(r.Value) != want {
+			return fmt.Errorf("expected %s, got %s", want, r.Value)
+		}
+		return nil
+	}
+
+	for n := 0; n < 4; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				last := l.LastID()
+				if last == 0 {
+					continue
+				}
+
+				id := 1 + uint64(rand.Int63n(int64(last)))
+				r, err := l.Read(id)
+				if err := check(id, r, err); err != nil {
+					errs <- err
+					return
+				}
+
+				_, err = l.ReadFromTime(time.Now().Add(-time.Millisecond))
+				if err != nil && !errors.Is(err, ErrRecordNotFound) {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		it := l.Iterator(1)
+		var prev uint64
+		for {
+			for it.Next() {
+				r := it.Record()
+				if r.ID <= prev {
+					errs <- fmt.Errorf("iterator went back from %d to %d", prev, r.ID)
+					return
+				}
+				if err := check(r.ID, r, nil); err != nil {
+					errs <- err
+					return
+				}
+				prev = r.ID
+			}
+			if it.Err() != nil && (gone == nil || !errors.Is(it.Err(), ErrRecordNotFound)) {
+				errs <- it.Err()
+				return
+			}
+
+			select {
+			case <-done:
+				return
+			default:
+			}
+		}
+	}()
+
+	if extra != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				err := extra()
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestConcurrentReaders(t *testing.T) {
+	l, err := Open(t.TempDir(), &testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	stressLog(t, l, 2000, nil, nil)
+}
+
+func TestConcurrentReadersBuffered(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.Flush.MaxBufferedBytes = 256
+
+	l, err := Open(t.TempDir(), &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	stressLog(t, l, 2000, l.Flush, nil)
+}
+
+func TestConcurrentMaintenance(t *testing.T) {
+	objects, err := NewDirStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := newTestConfig()
+	cfg.Tiering.Store = objects
+	cfg.Tiering.KeepLocalSegments = 2
+	cfg.Tiering.CacheSegments = 2
+	cfg.Retention.MaxBytes = 16 << 10
+
+	l, err := Open(t.TempDir(), &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	maintain := func() error {
+		err := l.Compact()
+		if err == nil {
+			err = l.Offload()
+		}
+		if err == nil {
+			err = l.EnforceRetention()
+		}
+		if err == nil {
+			err = l.Verify()
+		}
+		return err
+	}
+
+	// retention deletes the oldest records while they are being read
+	gone := func(id uint64) bool {
+		infos := l.Segments()
+		return id < infos[0].BaseID
+	}
+
+	stressLog(t, l, 2000, maintain, gone)
+}
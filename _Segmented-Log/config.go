@@ -1,21 +1,430 @@
 package segmentedlog
 
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
 
+	"gopkg.in/yaml.v3"
+)
+
+// production defaults, see DefaultConfig
 const (
-	defaultStoreSize = 1 << 10
-	defaultIndexSize = 1 << 10
+	defaultStoreSize          = 1 << 30
+	defaultIndexSize          = 10 << 20
+	defaultIndexInterval      = 4 << 10
+	defaultSegmentMaxAge      = 7 * 24 * time.Hour
+	defaultRetentionInterval  = 5 * time.Minute
+	defaultCompactionInterval = time.Minute
+	defaultTombstoneRetention = 24 * time.Hour
+	defaultCacheSegments      = 4
 )
 
-// Config stores embedded log configuration data
-// MaxIndexSizeBytes should be multiple of 16
+// envPrefix starts the environment variables LoadConfig reads, the rest of
+// the name is the key path in upper case, e.g. SEGMENTEDLOG_SEGMENT_MAX_AGE
+const envPrefix = "SEGMENTEDLOG"
+
+// Config stores embedded log configuration data. the json names are used
+// in config files and environment variables as well, sizes can be given as
+// plain bytes or with a unit like "64MiB" and durations like "168h".
 type Config struct {
+	// InitialID is the recordID of the first record of a new log, 0 means
+	// 1. it is ignored once the log exists.
+	InitialID uint64 `json:"initial_id"`
+	// Segment limits the active segment, it is sealed and a new one started
+	// once its store or index is full or it is older than MaxAge.
+	// MaxIndexSizeBytes has to be a multiple of 16. with IndexIntervalBytes
+	// set only the first batch after every IndexIntervalBytes of the store
+	// is indexed and lookups scan forward from there, 0 indexes every record.
 	Segment struct {
-		MaxStoreSizeBytes uint64
-		MaxIndexSizeBytes uint64
+		MaxStoreSizeBytes  uint64        `json:"max_store_bytes"`
+		MaxIndexSizeBytes  uint64        `json:"max_index_bytes"`
+		MaxAge             time.Duration `json:"max_age"`
+		IndexIntervalBytes uint64        `json:"index_interval_bytes"`
+	} `json:"segment"`
+	// Flush controls when appends reach the disk. with MaxBufferedBytes set
+	// appends are buffered and written once that many bytes are pending,
+	// every Interval or on Log.Flush. 0 syncs every append.
+	Flush struct {
+		MaxBufferedBytes uint64        `json:"max_buffered_bytes"`
+		Interval         time.Duration `json:"interval"`
+	} `json:"flush"`
+	// Tiering offloads the oldest sealed segments to Store once more than
+	// KeepLocalSegments sealed segments are on disk, every Interval or on
+	// Log.Offload. up to CacheSegments offloaded segments are kept locally
+	// after they were fetched back for a read. Store can only be set in
+	// code. object names start with Prefix, logs sharing a store need
+	// different ones.
+	Tiering struct {
+		Store             ObjectStore   `json:"-"`
+		Prefix            string        `json:"prefix"`
+		KeepLocalSegments int           `json:"keep_local_segments"`
+		CacheSegments     int           `json:"cache_segments"`
+		Interval          time.Duration `json:"interval"`
+	} `json:"tiering"`
+	// Retention deletes the oldest sealed segments, local or offloaded, while
+	// the log holds more than MaxBytes or their records are older than
+	// MaxAge. segments with records a consumer group has not committed yet
+	// are kept. it runs every Interval or on Log.EnforceRetention, 0 values
+	// disable a limit.
+	Retention struct {
+		MaxBytes uint64        `json:"max_bytes"`
+		MaxAge   time.Duration `json:"max_age"`
+		Interval time.Duration `json:"interval"`
+	} `json:"retention"`
+	// Compaction keeps only the latest record per key in sealed segments,
+	// tombstones are dropped once they are older than TombstoneRetention
+	Compaction struct {
+		Enabled            bool          `json:"enabled"`
+		Interval           time.Duration `json:"interval"`
+		TombstoneRetention time.Duration `json:"tombstone_retention"`
+	} `json:"compaction"`
+	// Merkle keeps an RFC 6962 Merkle tree over the records so their
+	// inclusion and the append only history of the log can be proven, see
+	// Log.TreeHead. tree heads are signed with SigningKey, or a key
+	// generated next to the tree when it is nil. compaction rewrites
+	// records and cannot be enabled with it.
+	Merkle struct {
+		Enabled    bool               `json:"enabled"`
+		SigningKey ed25519.PrivateKey `json:"-"`
+	} `json:"merkle"`
+}
+
+// DefaultConfig returns the configuration Open uses without one:
+//
+//   - segments of up to 1GiB with a 10MiB index, rolled after 7 days
+//   - one index entry per 4KiB of batches
+//   - every append synced before it returns
+//   - records kept forever, retention limits are checked every 5 minutes
+//     once a caller sets them
+//   - compaction off, when enabled it runs every minute and keeps
+//     tombstones for 24 hours
+//   - 4 offloaded segments cached locally once tiering is set up
+func DefaultConfig() Config {
+	var cfg Config
+	cfg.Segment.MaxStoreSizeBytes = defaultStoreSize
+	cfg.Segment.MaxIndexSizeBytes = defaultIndexSize
+	cfg.Segment.MaxAge = defaultSegmentMaxAge
+	cfg.Segment.IndexIntervalBytes = defaultIndexInterval
+	cfg.Retention.Interval = defaultRetentionInterval
+	cfg.Compaction.Interval = defaultCompactionInterval
+	cfg.Compaction.TombstoneRetention = defaultTombstoneRetention
+	cfg.Tiering.CacheSegments = defaultCacheSegments
+	return cfg
+}
+
+// LoadConfig returns DefaultConfig with the settings of the JSON or YAML
+// file at path and then the SEGMENTEDLOG_* environment variables applied
+// on top. an empty path only applies the environment. the result is
+// validated.
+func LoadConfig(path string) (Config, error) {
+	cfg := DefaultConfig()
+
+	if path != "" {
+		err := cfg.loadFile(path)
+		if err != nil {
+			return Config{}, fmt.Errorf("loading %s: %w", path, err)
+		}
+	}
+
+	err := cfg.loadEnv(os.LookupEnv)
+	if err != nil {
+		return Config{}, err
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		return Config{}, err
+	}
+
+	return cfg, nil
+}
+
+// loadFile applies the settings of a config file, the format is picked by
+// the extension. keys that are not part of Config are an error.
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var settings map[string]any
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		d := json.NewDecoder(bytes.NewReader(data))
+		d.UseNumber()
+		err = d.Decode(&settings)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &settings)
+	default:
+		return fmt.Errorf("unknown config format %q", ext)
+	}
+	if err != nil {
+		return err
+	}
+
+	return applySettings(reflect.ValueOf(c).Elem(), settings, "")
+}
+
+// applySettings sets the fields of the struct v from settings keyed by
+// their json names
+func applySettings(v reflect.Value, settings map[string]any, prefix string) error {
+	fields := map[string]reflect.Value{}
+	for i := 0; i < v.NumField(); i++ {
+		if name := fieldName(v.Type().Field(i)); name != "" {
+			fields[name] = v.Field(i)
+		}
+	}
+
+	var errs []error
+	for key, raw := range settings {
+		f, ok := fields[key]
+		if !ok {
+			errs = append(errs, fmt.Errorf("unknown setting %s%s", prefix, key))
+			continue
+		}
+
+		if f.Kind() == reflect.Struct {
+			nested, ok := raw.(map[string]any)
+			if !ok {
+				errs = append(errs, fmt.Errorf("%s%s must be a section", prefix, key))
+				continue
+			}
+
+			errs = append(errs, applySettings(f, nested, prefix+key+"."))
+			continue
+		}
+
+		err := setValue(f, raw)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s%s: %w", prefix, key, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// loadEnv applies every environment variable named after a setting, e.g.
+// SEGMENTEDLOG_RETENTION_MAX_BYTES for retention.max_bytes
+func (c *Config) loadEnv(lookup func(string) (string, bool)) error {
+	var errs []error
+	walkSettings(reflect.ValueOf(c).Elem(), "", func(f reflect.Value, key string) {
+		name := envPrefix + "_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+		raw, ok := lookup(name)
+		if !ok {
+			return
+		}
+
+		err := setValue(f, raw)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	})
+
+	return errors.Join(errs...)
+}
+
+// walkSettings calls fn for every setting below v with its dotted key
+func walkSettings(v reflect.Value, prefix string, fn func(f reflect.Value, key string)) {
+	for i := 0; i < v.NumField(); i++ {
+		name := fieldName(v.Type().Field(i))
+		if name == "" {
+			continue
+		}
+
+		if f := v.Field(i); f.Kind() == reflect.Struct {
+			walkSettings(f, prefix+name+".", fn)
+		} else {
+			fn(f, prefix+name)
+		}
+	}
+}
+
+// fieldName returns the json name of a setting, fields that cannot be
+// configured outside of code have none
+func fieldName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "-" {
+		return ""
+	}
+
+	return name
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// setValue stores raw, a value decoded from a file or an environment
+// variable, in the setting f
+func setValue(f reflect.Value, raw any) error {
+	switch {
+	case f.Type() == durationType:
+		s, ok := raw.(string)
+		if !ok {
+			return fmt.Errorf("duration %v needs a unit, e.g. \"30s\"", raw)
+		}
+
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		f.SetInt(int64(d))
+	case f.Kind() == reflect.Bool:
+		switch b := raw.(type) {
+		case bool:
+			f.SetBool(b)
+		case string:
+			v, err := strconv.ParseBool(b)
+			if err != nil {
+				return err
+			}
+			f.SetBool(v)
+		default:
+			return fmt.Errorf("%v is not a boolean", raw)
+		}
+	case f.Kind() == reflect.Uint64:
+		n, err := parseSize(raw)
+		if err != nil {
+			return err
+		}
+		f.SetUint(n)
+	case f.Kind() == reflect.Int:
+		n, err := parseInt(raw)
+		if err != nil {
+			return err
+		}
+		f.SetInt(n)
+	case f.Kind() == reflect.String:
+		s, ok := raw.(string)
+		if !ok {
+			return fmt.Errorf("%v is not a string", raw)
+		}
+		f.SetString(s)
+	default:
+		return fmt.Errorf("cannot set %s", f.Type())
+	}
+
+	return nil
+}
+
+// sizeUnits are the suffixes parseSize accepts, longest first so "MiB" is
+// not taken for "B"
+var sizeUnits = []struct {
+	suffix string
+	bytes  uint64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+	{"B", 1},
+}
+
+// parseSize reads a byte count, either a plain number or a string with an
+// optional unit like "64MiB"
+func parseSize(raw any) (uint64, error) {
+	s, ok := raw.(string)
+	if !ok {
+		n, err := parseInt(raw)
+		if err != nil {
+			return 0, err
+		}
+		if n < 0 {
+			return 0, fmt.Errorf("size %d is negative", n)
+		}
+		return uint64(n), nil
+	}
+
+	s = strings.TrimSpace(s)
+	unit := uint64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			s, unit = strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), u.bytes
+			break
+		}
+	}
+
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", raw)
 	}
+	if n > 0 && unit > ^uint64(0)/n {
+		return 0, fmt.Errorf("size %q overflows", raw)
+	}
+
+	return n * unit, nil
 }
 
-var defaultConfig = Config{Segment: struct {
-	MaxStoreSizeBytes uint64
-	MaxIndexSizeBytes uint64
-}{MaxStoreSizeBytes: defaultStoreSize, MaxIndexSizeBytes: defaultIndexSize}}
+// parseInt reads a whole number as decoded by encoding/json, yaml or
+// taken from the environment
+func parseInt(raw any) (int64, error) {
+	switch n := raw.(type) {
+	case int:
+		return int64(n), nil
+	case int64:
+		return n, nil
+	case uint64:
+		if n > 1<<63-1 {
+			return 0, fmt.Errorf("%d is too large", n)
+		}
+		return int64(n), nil
+	case json.Number:
+		return n.Int64()
+	case string:
+		return strconv.ParseInt(strings.TrimSpace(n), 10, 64)
+	default:
+		return 0, fmt.Errorf("%v is not a whole number", raw)
+	}
+}
+
+// Validate reports every setting that cannot work, Open refuses a config
+// that does not validate. flush, tiering and retention only run on demand
+// with a 0 interval, compaction has no such mode and needs one.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Segment.MaxStoreSizeBytes > 0, "segment.max_store_bytes must be more than 0")
+	check(c.Segment.MaxIndexSizeBytes > 0, "segment.max_index_bytes must be more than 0")
+	check(c.Segment.MaxIndexSizeBytes%16 == 0,
+		"segment.max_index_bytes must be a multiple of 16, got %d", c.Segment.MaxIndexSizeBytes)
+	check(c.Segment.IndexIntervalBytes < c.Segment.MaxStoreSizeBytes || c.Segment.MaxStoreSizeBytes == 0,
+		"segment.index_interval_bytes must be less than segment.max_store_bytes, got %d", c.Segment.IndexIntervalBytes)
+	check(!c.Compaction.Enabled || c.Compaction.Interval > 0,
+		"compaction.interval must be more than 0 when compaction.enabled is set")
+	check(c.Tiering.KeepLocalSegments >= 0, "tiering.keep_local_segments must not be negative")
+	check(c.Tiering.CacheSegments >= 0, "tiering.cache_segments must not be negative")
+	check(!strings.ContainsAny(c.Tiering.Prefix, `/\`),
+		"tiering.prefix must not contain a path separator, object names are flat")
+	check(!c.Merkle.Enabled || !c.Compaction.Enabled,
+		"merkle.enabled cannot be combined with compaction.enabled, compaction rewrites the records the tree covers")
+	check(c.Merkle.SigningKey == nil || len(c.Merkle.SigningKey) == ed25519.PrivateKeySize,
+		"merkle signing key must be an ed25519 private key")
+
+	durations := []struct {
+		key string
+		d   time.Duration
+	}{
+		{"segment.max_age", c.Segment.MaxAge},
+		{"flush.interval", c.Flush.Interval},
+		{"tiering.interval", c.Tiering.Interval},
+		{"retention.max_age", c.Retention.MaxAge},
+		{"retention.interval", c.Retention.Interval},
+		{"compaction.interval", c.Compaction.Interval},
+		{"compaction.tombstone_retention", c.Compaction.TombstoneRetention},
+	}
+	for _, d := range durations {
+		check(d.d >= 0, "%s must not be negative, got %s", d.key, d.d)
+	}
+
+	return errors.Join(errs...)
+}
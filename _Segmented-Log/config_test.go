@@ -0,0 +1,276 @@
+package segmentedlog
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testConfig is small enough for a handful of records to fill a segment
+var testConfig = newTestConfig()
+
+func newTestConfig() Config {
+	var cfg Config
+	cfg.Segment.MaxStoreSizeBytes = 1 << 10
+	cfg.Segment.MaxIndexSizeBytes = 1 << 10
+	cfg.Compaction.Interval = defaultCompactionInterval
+	cfg.Compaction.TombstoneRetention = defaultTombstoneRetention
+	cfg.Tiering.CacheSegments = defaultCacheSegments
+	return cfg
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log.yaml")
+	err := os.WriteFile(path, []byte(`
+initial_id: 100
+segment:
+  max_store_bytes: 64MiB
+  max_index_bytes: 65536
+  max_age: 1h
+flush:
+  max_buffered_bytes: 1MiB
+  interval: 50ms
+compaction:
+  enabled: true
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("SEGMENTEDLOG_RETENTION_MAX_BYTES", "10GiB")
+	t.Setenv("SEGMENTEDLOG_SEGMENT_MAX_AGE", "2h")
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.InitialID != 100 {
+		t.Errorf("expected initial id 100, got %d", cfg.InitialID)
+	}
+	if cfg.Segment.MaxStoreSizeBytes != 64<<20 || cfg.Segment.MaxIndexSizeBytes != 65536 {
+		t.Errorf("unexpected segment sizes %d, %d", cfg.Segment.MaxStoreSizeBytes, cfg.Segment.MaxIndexSizeBytes)
+	}
+	if cfg.Segment.MaxAge != 2*time.Hour {
+		t.Errorf("expected the environment to win, got max age %s", cfg.Segment.MaxAge)
+	}
+	if cfg.Flush.MaxBufferedBytes != 1<<20 || cfg.Flush.Interval != 50*time.Millisecond {
+		t.Errorf("unexpected flush policy %+v", cfg.Flush)
+	}
+	if cfg.Retention.MaxBytes != 10<<30 {
+		t.Errorf("expected retention of 10GiB, got %d", cfg.Retention.MaxBytes)
+	}
+	if !cfg.Compaction.Enabled {
+		t.Error("expected compaction to be enabled")
+	}
+
+	// settings the file leaves out keep their defaults
+	def := DefaultConfig()
+	if cfg.Segment.IndexIntervalBytes != def.Segment.IndexIntervalBytes || cfg.Retention.MaxAge != def.Retention.MaxAge {
+		t.Errorf("expected defaults for the other settings, got %+v", cfg)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "log.json")
+	err := os.WriteFile(path, []byte(`{"segment": {"max_store_byte": 1024, "max_age": 60}}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = LoadConfig(path)
+	if err == nil || !strings.Contains(err.Error(), "unknown setting segment.max_store_byte") ||
+		!strings.Contains(err.Error(), "segment.max_age: duration 60 needs a unit") {
+		t.Errorf("expected the unknown key and the bare duration to be reported, got %v", err)
+	}
+
+	path = filepath.Join(dir, "log.json")
+	err = os.WriteFile(path, []byte(`{"segment": {"max_index_bytes": "1000B"}}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("SEGMENTEDLOG_RETENTION_INTERVAL", "-1m")
+	_, err = LoadConfig(path)
+	if err == nil || !strings.Contains(err.Error(), "segment.max_index_bytes must be a multiple of 16, got 1000") ||
+		!strings.Contains(err.Error(), "retention.interval must not be negative") {
+		t.Errorf("expected both validation errors, got %v", err)
+	}
+}
+
+func TestOpenValidates(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.Segment.MaxIndexSizeBytes = 100
+
+	_, err := Open(t.TempDir(), &cfg)
+	if err == nil {
+		t.Fatal("expected Open to refuse an index size that is not a multiple of 16")
+	}
+
+	cfg = newTestConfig()
+	cfg.Compaction.Enabled = true
+	cfg.Compaction.Interval = 0
+	_, err = Open(t.TempDir(), &cfg)
+	if err == nil || !strings.Contains(err.Error(), "compaction.interval must be more than 0") {
+		t.Errorf("expected Open to refuse compaction without an interval, got %v", err)
+	}
+}
+
+func TestDefaultConfigKeepsRecords(t *testing.T) {
+	cfg := DefaultConfig()
+	if cfg.Retention.MaxAge != 0 || cfg.Retention.MaxBytes != 0 {
+		t.Errorf("expected no retention limit by default, got %+v", cfg.Retention)
+	}
+}
+
+func TestInitialID(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.InitialID = 1000
+
+	l, err := Open(t.TempDir(), &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	id, err := l.Append([]byte("first"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 1000 {
+		t.Errorf("expected the first record at 1000, got %d", id)
+	}
+
+	_, err = l.Read(999)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("expected nothing before the initial id, got %v", err)
+	}
+}
+
+func TestSegmentMaxAge(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.Segment.MaxStoreSizeBytes = 1 << 20
+	cfg.Segment.MaxAge = 10 * time.Millisecond
+
+	l, err := Open(t.TempDir(), &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	_, err = l.Append([]byte("old"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(2 * cfg.Segment.MaxAge)
+	id, err := l.Append([]byte("new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	infos := l.Segments()
+	if len(infos) != 2 || infos[0].State != SegmentSealed || infos[1].BaseID != id {
+		t.Errorf("expected the old segment to be rolled, got %+v", infos)
+	}
+}
+
+func TestSparseIndex(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.Segment.MaxStoreSizeBytes = 16 << 10
+	cfg.Segment.IndexIntervalBytes = 512
+
+	dir := t.TempDir()
+	l, err := Open(dir, &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Unix(1_700_000_000, 0)
+	var id uint64 = 1
+	for id <= 1000 {
+		batch := make([]Record, 1+id%3)
+		for i := range batch {
+			batch[i].Value = []byte(fmt.Sprintf("record-%d", id+uint64(i)))
+			batch[i].Timestamp = start.Add(time.Duration(id+uint64(i)) * time.Second)
+		}
+
+		_, err = l.AppendBatch(batch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id += uint64(len(batch))
+	}
+
+	maxEntries := int(cfg.Segment.MaxStoreSizeBytes/cfg.Segment.IndexIntervalBytes) + 1
+	if n := l.active().index.entries(); n == 0 || n > maxEntries {
+		t.Errorf("expected a few index entries per segment, got %d", n)
+	}
+
+	check := func() {
+		for id := uint64(1); id <= 1000; id += 7 {
+			r, err := l.Read(id)
+			if err != nil {
+				t.Fatalf("reading %d: %v", id, err)
+			}
+			if want := fmt.Sprintf("record-%d", id); string(r.Value) != want {
+				t.Fatalf("expected %s, got %s", want, r.Value)
+			}
+
+			found, err := l.ReadFromTime(start.Add(time.Duration(id)*time.Second - time.Millisecond))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if found != id {
+				t.Fatalf("expected %d from its timestamp, got %d", id, found)
+			}
+		}
+
+		it := l.Iterator(500)
+		next := uint64(500)
+		for it.Next() {
+			if it.Record().ID != next {
+				t.Fatalf("expected %d, got %d", next, it.Record().ID)
+			}
+			next++
+		}
+		if it.Err() != nil || next <= 1000 {
+			t.Fatalf("iterator stopped at %d: %v", next, it.Err())
+		}
+	}
+
+	check()
+
+	err = l.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err = Open(dir, &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	check()
+}
+
+func TestConcurrentReadersSparse(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.Segment.MaxStoreSizeBytes = 8 << 10
+	cfg.Segment.IndexIntervalBytes = 256
+
+	l, err := Open(t.TempDir(), &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	stressLog(t, l, 2000, nil, nil)
+}
@@ -1,61 +1,156 @@
 package segmentedlog
 
-
 import (
 	"encoding/binary"
 	"errors"
 	"os"
+	"sort"
+	"sync/atomic"
 
 	"github.com/edsrzf/mmap-go"
 )
 
 // index will store mapping between recordID and recordOffset
 // it will maintain it in memory and in index file
+//
+// one goroutine writes, any number read. an entry is written to the
+// mapping before size and id are moved past it, readers load them first
+// and never look beyond. the mapping stays in place until close.
 type Index struct {
 	mm      mmap.MMap
 	idxFile *os.File
 	maxsize uint64
-	size    uint64
-	id      uint64
+	size    atomic.Uint64
+	id      atomic.Uint64
 	startID uint64
+	// lazy defers syncing the mapping to flush, it is set when the store
+	// buffers appends as well
+	lazy bool
 }
 
 var ErrMaxIndexSize = errors.New("max index size should be multiple by 16 and more than 0")
-var ErrRecordNotFound   = errors.New("record is not found")
+var ErrRecordNotFound = errors.New("record is not found")
 
 func (i *Index) Write(offset uint64) (uint64, error) {
-	ii := (i.id - i.startID) * 16
+	id := i.id.Load()
+	return id, i.writeID(id, offset)
+}
+
+// writeID appends an entry for a record with an explicit id. ids must keep
+// increasing but may leave gaps, which is how compacted segments are stored
+func (i *Index) writeID(id uint64, offset uint64) error {
+	ii := i.size.Load()
 
 	if ii >= i.maxsize {
-		return 0, ErrMaxIndexSize
+		return ErrMaxIndexSize
+	}
+
+	binary.BigEndian.PutUint64(i.mm[ii:ii+8], id)
+	binary.BigEndian.PutUint64(i.mm[ii+8:ii+16], offset)
+
+	i.size.Store(ii + 16)
+	i.id.Store(id + 1)
+
+	if i.lazy {
+		return nil
 	}
 
-	binary.BigEndian.PutUint64(i.mm[ii:ii+8], i.id)
-	binary.BigEndian.PutUint64(i.mm[ii:ii+16], offset)
+	return i.flush()
+}
+
+// flush syncs the index to disk
+func (i *Index) flush() error {
+	if len(i.mm) == 0 {
+		return nil
+	}
 
-	i.size += 16
-	i.id++
+	return i.mm.Flush()
+}
 
-	return i.id - 1, i.mm.Flush()
+// hasRoom reports whether n more entries fit in the index
+func (i *Index) hasRoom(n int) bool {
+	return i.size.Load()+uint64(n)*16 <= i.maxsize
 }
 
 func (i *Index) read(id uint64) (uint64, error) {
-	ii := (id - i.startID) * 16
-	if id == 0 || ii >= i.size {
+	if id < i.startID || id >= i.id.Load() {
 		return 0, ErrRecordNotFound
 	}
 
-	sID := binary.BigEndian.Uint64(i.mm[ii : ii+8])
-	sOffset := binary.BigEndian.Uint64(i.mm[ii+8 : ii+16])
+	// ids are dense unless the segment was compacted, so the entry is
+	// usually found at its natural slot
+	ii := (id - i.startID) * 16
+	if ii < i.size.Load() && binary.BigEndian.Uint64(i.mm[ii:ii+8]) == id {
+		return binary.BigEndian.Uint64(i.mm[ii+8 : ii+16]), nil
+	}
 
+	e := i.search(id)
+	if e == i.entries() {
+		return 0, ErrRecordNotFound
+	}
+
+	sID, sOffset := i.entry(e)
 	if sID != id {
-		panic("write or read is not working correctly")
+		return 0, ErrRecordNotFound
 	}
 
 	return sOffset, nil
 }
 
+// entries returns the number of entries written to the index
+func (i *Index) entries() int {
+	return int(i.size.Load() / 16)
+}
+
+// entry returns the recordID and offset stored in entry e
+func (i *Index) entry(e int) (uint64, uint64) {
+	ii := e * 16
+	return binary.BigEndian.Uint64(i.mm[ii : ii+8]), binary.BigEndian.Uint64(i.mm[ii+8 : ii+16])
+}
+
+// search returns the first entry with a recordID at or after id, or
+// entries() if there is none
+func (i *Index) search(id uint64) int {
+	return sort.Search(i.entries(), func(e int) bool {
+		sID, _ := i.entry(e)
+		return sID >= id
+	})
+}
+
+// reset drops every entry of the index
+func (i *Index) reset() error {
+	for b := range i.mm {
+		i.mm[b] = 0
+	}
+
+	i.size.Store(0)
+	i.id.Store(i.startID)
+
+	return i.flush()
+}
+
+// seal shrinks the index file to the entries written so far, a sealed
+// index takes no more writes
+func (i *Index) seal() error {
+	size := i.size.Load()
+	err := shrinkIndexFile(i.idxFile, i.mm, size)
+	if err != nil {
+		return err
+	}
+
+	i.maxsize = size
+	return nil
+}
+
 func (i *Index) close() error {
+	if i.mm != nil {
+		err := i.mm.Unmap()
+		if err != nil {
+			return err
+		}
+		i.mm = nil
+	}
+
 	return i.idxFile.Close()
 }
 
@@ -64,6 +159,12 @@ func (i *Index) remove() error {
 }
 
 func newIndex(file string, cfg *Config, startID uint64) (*Index, error) {
+	return openIndex(file, cfg, startID, false)
+}
+
+// openIndex opens the index of a segment. the index of the active segment
+// is grown to MaxIndexSizeBytes, a sealed one is mapped as it is.
+func openIndex(file string, cfg *Config, startID uint64, sealed bool) (*Index, error) {
 	if startID == 0 {
 		panic("recordID should not be zero")
 	}
@@ -82,12 +183,7 @@ func newIndex(file string, cfg *Config, startID uint64) (*Index, error) {
 		return nil, err
 	}
 
-	err = os.Truncate(f.Name(), int64(cfg.Segment.MaxIndexSizeBytes))
-	if err != nil {
-		return nil, err
-	}
-
-	mm, err := mmap.Map(f, mmap.RDWR, 0)
+	mm, err := mapIndexFile(f, cfg.Segment.MaxIndexSizeBytes, sealed)
 	if err != nil {
 		return nil, err
 	}
@@ -103,17 +199,59 @@ func newIndex(file string, cfg *Config, startID uint64) (*Index, error) {
 		}
 
 		size += 16
-		id++
+		id = b1 + 1
 	}
 
 	idx := &Index{
 		mm:      mm,
 		idxFile: f,
-		maxsize: cfg.Segment.MaxIndexSizeBytes,
-		size:    size,
-		id:      id,
+		maxsize: uint64(len(mm)),
 		startID: startID,
+		lazy:    cfg.Flush.MaxBufferedBytes > 0,
 	}
+	idx.size.Store(size)
+	idx.id.Store(id)
 
 	return idx, nil
 }
+
+// mapIndexFile maps an index file after growing it to maxSize, sealed
+// files keep their size. an empty file is not mapped at all.
+func mapIndexFile(f *os.File, maxSize uint64, sealed bool) (mmap.MMap, error) {
+	if !sealed {
+		err := f.Truncate(int64(maxSize))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if st.Size() == 0 {
+		return nil, nil
+	}
+
+	return mmap.Map(f, mmap.RDWR, 0)
+}
+
+// shrinkIndexFile cuts a mapped index file down to size. the mapping is
+// kept as it is so readers holding it are not disturbed, only the part
+// past size is left without a file behind it and that part is never read.
+func shrinkIndexFile(f *os.File, mm mmap.MMap, size uint64) error {
+	if mm != nil {
+		err := mm.Flush()
+		if err != nil {
+			return err
+		}
+	}
+
+	err := f.Truncate(int64(size))
+	if err != nil {
+		return err
+	}
+
+	return f.Sync()
+}
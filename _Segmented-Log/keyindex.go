@@ -0,0 +1,409 @@
+package segmentedlog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"os"
+	"sort"
+	"strings"
+)
+
+// keyIndexSuffix is the file a sealed segment keeps its key index in
+const keyIndexSuffix = ".keys"
+
+const (
+	// bloomBitsPerKey and bloomHashes give a false positive rate of about
+	// one percent
+	bloomBitsPerKey = 10
+	bloomHashes     = 7
+	// keyIndexInterval is the number of entries between the ones the
+	// sparse index keeps in memory
+	keyIndexInterval = 64
+	// keyIndexHeaderSize is the size of the fixed part of a key index file
+	//
+	//	entries   8 bytes
+	//	bloom     8 bytes, size of the filter in bits
+	//	hashes    4 bytes
+	keyIndexHeaderSize = 20
+)
+
+var ErrCorruptKeyIndex = errors.New("key index is corrupted")
+
+// keyIndexFile returns the key index file that belongs to indexFile
+func keyIndexFile(indexFile string) string {
+	return strings.TrimSuffix(indexFile, indexSuffix) + keyIndexSuffix
+}
+
+// keyHash is the 64 bit FNV-1a of key, the key index and the Bloom filter
+// only know keys by it
+func keyHash(key []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(key)
+	return h.Sum64()
+}
+
+// keyIndex finds the latest record of a key in a sealed segment. the file
+// holds a Bloom filter over the keys of the segment followed by one 16
+// byte hash|recordID entry per key hash sorted by hash and a crc32c of
+// everything before it. the filter and every keyIndexInterval-th hash stay
+// in memory, a lookup reads a single block of entries.
+type keyIndex struct {
+	file    *os.File
+	bloom   []byte
+	bits    uint64
+	hashes  uint32
+	entries uint64
+	sparse  []uint64
+}
+
+// writeKeyIndex writes the key index for latest, the recordID of the last
+// record of every key hash, atomically to path
+func writeKeyIndex(path string, latest map[uint64]uint64) error {
+	hashes := make([]uint64, 0, len(latest))
+	for h := range latest {
+		hashes = append(hashes, h)
+	}
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i] < hashes[j] })
+
+	bits := (uint64(len(hashes))*bloomBitsPerKey + 63) / 64 * 64
+	if bits == 0 {
+		bits = 64
+	}
+	bloom := make([]byte, bits/8)
+	for _, h := range hashes {
+		for _, pos := range bloomPositions(h, bits, bloomHashes) {
+			bloom[pos/8] |= 1 << (pos % 8)
+		}
+	}
+
+	buf := make([]byte, keyIndexHeaderSize, keyIndexHeaderSize+len(bloom)+16*len(hashes)+4)
+	binary.BigEndian.PutUint64(buf[0:8], uint64(len(hashes)))
+	binary.BigEndian.PutUint64(buf[8:16], bits)
+	binary.BigEndian.PutUint32(buf[16:20], bloomHashes)
+	buf = append(buf, bloom...)
+	for _, h := range hashes {
+		buf = binary.BigEndian.AppendUint64(buf, h)
+		buf = binary.BigEndian.AppendUint64(buf, latest[h])
+	}
+	buf = binary.BigEndian.AppendUint32(buf, crc32.Checksum(buf, crcTable))
+
+	return writeFileAtomic(path, buf)
+}
+
+// openKeyIndex opens and verifies the key index at path
+func openKeyIndex(path string) (*keyIndex, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < keyIndexHeaderSize+4 {
+		return nil, ErrCorruptKeyIndex
+	}
+	body := data[:len(data)-4]
+	if binary.BigEndian.Uint32(data[len(body):]) != crc32.Checksum(body, crcTable) {
+		return nil, ErrCorruptKeyIndex
+	}
+
+	k := &keyIndex{
+		entries: binary.BigEndian.Uint64(body[0:8]),
+		bits:    binary.BigEndian.Uint64(body[8:16]),
+		hashes:  binary.BigEndian.Uint32(body[16:20]),
+	}
+	if k.bits == 0 || k.bits%8 != 0 || uint64(len(body)) != keyIndexHeaderSize+k.bits/8+16*k.entries {
+		return nil, ErrCorruptKeyIndex
+	}
+	k.bloom = append([]byte(nil), body[keyIndexHeaderSize:keyIndexHeaderSize+k.bits/8]...)
+
+	entries := body[keyIndexHeaderSize+k.bits/8:]
+	for i := uint64(0); i < k.entries; i += keyIndexInterval {
+		k.sparse = append(k.sparse, binary.BigEndian.Uint64(entries[i*16:]))
+	}
+
+	k.file, err = os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// bloomPositions returns the n filter bits of hash h, derived from its two
+// halves by double hashing
+func bloomPositions(h uint64, bits uint64, n uint32) []uint64 {
+	h1, h2 := h&0xffffffff, h>>32|1
+	positions := make([]uint64, n)
+	for i := range positions {
+		positions[i] = (h1 + uint64(i)*h2) % bits
+	}
+
+	return positions
+}
+
+// mayContain reports whether the segment may hold a record with hash h, it
+// is never false for a key the segment holds
+func (k *keyIndex) mayContain(h uint64) bool {
+	for _, pos := range bloomPositions(h, k.bits, k.hashes) {
+		if k.bloom[pos/8]&(1<<(pos%8)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// lookup returns the recordID of the latest record with hash h
+func (k *keyIndex) lookup(h uint64) (uint64, bool, error) {
+	if !k.mayContain(h) {
+		return 0, false, nil
+	}
+
+	block := sort.Search(len(k.sparse), func(i int) bool { return k.sparse[i] > h }) - 1
+	if block < 0 {
+		return 0, false, nil
+	}
+
+	first := uint64(block) * keyIndexInterval
+	n := min(uint64(keyIndexInterval), k.entries-first)
+	buf := make([]byte, 16*n)
+	_, err := k.file.ReadAt(buf, int64(keyIndexHeaderSize+k.bits/8+16*first))
+	if err != nil {
+		return 0, false, err
+	}
+
+	for i := uint64(0); i < n; i++ {
+		if binary.BigEndian.Uint64(buf[i*16:]) == h {
+			return binary.BigEndian.Uint64(buf[i*16+8:]), true, nil
+		}
+	}
+
+	return 0, false, nil
+}
+
+func (k *keyIndex) close() error {
+	return k.file.Close()
+}
+
+// addKeys remembers the records of b as the latest of their keys, the
+// active segment keeps them in memory until it is sealed
+func (s *segmentedlog) addKeys(b *batch) {
+	s.keysMu.Lock()
+	defer s.keysMu.Unlock()
+
+	if s.latest == nil {
+		s.latest = map[uint64]uint64{}
+	}
+	for _, r := range b.records {
+		if r.Key != nil {
+			s.latest[keyHash(r.Key)] = r.ID
+		}
+	}
+}
+
+// scanKeys fills the in memory key index of an active segment from its
+// store
+func (s *segmentedlog) scanKeys() error {
+	return s.scan(func(b *batch) error {
+		s.addKeys(b)
+		return nil
+	})
+}
+
+// sealKeys writes the key index of a segment that is being sealed and
+// switches lookups over to it
+func (s *segmentedlog) sealKeys() error {
+	s.keysMu.RLock()
+	err := writeKeyIndex(s.keysFile, s.latest)
+	s.keysMu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	return s.openKeys()
+}
+
+// loadKeys opens the key index of a sealed segment, it is built again
+// from the store when it is missing or damaged
+func (s *segmentedlog) loadKeys() error {
+	err := s.openKeys()
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, os.ErrNotExist) && !errors.Is(err, ErrCorruptKeyIndex) {
+		return err
+	}
+
+	err = s.scanKeys()
+	if err != nil {
+		return err
+	}
+
+	return s.sealKeys()
+}
+
+func (s *segmentedlog) openKeys() error {
+	k, err := openKeyIndex(s.keysFile)
+	if err != nil {
+		return err
+	}
+
+	s.keysMu.Lock()
+	old := s.keys
+	s.keys, s.latest = k, nil
+	s.keysMu.Unlock()
+
+	if old != nil {
+		return old.close()
+	}
+	return nil
+}
+
+// latestByKey returns the latest record with key that readers can see
+func (s *segmentedlog) latestByKey(key []byte) (Record, bool, error) {
+	h := keyHash(key)
+
+	s.keysMu.RLock()
+	var id uint64
+	var ok bool
+	var err error
+	if s.keys != nil {
+		id, ok, err = s.keys.lookup(h)
+	} else {
+		id, ok = s.latest[h]
+	}
+	s.keysMu.RUnlock()
+	if err != nil || !ok {
+		return Record{}, false, err
+	}
+
+	if id <= s.lastID.Load() {
+		r, err := s.read(id)
+		if err != nil {
+			return Record{}, false, err
+		}
+		if bytes.Equal(r.Key, key) {
+			return r, true, nil
+		}
+	}
+
+	// another key with the same hash came later, or the latest record is
+	// not visible yet
+	return s.scanLatest(key)
+}
+
+// scanLatest finds the latest visible record with key by reading the
+// whole segment
+func (s *segmentedlog) scanLatest(key []byte) (Record, bool, error) {
+	last := s.lastID.Load()
+
+	var latest Record
+	var found bool
+	err := s.scan(func(b *batch) error {
+		for _, r := range b.records {
+			if r.ID <= last && r.Key != nil && bytes.Equal(r.Key, key) {
+				latest, found = r, true
+			}
+		}
+		return nil
+	})
+
+	return latest, found, err
+}
+
+// LatestByKey returns the newest record stored under key. segments are
+// searched newest first and only read when their Bloom filter may hold
+// the key. a key whose latest record is a tombstone is not found.
+func (l *Log) LatestByKey(key []byte) (Record, error) {
+	if key == nil {
+		return Record{}, ErrRecordNotFound
+	}
+
+	for {
+		v := l.view.Load()
+		r, ok, retry, err := l.latestLocal(v, key)
+		if retry {
+			continue
+		}
+		if err != nil {
+			return Record{}, err
+		}
+
+		for i := len(v.remote) - 1; !ok && i >= 0; i-- {
+			r, ok, err = l.latestRemote(v.remote[i], key)
+			if err != nil && l.deleted(v.remote[i]) {
+				retry = true
+				break
+			}
+			if err != nil {
+				return Record{}, err
+			}
+		}
+		if retry {
+			continue
+		}
+
+		if !ok || r.Value == nil {
+			return Record{}, ErrRecordNotFound
+		}
+		return r, nil
+	}
+}
+
+// latestLocal searches the local segments of v, retry is set when one of
+// them was retired in the meantime
+func (l *Log) latestLocal(v *logView, key []byte) (Record, bool, bool, error) {
+	for i := len(v.segments) - 1; i >= 0; i-- {
+		s := v.segments[i]
+		if !s.acquire() {
+			return Record{}, false, true, nil
+		}
+
+		r, ok, err := s.latestByKey(key)
+		s.release()
+		if err != nil || ok {
+			return r, ok, false, err
+		}
+	}
+
+	return Record{}, false, false, nil
+}
+
+// latestRemote searches an offloaded segment, only its key index is
+// fetched unless the filter matches
+func (l *Log) latestRemote(rs SegmentInfo, key []byte) (Record, bool, error) {
+	k, err := l.cache.keys(rs)
+	if err != nil {
+		return Record{}, false, err
+	}
+
+	var id uint64
+	ok := true
+	if k != nil {
+		id, ok, err = k.lookup(keyHash(key))
+		if err != nil || !ok {
+			return Record{}, false, err
+		}
+	}
+
+	s, release, err := l.cache.get(rs)
+	if err != nil {
+		return Record{}, false, err
+	}
+	defer release()
+
+	if k != nil {
+		r, err := s.read(id)
+		if err != nil {
+			return Record{}, false, fmt.Errorf("segment %d: %w", rs.BaseID, err)
+		}
+		if bytes.Equal(r.Key, key) {
+			return r, true, nil
+		}
+	}
+
+	return s.latestByKey(key)
+}
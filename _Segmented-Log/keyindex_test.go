@@ -0,0 +1,214 @@
+package segmentedlog
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// appendKeyed writes rounds of one record for each of keys keys, the value
+// names the round
+func appendKeyed(t *testing.T, l *Log, keys, rounds int) {
+	t.Helper()
+
+	for round := 0; round < rounds; round++ {
+		for k := 0; k < keys; k++ {
+			_, err := l.AppendBatch([]Record{{
+				Key:   []byte(fmt.Sprintf("key-%d", k)),
+				Value: []byte(fmt.Sprintf("round-%d", round)),
+			}})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestLatestByKey(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, &testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	appendKeyed(t, l, 10, 8)
+
+	// key-3 only shows up again in the active segment, key-4 is deleted
+	_, err = l.AppendBatch([]Record{
+		{Key: []byte("key-3"), Value: []byte("latest")},
+		{Key: []byte("key-4")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(l.segments) < 4 {
+		t.Fatalf("expected the records to span several segments, got %d", len(l.segments))
+	}
+
+	check := func(l *Log) {
+		t.Helper()
+
+		for k := 0; k < 10; k++ {
+			key := []byte(fmt.Sprintf("key-%d", k))
+			r, err := l.LatestByKey(key)
+
+			switch k {
+			case 3:
+				if err != nil || string(r.Value) != "latest" {
+					t.Errorf("expected the record in the active segment for key-3, got %q %v", r.Value, err)
+				}
+			case 4:
+				if !errors.Is(err, ErrRecordNotFound) {
+					t.Errorf("expected the deleted key-4 not to be found, got %q %v", r.Value, err)
+				}
+			default:
+				if err != nil || string(r.Value) != "round-7" || string(r.Key) != string(key) {
+					t.Errorf("expected round-7 for %s, got %q %v", key, r.Value, err)
+				}
+			}
+		}
+
+		_, err := l.LatestByKey([]byte("missing"))
+		if !errors.Is(err, ErrRecordNotFound) {
+			t.Errorf("expected a key that was never written not to be found, got %v", err)
+		}
+	}
+	check(l)
+
+	for _, s := range l.segments[:len(l.segments)-1] {
+		if s.keys == nil {
+			t.Fatalf("expected sealed segment %d to have a key index", s.baseID())
+		}
+	}
+
+	err = l.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a lost key index is built again from the store
+	err = os.Remove(l.segmentPath(1) + keyIndexSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err = Open(dir, &testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	check(l)
+
+	_, err = os.Stat(l.segmentPath(1) + keyIndexSuffix)
+	if err != nil {
+		t.Errorf("expected the key index to be written again: %v", err)
+	}
+}
+
+func TestKeyIndex(t *testing.T) {
+	latest := map[uint64]uint64{}
+	for i := uint64(0); i < 1000; i++ {
+		latest[keyHash([]byte(fmt.Sprintf("key-%d", i)))] = i + 1
+	}
+
+	path := filepath.Join(t.TempDir(), "segment"+keyIndexSuffix)
+	err := writeKeyIndex(path, latest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k, err := openKeyIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.close()
+
+	if len(k.sparse) != (1000+keyIndexInterval-1)/keyIndexInterval {
+		t.Errorf("expected one sparse entry per %d keys, got %d", keyIndexInterval, len(k.sparse))
+	}
+
+	for h, want := range latest {
+		id, ok, err := k.lookup(h)
+		if err != nil || !ok || id != want {
+			t.Fatalf("expected %d for %x, got %d %v %v", want, h, id, ok, err)
+		}
+	}
+
+	var positives int
+	for i := 0; i < 10000; i++ {
+		if k.mayContain(keyHash([]byte(fmt.Sprintf("other-%d", i)))) {
+			positives++
+		}
+	}
+	if positives > 300 {
+		t.Errorf("expected about 1%% false positives, got %d in 10000", positives)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[keyIndexHeaderSize] ^= 0xff
+	err = os.WriteFile(path, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = openKeyIndex(path)
+	if !errors.Is(err, ErrCorruptKeyIndex) {
+		t.Errorf("expected a damaged key index to be refused, got %v", err)
+	}
+}
+
+func TestLatestByKeyOffloaded(t *testing.T) {
+	objects, err := NewDirStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := newTestConfig()
+	cfg.Tiering.Store = objects
+
+	l, err := Open(t.TempDir(), &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	appendKeyed(t, l, 10, 8)
+	_, err = l.AppendBatch([]Record{{Key: []byte("key-0"), Value: []byte("first")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = l.Offload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l.remote) == 0 {
+		t.Fatal("expected offloaded segments")
+	}
+
+	// filters that do not match keep the segments in the object store
+	_, err = l.LatestByKey([]byte("missing"))
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Fatalf("expected a key that was never written not to be found, got %v", err)
+	}
+	if n := len(l.cache.entries); n != 0 {
+		t.Errorf("expected no offloaded segment to be fetched, got %d", n)
+	}
+	if n := len(l.cache.keyIndexes); n != len(l.remote) {
+		t.Errorf("expected the key index of all %d offloaded segments, got %d", len(l.remote), n)
+	}
+
+	for k := 1; k < 10; k++ {
+		r, err := l.LatestByKey([]byte(fmt.Sprintf("key-%d", k)))
+		if err != nil || string(r.Value) != "round-7" {
+			t.Errorf("expected round-7 for key-%d, got %q %v", k, r.Value, err)
+		}
+	}
+}
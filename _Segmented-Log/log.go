@@ -0,0 +1,645 @@
+package segmentedlog
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Log is an append only log split into segments stored in one directory.
+// every segment is named after the first recordID it holds and a new one
+// is started once the active segment runs out of store or index space
+//
+// appends, flushes and maintenance serialize on mu, there is only ever one
+// writer. readers never take mu: they load the published view, take a
+// reference on the segment they need and only see records up to the
+// lastID it published, see segmentedlog. the log must not be read while
+// Close runs.
+type Log struct {
+	mu       sync.Mutex
+	dir      string
+	cfg      *Config
+	segments []*segmentedlog
+	// infos holds the manifest entries of the local segments by base ID,
+	// remote the entries of the offloaded ones
+	infos   map[uint64]SegmentInfo
+	remote  []SegmentInfo
+	view    atomic.Pointer[logView]
+	cache   *segmentCache
+	offsets *consumerOffsets
+	// tree is the Merkle tree over the records when Merkle.Enabled is set,
+	// its heads are signed with signingKey
+	tree       *merkleTree
+	signingKey ed25519.PrivateKey
+
+	// maintenanceMu serializes compaction and offloading, both replace
+	// sealed segments
+	maintenanceMu sync.Mutex
+	done          chan struct{}
+	wg            sync.WaitGroup
+}
+
+// Open opens the log stored in dir, creating the directory and the first
+// segment if needed
+func Open(dir string, cfg *Config) (*Log, error) {
+	if cfg == nil {
+		def := DefaultConfig()
+		cfg = &def
+	}
+
+	err := cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := open(dir, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Merkle.Enabled {
+		l.mu.Lock()
+		err = l.openTree()
+		l.mu.Unlock()
+		if err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+
+	l.offsets, err = openConsumerOffsets(filepath.Join(dir, offsetsDir), cfg)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	l.start()
+	return l, nil
+}
+
+// open opens the segments of the log without starting any background work
+func open(dir string, cfg *Config) (*Log, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	swapped, err := recoverCompaction(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// segments are found by their store files, the index of a segment can
+	// be rebuilt from its store but not the other way round
+	files, err := filepath.Glob(filepath.Join(dir, "*"+storeSuffix))
+	if err != nil {
+		return nil, err
+	}
+
+	var baseIDs []uint64
+	for _, file := range files {
+		id, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(file), storeSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		baseIDs = append(baseIDs, id)
+	}
+	sort.Slice(baseIDs, func(i, j int) bool { return baseIDs[i] < baseIDs[j] })
+
+	l := &Log{
+		dir:   dir,
+		cfg:   cfg,
+		infos: map[uint64]SegmentInfo{},
+	}
+
+	manifest, err := loadManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	listed := map[uint64]SegmentInfo{}
+	for _, info := range manifest {
+		if info.State == SegmentOffloaded {
+			l.remote = append(l.remote, info)
+			continue
+		}
+		listed[info.BaseID] = info
+	}
+
+	if len(l.remote) > 0 && cfg.Tiering.Store == nil {
+		return nil, errors.New("log has offloaded segments but no object store is configured")
+	}
+	if cfg.Tiering.Store != nil {
+		l.cache, err = newSegmentCache(filepath.Join(dir, cacheDir), cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for n, id := range baseIDs {
+		if _, ok := remoteFor(l.remote, id); ok {
+			// the upload finished but the local copy was not deleted yet
+			err = l.removeSegmentFiles(id)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		err = l.restoreIndex(id)
+		if err == nil {
+			err = l.loadSegment(id, listed, n == len(baseIDs)-1, swapped[id])
+		}
+		if err != nil {
+			l.closeSegments()
+			return nil, err
+		}
+	}
+
+	// segments listed in the manifest without files were deleted before
+	// the manifest could be written, they are simply left out of it
+	if len(l.segments) == 0 || l.infos[l.active().baseID()].State != SegmentActive {
+		base := cfg.InitialID
+		if base == 0 {
+			base = 1
+		}
+		if len(l.segments) > 0 {
+			base = l.active().nextID()
+		} else if len(l.remote) > 0 {
+			base = l.remote[len(l.remote)-1].nextID()
+		}
+
+		err = l.newSegment(base)
+		if err != nil {
+			l.closeSegments()
+			return nil, err
+		}
+	}
+
+	err = l.saveManifest()
+	if err != nil {
+		l.closeSegments()
+		return nil, err
+	}
+
+	l.publish()
+	return l, nil
+}
+
+// logView is what readers see of the log, it is never changed once
+// published and replaced as a whole when segments come and go
+type logView struct {
+	segments []*segmentedlog
+	remote   []SegmentInfo
+}
+
+func (v *logView) active() *segmentedlog {
+	return v.segments[len(v.segments)-1]
+}
+
+// publish hands the current segments to readers. the caller must hold
+// l.mu.
+func (l *Log) publish() {
+	l.view.Store(&logView{
+		segments: append([]*segmentedlog(nil), l.segments...),
+		remote:   append([]SegmentInfo(nil), l.remote...),
+	})
+}
+
+// restoreIndex rebuilds the index of the segment starting at baseID from
+// its store when the index file was lost
+func (l *Log) restoreIndex(baseID uint64) error {
+	path := l.segmentPath(baseID)
+	_, err := os.Stat(path + indexSuffix)
+	if !os.IsNotExist(err) {
+		return err
+	}
+
+	f, err := os.OpenFile(path+indexSuffix, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	return RecoverSegment(path+indexSuffix, path+storeSuffix, baseID, l.cfg)
+}
+
+// loadSegment opens the local segment starting at baseID using its entry
+// in the manifest. only the last segment can still be active, every other
+// one is sealed if it was not yet, and segments swapped in by a compaction
+// that crashed before updating the manifest get a fresh checksum.
+func (l *Log) loadSegment(baseID uint64, listed map[uint64]SegmentInfo, last bool, swapped bool) error {
+	info, ok := listed[baseID]
+	if !ok {
+		info = SegmentInfo{
+			BaseID:    baseID,
+			CreatedAt: time.Now(),
+			State:     SegmentActive,
+		}
+	}
+	l.infos[baseID] = info
+
+	path := l.segmentPath(baseID)
+	if last && info.State == SegmentActive {
+		s, err := createSegment(path, baseID, l.cfg)
+		if err != nil {
+			return err
+		}
+
+		l.segments = append(l.segments, s)
+		return nil
+	}
+
+	s, err := openSealedSegment(path, baseID, l.cfg)
+	if err != nil {
+		return err
+	}
+	l.segments = append(l.segments, s)
+
+	if info.State != SegmentSealed {
+		return l.sealSegment(s)
+	}
+	if swapped {
+		return l.updateChecksum(s, info)
+	}
+
+	return nil
+}
+
+// start launches the background work enabled in the config
+func (l *Log) start() {
+	cfg := l.cfg
+
+	l.done = make(chan struct{})
+	if cfg.Compaction.Enabled {
+		l.runEvery(cfg.Compaction.Interval, "compaction", l.Compact)
+	}
+	if cfg.Flush.Interval > 0 {
+		l.runEvery(cfg.Flush.Interval, "flush", l.Flush)
+	}
+	if cfg.Tiering.Store != nil && cfg.Tiering.Interval > 0 {
+		l.runEvery(cfg.Tiering.Interval, "offload", l.Offload)
+	}
+	if cfg.Retention.Interval > 0 {
+		l.runEvery(cfg.Retention.Interval, "retention", l.EnforceRetention)
+	}
+}
+
+// runEvery calls fn every interval in the background until Close, a
+// non positive interval does not run it
+func (l *Log) runEvery(interval time.Duration, what string, fn func() error) {
+	if interval <= 0 {
+		return
+	}
+
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-l.done:
+				return
+			case <-ticker.C:
+				err := fn()
+				if err != nil {
+					log.Printf("segmentedlog: %s of %s failed: %v", what, l.dir, err)
+				}
+			}
+		}
+	}()
+}
+
+// segmentPath returns the path of a segment file without its suffix
+func (l *Log) segmentPath(baseID uint64) string {
+	return filepath.Join(l.dir, fmt.Sprintf("%020d", baseID))
+}
+
+// removeSegmentFiles deletes the files of the segment starting at baseID
+func (l *Log) removeSegmentFiles(baseID uint64) error {
+	path := l.segmentPath(baseID)
+	for _, suffix := range segmentSuffixes {
+		err := os.Remove(path + suffix)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// newSegment starts a new active segment at baseID, the manifest is not
+// written
+func (l *Log) newSegment(baseID uint64) error {
+	s, err := createSegment(l.segmentPath(baseID), baseID, l.cfg)
+	if err != nil {
+		return err
+	}
+
+	l.segments = append(l.segments, s)
+	l.infos[baseID] = SegmentInfo{
+		BaseID:    baseID,
+		LastID:    baseID - 1,
+		CreatedAt: time.Now(),
+		State:     SegmentActive,
+	}
+	return nil
+}
+
+// createSegment opens the segment stored at path, creating its files if
+// they do not exist yet
+func createSegment(path string, baseID uint64, cfg *Config) (*segmentedlog, error) {
+	for _, suffix := range []string{indexSuffix, storeSuffix} {
+		f, err := os.OpenFile(path+suffix, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+		err = f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return openSegment(path, baseID, cfg)
+}
+
+// openSegment opens the existing segment stored at path
+func openSegment(path string, baseID uint64, cfg *Config) (*segmentedlog, error) {
+	return NewSegement(path+indexSuffix, path+storeSuffix, baseID, cfg)
+}
+
+// openSealedSegment opens the existing sealed segment stored at path
+// without growing its indexes
+func openSealedSegment(path string, baseID uint64, cfg *Config) (*segmentedlog, error) {
+	return loadSegment(path+indexSuffix, path+storeSuffix, baseID, cfg, true)
+}
+
+func (l *Log) active() *segmentedlog {
+	return l.segments[len(l.segments)-1]
+}
+
+// Append writes a record holding data and returns its recordID
+func (l *Log) Append(data []byte) (uint64, error) {
+	return l.AppendBatch([]Record{{Value: data}})
+}
+
+// AppendBatch writes records to the active segment as a single batch and
+// returns the recordID of the first one
+func (l *Log) AppendBatch(records []Record) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	id, err := l.appendBatch(records)
+	if err != nil {
+		return 0, err
+	}
+
+	if l.tree != nil {
+		err = l.extendTree()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return id, nil
+}
+
+// appendBatch writes records to the active segment, rolling it first when
+// it is too old or full. the caller must hold l.mu.
+func (l *Log) appendBatch(records []Record) (uint64, error) {
+	if l.expired(l.active()) {
+		err := l.roll()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	id, err := l.active().appendBatch(records)
+	if !segmentFull(err) {
+		return id, err
+	}
+
+	// a new segment would start at the same recordID as an empty one and
+	// could not take the batch either
+	if l.active().nextID() == l.active().baseID() {
+		return 0, ErrRecordTooLarge
+	}
+
+	// the active segment is full, seal it and retry once on a new one
+	err = l.roll()
+	if err != nil {
+		return 0, err
+	}
+
+	id, err = l.active().appendBatch(records)
+	if segmentFull(err) {
+		return 0, ErrRecordTooLarge
+	}
+	return id, err
+}
+
+// segmentFull reports whether err means the batch did not fit the rest of
+// the active segment
+func segmentFull(err error) bool {
+	return errors.Is(err, errNoStoreSpaceLeft) || errors.Is(err, ErrMaxIndexSize)
+}
+
+// expired reports whether s holds records and was created more than
+// Segment.MaxAge ago. the caller must hold l.mu.
+func (l *Log) expired(s *segmentedlog) bool {
+	maxAge := l.cfg.Segment.MaxAge
+	if maxAge == 0 || s.nextID() == s.baseID() {
+		return false
+	}
+
+	return time.Since(l.infos[s.baseID()].CreatedAt) >= maxAge
+}
+
+// roll seals the active segment and starts a new one after it. the caller
+// must hold l.mu.
+func (l *Log) roll() error {
+	err := l.sealSegment(l.active())
+	if err != nil {
+		return err
+	}
+
+	// retention only deletes sealed segments, the tree must not need
+	// their records again after a crash
+	if l.tree != nil {
+		err = l.tree.flush()
+		if err != nil {
+			return err
+		}
+	}
+
+	err = l.newSegment(l.active().nextID())
+	if err != nil {
+		return err
+	}
+
+	err = l.saveManifest()
+	if err != nil {
+		return err
+	}
+
+	l.publish()
+	return nil
+}
+
+// Flush writes and syncs every buffered append
+func (l *Log) Flush() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	err := l.active().flush()
+	if err != nil || l.tree == nil {
+		return err
+	}
+
+	return l.tree.flush()
+}
+
+// FlushedID returns the last recordID that is synced to disk. Iterator,
+// Reader and SendTo do not go past it, Read does.
+func (l *Log) FlushedID() uint64 {
+	return l.view.Load().active().flushedID.Load()
+}
+
+// LastID returns the last recordID readers can see. it moves forward a
+// whole batch at a time once the batch is stored and indexed.
+func (l *Log) LastID() uint64 {
+	return l.view.Load().active().lastID.Load()
+}
+
+// Read returns the record stored under id
+func (l *Log) Read(id uint64) (Record, error) {
+	s, release, err := l.acquire(id)
+	if err != nil {
+		return Record{}, err
+	}
+	defer release()
+
+	return s.read(id)
+}
+
+// ReadFromTime returns the first recordID appended at or after t
+func (l *Log) ReadFromTime(t time.Time) (uint64, error) {
+	ts := timestamp(t)
+	for {
+		v := l.view.Load()
+		retired := false
+		for _, rs := range v.remote {
+			if rs.MaxTimestamp < ts {
+				continue
+			}
+
+			s, release, err := l.cache.get(rs)
+			if err != nil && l.deleted(rs) {
+				retired = true
+				break
+			}
+			if err != nil {
+				return 0, err
+			}
+			defer release()
+
+			return s.readFromTime(t)
+		}
+		if retired {
+			continue
+		}
+
+		for _, s := range v.segments {
+			if s.maxTimestamp.Load() < ts {
+				continue
+			}
+
+			if !s.acquire() {
+				retired = true
+				break
+			}
+			defer s.release()
+
+			return s.readFromTime(t)
+		}
+
+		// a retired segment was replaced, offloaded or deleted after the
+		// view was loaded, look again in the current one
+		if !retired {
+			return 0, ErrRecordNotFound
+		}
+	}
+}
+
+// Close stops the background work and closes every segment of the log
+func (l *Log) Close() error {
+	if l.done != nil {
+		close(l.done)
+		l.wg.Wait()
+		l.done = nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.cache != nil {
+		l.cache.close()
+	}
+
+	// a failing step does not keep the others from running, whatever can
+	// still be flushed and closed is
+	var errs []error
+	if l.offsets != nil {
+		errs = append(errs, l.offsets.log.Close())
+	}
+
+	// flush first so the manifest does not list records that never made
+	// it to disk
+	errs = append(errs, l.active().flush())
+
+	if l.tree != nil {
+		err := l.tree.flush()
+		if err == nil {
+			err = l.tree.close()
+		}
+		errs = append(errs, err)
+	}
+
+	errs = append(errs, l.saveManifest(), l.closeSegments())
+	return errors.Join(errs...)
+}
+
+// closeSegments closes every local segment without touching the manifest
+func (l *Log) closeSegments() error {
+	var errs []error
+	for _, s := range l.segments {
+		errs = append(errs, s.close())
+	}
+
+	return errors.Join(errs...)
+}
+
+// Remove closes the log and deletes its directory
+func (l *Log) Remove() error {
+	err := l.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.RemoveAll(l.dir)
+}
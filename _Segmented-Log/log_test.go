@@ -0,0 +1,428 @@
+package segmentedlog
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestLogAppendRead(t *testing.T) {
+	l, err := Open(t.TempDir(), &testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	var ids []uint64
+	for i := 0; i < 100; i++ {
+		id, err := l.Append([]byte(fmt.Sprintf("record-%d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	if len(l.segments) < 2 {
+		t.Errorf("expected the log to roll over, got %d segments", len(l.segments))
+	}
+
+	for i, id := range ids {
+		r, err := l.Read(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(r.Value) != fmt.Sprintf("record-%d", i) {
+			t.Errorf("expected record-%d, got %s", i, r.Value)
+		}
+		if r.ID != id {
+			t.Errorf("expected id %d, got %d", id, r.ID)
+		}
+	}
+
+	_, err = l.Read(ids[len(ids)-1] + 1)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("expected ErrRecordNotFound, got %v", err)
+	}
+}
+
+func TestLogReopen(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, &testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 50; i++ {
+		_, err := l.Append([]byte("abc"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = l.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err = Open(dir, &testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	id, err := l.Append([]byte("next"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 51 {
+		t.Errorf("expected id 51, got %d", id)
+	}
+}
+
+func TestLogReadFromTime(t *testing.T) {
+	l, err := Open(t.TempDir(), &testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	for i := 0; i < 40; i++ {
+		_, err := l.Append([]byte("before"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	since := time.Now()
+
+	first, err := l.Append([]byte("after"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 40; i++ {
+		_, err := l.Append([]byte("after"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	id, err := l.ReadFromTime(since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != first {
+		t.Errorf("expected id %d, got %d", first, id)
+	}
+
+	id, err = l.ReadFromTime(time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 1 {
+		t.Errorf("expected id 1, got %d", id)
+	}
+
+	_, err = l.ReadFromTime(time.Now().Add(time.Hour))
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("expected ErrRecordNotFound, got %v", err)
+	}
+}
+
+func TestLogReadFromTimeBackwards(t *testing.T) {
+	base := time.Unix(1700000000, 0)
+	offsets := []int{100, 300, 200, 400, 150, 500, 450}
+
+	for _, interval := range []uint64{0, 64} {
+		t.Run(fmt.Sprintf("interval %d", interval), func(t *testing.T) {
+			cfg := newTestConfig()
+			cfg.Segment.IndexIntervalBytes = interval
+			l, err := Open(t.TempDir(), &cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+
+			for _, o := range offsets {
+				_, err := l.AppendBatch([]Record{{Value: []byte("v"), Timestamp: base.Add(time.Duration(o) * time.Second)}})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for id, o := range offsets {
+				r, err := l.Read(uint64(id + 1))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !r.Timestamp.Equal(base.Add(time.Duration(o) * time.Second)) {
+					t.Errorf("expected record %d to keep its timestamp, got %v", id+1, r.Timestamp)
+				}
+			}
+
+			// no record at or after the time may come before the one
+			// returned
+			for q := 50; q <= 500; q += 25 {
+				var want uint64
+				for id, o := range offsets {
+					if o >= q {
+						want = uint64(id + 1)
+						break
+					}
+				}
+
+				got, err := l.ReadFromTime(base.Add(time.Duration(q) * time.Second))
+				if err != nil || got > want {
+					t.Errorf("from %d: expected at most id %d, got %d %v", q, want, got, err)
+				}
+			}
+		})
+	}
+
+	l, err := Open(t.TempDir(), &testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	_, err = l.AppendBatch([]Record{{Value: []byte("v"), Timestamp: time.Unix(-1, 0)}})
+	if !errors.Is(err, ErrInvalidTimestamp) {
+		t.Errorf("expected ErrInvalidTimestamp, got %v", err)
+	}
+}
+
+func TestLogAppendBatch(t *testing.T) {
+	l, err := Open(t.TempDir(), &testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	ts := time.Unix(1700000000, 0)
+	records := []Record{
+		{Key: []byte("a"), Value: []byte("1"), Headers: []Header{{Key: "h", Value: []byte("v")}}},
+		{Key: []byte("b"), Value: nil, Timestamp: ts},
+		{Value: []byte("3")},
+	}
+
+	base, err := l.AppendBatch(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, want := range records {
+		r, err := l.Read(base + uint64(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.ID != base+uint64(i) {
+			t.Errorf("expected id %d, got %d", base+uint64(i), r.ID)
+		}
+		if string(r.Key) != string(want.Key) || string(r.Value) != string(want.Value) {
+			t.Errorf("expected %s=%s, got %s=%s", want.Key, want.Value, r.Key, r.Value)
+		}
+		if len(r.Headers) != len(want.Headers) {
+			t.Errorf("expected %d headers, got %d", len(want.Headers), len(r.Headers))
+		}
+	}
+
+	r, _ := l.Read(base + 1)
+	if r.Value != nil {
+		t.Errorf("expected a tombstone, got %q", r.Value)
+	}
+	if !r.Timestamp.Equal(ts) {
+		t.Errorf("expected timestamp %v, got %v", ts, r.Timestamp)
+	}
+
+	_, err = l.AppendBatch(nil)
+	if !errors.Is(err, ErrEmptyBatch) {
+		t.Errorf("expected ErrEmptyBatch, got %v", err)
+	}
+}
+
+func TestLogRecordTooLarge(t *testing.T) {
+	l, err := Open(t.TempDir(), &testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	big := make([]byte, 2000)
+	for i := 0; i < 2; i++ {
+		_, err = l.Append(big)
+		if !errors.Is(err, ErrRecordTooLarge) {
+			t.Fatalf("expected ErrRecordTooLarge, got %v", err)
+		}
+	}
+	if len(l.segments) != 1 {
+		t.Errorf("expected no roll for an empty segment, got %d segments", len(l.segments))
+	}
+
+	id, err := l.Append([]byte("small"))
+	if err != nil || id != 1 {
+		t.Fatalf("expected id 1, got %d %v", id, err)
+	}
+
+	// a full segment rolls once, the new empty one does not roll again
+	for i := 0; i < 2; i++ {
+		_, err = l.Append(big)
+		if !errors.Is(err, ErrRecordTooLarge) {
+			t.Fatalf("expected ErrRecordTooLarge, got %v", err)
+		}
+	}
+	if len(l.segments) != 2 || l.active().baseID() != 2 {
+		t.Errorf("expected a second segment starting at 2, got %d segments", len(l.segments))
+	}
+
+	id, err = l.Append([]byte("small"))
+	if err != nil || id != 2 {
+		t.Errorf("expected id 2, got %d %v", id, err)
+	}
+}
+
+func TestDecodeBatchCorrupted(t *testing.T) {
+	data := encodeBatch(&batch{
+		baseID:  1,
+		records: []Record{{ID: 1, Timestamp: time.Unix(0, 0), Value: []byte("hello")}},
+	})
+
+	_, err := decodeBatch(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data[len(data)-1] ^= 0xff
+	_, err = decodeBatch(data)
+	if !errors.Is(err, ErrCorruptBatch) {
+		t.Errorf("expected ErrCorruptBatch, got %v", err)
+	}
+}
+
+func TestLogBufferedFlush(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.Segment.MaxStoreSizeBytes = 1 << 16
+	cfg.Flush.MaxBufferedBytes = 512
+
+	dir := t.TempDir()
+	l, err := Open(dir, &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := l.Append([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if l.FlushedID() != 0 {
+		t.Errorf("expected nothing flushed yet, got %d", l.FlushedID())
+	}
+
+	r, err := l.Read(id)
+	if err != nil || string(r.Value) != "hello" {
+		t.Errorf("expected buffered record to be readable, got %q %v", r.Value, err)
+	}
+
+	if l.Iterator(1).Next() {
+		t.Error("expected iterator to stop at the flushed id")
+	}
+
+	err = l.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.FlushedID() != id {
+		t.Errorf("expected flushed id %d, got %d", id, l.FlushedID())
+	}
+
+	for i := 0; i < 20; i++ {
+		id, err = l.Append([]byte("filling the buffer"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if l.FlushedID() == 1 {
+		t.Error("expected a full buffer to be flushed")
+	}
+
+	err = l.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err = Open(dir, &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	if l.FlushedID() != id {
+		t.Errorf("expected close to flush up to %d, got %d", id, l.FlushedID())
+	}
+}
+
+func TestLogFlushInterval(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.Flush.MaxBufferedBytes = 512
+	cfg.Flush.Interval = 10 * time.Millisecond
+
+	l, err := Open(t.TempDir(), &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	id, err := l.Append([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for l.FlushedID() != id && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if l.FlushedID() != id {
+		t.Errorf("expected periodic flush up to %d, got %d", id, l.FlushedID())
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	l, err := Open(t.TempDir(), &testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	for i := 1; i <= 100; i++ {
+		_, err := l.Append([]byte(fmt.Sprintf("record-%d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	second := l.segments[1].baseID()
+	first, err := l.Truncate(second + 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Fatalf("expected only the first segment to go, first id is %d", first)
+	}
+
+	_, err = l.Read(second - 1)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("expected truncated record to be gone, got %v", err)
+	}
+	_, err = l.Read(second)
+	if err != nil {
+		t.Errorf("expected record %d to be kept, got %v", second, err)
+	}
+
+	first, err = l.Truncate(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l.segments) != 1 || first != l.active().baseID() {
+		t.Errorf("expected everything but the active segment to go, got %d segments", len(l.segments))
+	}
+}
@@ -0,0 +1,88 @@
+package logpb
+
+import (
+	"time"
+
+	segmentedlog "github.com/EncrypteID/Replicate-Partition-Next-Generation-Systems/_Segmented-Log"
+)
+
+// FromRecord converts a log record into its wire form
+func FromRecord(r segmentedlog.Record) *Record {
+	m := &Record{
+		Id:        r.ID,
+		Key:       r.Key,
+		Value:     r.Value,
+		Tombstone: r.Value == nil,
+	}
+	if !r.Timestamp.IsZero() {
+		m.Timestamp = r.Timestamp.UnixNano()
+	}
+	for _, h := range r.Headers {
+		m.Headers = append(m.Headers, &Header{Key: h.Key, Value: h.Value})
+	}
+
+	return m
+}
+
+// ToRecord converts a wire record back into a log record. an empty key
+// comes back as nil, the wire does not tell them apart.
+func (m *Record) ToRecord() segmentedlog.Record {
+	r := segmentedlog.Record{
+		ID:  m.Id,
+		Key: m.Key,
+	}
+	if m.Timestamp != 0 {
+		r.Timestamp = time.Unix(0, m.Timestamp)
+	}
+	if !m.Tombstone {
+		r.Value = m.Value
+		if r.Value == nil {
+			r.Value = []byte{}
+		}
+	}
+	for _, h := range m.Headers {
+		r.Headers = append(r.Headers, segmentedlog.Header{Key: h.Key, Value: h.Value})
+	}
+
+	return r
+}
+
+// FromSegmentInfo converts a manifest entry into its wire form
+func FromSegmentInfo(info segmentedlog.SegmentInfo) *SegmentInfo {
+	m := &SegmentInfo{
+		BaseId:       info.BaseID,
+		LastId:       info.LastID,
+		Size_:        info.Size,
+		MaxTimestamp: info.MaxTimestamp,
+		Checksum:     info.Checksum,
+		State:        string(info.State),
+	}
+	if !info.CreatedAt.IsZero() {
+		m.CreatedAt = info.CreatedAt.UnixNano()
+	}
+	if !info.SealedAt.IsZero() {
+		m.SealedAt = info.SealedAt.UnixNano()
+	}
+
+	return m
+}
+
+// ToSegmentInfo converts a wire manifest entry back
+func (m *SegmentInfo) ToSegmentInfo() segmentedlog.SegmentInfo {
+	info := segmentedlog.SegmentInfo{
+		BaseID:       m.BaseId,
+		LastID:       m.LastId,
+		Size:         m.Size_,
+		MaxTimestamp: m.MaxTimestamp,
+		Checksum:     m.Checksum,
+		State:        segmentedlog.SegmentState(m.State),
+	}
+	if m.CreatedAt != 0 {
+		info.CreatedAt = time.Unix(0, m.CreatedAt)
+	}
+	if m.SealedAt != 0 {
+		info.SealedAt = time.Unix(0, m.SealedAt)
+	}
+
+	return info
+}
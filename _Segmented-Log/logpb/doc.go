@@ -0,0 +1,4 @@
+// Package logpb holds the messages the log server and its client exchange.
+// they are described in log.proto, log.pb.go is generated from it by
+// genProto.sh at the root of the repository.
+package logpb
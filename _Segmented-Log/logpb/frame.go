@@ -0,0 +1,61 @@
+package logpb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	proto "github.com/gogo/protobuf/proto"
+)
+
+// MaxFrameSize bounds a single message so a corrupted or hostile length
+// can not make the reader allocate without limit
+const MaxFrameSize = 64 << 20
+
+var ErrFrameTooLarge = errors.New("frame is larger than MaxFrameSize")
+
+// WriteFrame writes m prefixed with its 4 byte big-endian length
+func WriteFrame(w io.Writer, m proto.Message) error {
+	data, err := proto.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if len(data) > MaxFrameSize {
+		return ErrFrameTooLarge
+	}
+
+	b := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(b[0:4], uint32(len(data)))
+	copy(b[4:], data)
+
+	_, err = w.Write(b)
+	return err
+}
+
+// ReadFrame reads the next frame from r into m
+func ReadFrame(r io.Reader, m proto.Message) error {
+	var size [4]byte
+	_, err := io.ReadFull(r, size[:])
+	if err != nil {
+		return err
+	}
+
+	n := binary.BigEndian.Uint32(size[:])
+	if n > MaxFrameSize {
+		return ErrFrameTooLarge
+	}
+
+	data := make([]byte, n)
+	_, err = io.ReadFull(r, data)
+	if err != nil {
+		return err
+	}
+
+	err = proto.Unmarshal(data, m)
+	if err != nil {
+		return fmt.Errorf("decoding frame: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,776 @@
+// Code generated by protoc-gen-gogo. DO NOT EDIT.
+// source: _Segmented-Log/logpb/log.proto
+
+package logpb
+
+import (
+	fmt "fmt"
+	proto "github.com/gogo/protobuf/proto"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.GoGoProtoPackageIsVersion3 // please upgrade the proto package
+
+type Request struct {
+	Id                   uint64               `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Produce              *ProduceRequest      `protobuf:"bytes,2,opt,name=produce,proto3" json:"produce,omitempty"`
+	Fetch                *FetchRequest        `protobuf:"bytes,3,opt,name=fetch,proto3" json:"fetch,omitempty"`
+	ListSegments         *ListSegmentsRequest `protobuf:"bytes,4,opt,name=list_segments,json=listSegments,proto3" json:"list_segments,omitempty"`
+	Truncate             *TruncateRequest     `protobuf:"bytes,5,opt,name=truncate,proto3" json:"truncate,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}             `json:"-"`
+	XXX_unrecognized     []byte               `json:"-"`
+	XXX_sizecache        int32                `json:"-"`
+}
+
+func (m *Request) Reset()         { *m = Request{} }
+func (m *Request) String() string { return proto.CompactTextString(m) }
+func (*Request) ProtoMessage()    {}
+func (*Request) Descriptor() ([]byte, []int) {
+	return fileDescriptor_3f002df74f3862dd, []int{0}
+}
+func (m *Request) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Request.Unmarshal(m, b)
+}
+func (m *Request) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Request.Marshal(b, m, deterministic)
+}
+func (m *Request) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Request.Merge(m, src)
+}
+func (m *Request) XXX_Size() int {
+	return xxx_messageInfo_Request.Size(m)
+}
+func (m *Request) XXX_DiscardUnknown() {
+	xxx_messageInfo_Request.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Request proto.InternalMessageInfo
+
+func (m *Request) GetId() uint64 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+func (m *Request) GetProduce() *ProduceRequest {
+	if m != nil {
+		return m.Produce
+	}
+	return nil
+}
+
+func (m *Request) GetFetch() *FetchRequest {
+	if m != nil {
+		return m.Fetch
+	}
+	return nil
+}
+
+func (m *Request) GetListSegments() *ListSegmentsRequest {
+	if m != nil {
+		return m.ListSegments
+	}
+	return nil
+}
+
+func (m *Request) GetTruncate() *TruncateRequest {
+	if m != nil {
+		return m.Truncate
+	}
+	return nil
+}
+
+type Response struct {
+	Id                   uint64                `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Error                string                `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`
+	Produce              *ProduceResponse      `protobuf:"bytes,3,opt,name=produce,proto3" json:"produce,omitempty"`
+	Fetch                *FetchResponse        `protobuf:"bytes,4,opt,name=fetch,proto3" json:"fetch,omitempty"`
+	ListSegments         *ListSegmentsResponse `protobuf:"bytes,5,opt,name=list_segments,json=listSegments,proto3" json:"list_segments,omitempty"`
+	Truncate             *TruncateResponse     `protobuf:"bytes,6,opt,name=truncate,proto3" json:"truncate,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}              `json:"-"`
+	XXX_unrecognized     []byte                `json:"-"`
+	XXX_sizecache        int32                 `json:"-"`
+}
+
+func (m *Response) Reset()         { *m = Response{} }
+func (m *Response) String() string { return proto.CompactTextString(m) }
+func (*Response) ProtoMessage()    {}
+func (*Response) Descriptor() ([]byte, []int) {
+	return fileDescriptor_3f002df74f3862dd, []int{1}
+}
+func (m *Response) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Response.Unmarshal(m, b)
+}
+func (m *Response) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Response.Marshal(b, m, deterministic)
+}
+func (m *Response) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Response.Merge(m, src)
+}
+func (m *Response) XXX_Size() int {
+	return xxx_messageInfo_Response.Size(m)
+}
+func (m *Response) XXX_DiscardUnknown() {
+	xxx_messageInfo_Response.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Response proto.InternalMessageInfo
+
+func (m *Response) GetId() uint64 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+func (m *Response) GetError() string {
+	if m != nil {
+		return m.Error
+	}
+	return ""
+}
+
+func (m *Response) GetProduce() *ProduceResponse {
+	if m != nil {
+		return m.Produce
+	}
+	return nil
+}
+
+func (m *Response) GetFetch() *FetchResponse {
+	if m != nil {
+		return m.Fetch
+	}
+	return nil
+}
+
+func (m *Response) GetListSegments() *ListSegmentsResponse {
+	if m != nil {
+		return m.ListSegments
+	}
+	return nil
+}
+
+func (m *Response) GetTruncate() *TruncateResponse {
+	if m != nil {
+		return m.Truncate
+	}
+	return nil
+}
+
+type Header struct {
+	Key                  string   `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Value                []byte   `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Header) Reset()         { *m = Header{} }
+func (m *Header) String() string { return proto.CompactTextString(m) }
+func (*Header) ProtoMessage()    {}
+func (*Header) Descriptor() ([]byte, []int) {
+	return fileDescriptor_3f002df74f3862dd, []int{2}
+}
+func (m *Header) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Header.Unmarshal(m, b)
+}
+func (m *Header) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Header.Marshal(b, m, deterministic)
+}
+func (m *Header) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Header.Merge(m, src)
+}
+func (m *Header) XXX_Size() int {
+	return xxx_messageInfo_Header.Size(m)
+}
+func (m *Header) XXX_DiscardUnknown() {
+	xxx_messageInfo_Header.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Header proto.InternalMessageInfo
+
+func (m *Header) GetKey() string {
+	if m != nil {
+		return m.Key
+	}
+	return ""
+}
+
+func (m *Header) GetValue() []byte {
+	if m != nil {
+		return m.Value
+	}
+	return nil
+}
+
+type Record struct {
+	Id        uint64 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Timestamp int64  `protobuf:"varint,2,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	Key       []byte `protobuf:"bytes,3,opt,name=key,proto3" json:"key,omitempty"`
+	Value     []byte `protobuf:"bytes,4,opt,name=value,proto3" json:"value,omitempty"`
+	// proto3 can not tell an empty value from a missing one
+	Tombstone            bool      `protobuf:"varint,5,opt,name=tombstone,proto3" json:"tombstone,omitempty"`
+	Headers              []*Header `protobuf:"bytes,6,rep,name=headers,proto3" json:"headers,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}  `json:"-"`
+	XXX_unrecognized     []byte    `json:"-"`
+	XXX_sizecache        int32     `json:"-"`
+}
+
+func (m *Record) Reset()         { *m = Record{} }
+func (m *Record) String() string { return proto.CompactTextString(m) }
+func (*Record) ProtoMessage()    {}
+func (*Record) Descriptor() ([]byte, []int) {
+	return fileDescriptor_3f002df74f3862dd, []int{3}
+}
+func (m *Record) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Record.Unmarshal(m, b)
+}
+func (m *Record) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Record.Marshal(b, m, deterministic)
+}
+func (m *Record) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Record.Merge(m, src)
+}
+func (m *Record) XXX_Size() int {
+	return xxx_messageInfo_Record.Size(m)
+}
+func (m *Record) XXX_DiscardUnknown() {
+	xxx_messageInfo_Record.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Record proto.InternalMessageInfo
+
+func (m *Record) GetId() uint64 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+func (m *Record) GetTimestamp() int64 {
+	if m != nil {
+		return m.Timestamp
+	}
+	return 0
+}
+
+func (m *Record) GetKey() []byte {
+	if m != nil {
+		return m.Key
+	}
+	return nil
+}
+
+func (m *Record) GetValue() []byte {
+	if m != nil {
+		return m.Value
+	}
+	return nil
+}
+
+func (m *Record) GetTombstone() bool {
+	if m != nil {
+		return m.Tombstone
+	}
+	return false
+}
+
+func (m *Record) GetHeaders() []*Header {
+	if m != nil {
+		return m.Headers
+	}
+	return nil
+}
+
+type ProduceRequest struct {
+	Records              []*Record `protobuf:"bytes,1,rep,name=records,proto3" json:"records,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}  `json:"-"`
+	XXX_unrecognized     []byte    `json:"-"`
+	XXX_sizecache        int32     `json:"-"`
+}
+
+func (m *ProduceRequest) Reset()         { *m = ProduceRequest{} }
+func (m *ProduceRequest) String() string { return proto.CompactTextString(m) }
+func (*ProduceRequest) ProtoMessage()    {}
+func (*ProduceRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_3f002df74f3862dd, []int{4}
+}
+func (m *ProduceRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ProduceRequest.Unmarshal(m, b)
+}
+func (m *ProduceRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ProduceRequest.Marshal(b, m, deterministic)
+}
+func (m *ProduceRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ProduceRequest.Merge(m, src)
+}
+func (m *ProduceRequest) XXX_Size() int {
+	return xxx_messageInfo_ProduceRequest.Size(m)
+}
+func (m *ProduceRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ProduceRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ProduceRequest proto.InternalMessageInfo
+
+func (m *ProduceRequest) GetRecords() []*Record {
+	if m != nil {
+		return m.Records
+	}
+	return nil
+}
+
+type ProduceResponse struct {
+	BaseId               uint64   `protobuf:"varint,1,opt,name=base_id,json=baseId,proto3" json:"base_id,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ProduceResponse) Reset()         { *m = ProduceResponse{} }
+func (m *ProduceResponse) String() string { return proto.CompactTextString(m) }
+func (*ProduceResponse) ProtoMessage()    {}
+func (*ProduceResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_3f002df74f3862dd, []int{5}
+}
+func (m *ProduceResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ProduceResponse.Unmarshal(m, b)
+}
+func (m *ProduceResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ProduceResponse.Marshal(b, m, deterministic)
+}
+func (m *ProduceResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ProduceResponse.Merge(m, src)
+}
+func (m *ProduceResponse) XXX_Size() int {
+	return xxx_messageInfo_ProduceResponse.Size(m)
+}
+func (m *ProduceResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_ProduceResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ProduceResponse proto.InternalMessageInfo
+
+func (m *ProduceResponse) GetBaseId() uint64 {
+	if m != nil {
+		return m.BaseId
+	}
+	return 0
+}
+
+type FetchRequest struct {
+	FromId               uint64   `protobuf:"varint,1,opt,name=from_id,json=fromId,proto3" json:"from_id,omitempty"`
+	MaxBytes             uint64   `protobuf:"varint,2,opt,name=max_bytes,json=maxBytes,proto3" json:"max_bytes,omitempty"`
+	MaxWaitMs            int64    `protobuf:"varint,3,opt,name=max_wait_ms,json=maxWaitMs,proto3" json:"max_wait_ms,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *FetchRequest) Reset()         { *m = FetchRequest{} }
+func (m *FetchRequest) String() string { return proto.CompactTextString(m) }
+func (*FetchRequest) ProtoMessage()    {}
+func (*FetchRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_3f002df74f3862dd, []int{6}
+}
+func (m *FetchRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_FetchRequest.Unmarshal(m, b)
+}
+func (m *FetchRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_FetchRequest.Marshal(b, m, deterministic)
+}
+func (m *FetchRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_FetchRequest.Merge(m, src)
+}
+func (m *FetchRequest) XXX_Size() int {
+	return xxx_messageInfo_FetchRequest.Size(m)
+}
+func (m *FetchRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_FetchRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_FetchRequest proto.InternalMessageInfo
+
+func (m *FetchRequest) GetFromId() uint64 {
+	if m != nil {
+		return m.FromId
+	}
+	return 0
+}
+
+func (m *FetchRequest) GetMaxBytes() uint64 {
+	if m != nil {
+		return m.MaxBytes
+	}
+	return 0
+}
+
+func (m *FetchRequest) GetMaxWaitMs() int64 {
+	if m != nil {
+		return m.MaxWaitMs
+	}
+	return 0
+}
+
+type FetchResponse struct {
+	Records              []*Record `protobuf:"bytes,1,rep,name=records,proto3" json:"records,omitempty"`
+	LastId               uint64    `protobuf:"varint,2,opt,name=last_id,json=lastId,proto3" json:"last_id,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}  `json:"-"`
+	XXX_unrecognized     []byte    `json:"-"`
+	XXX_sizecache        int32     `json:"-"`
+}
+
+func (m *FetchResponse) Reset()         { *m = FetchResponse{} }
+func (m *FetchResponse) String() string { return proto.CompactTextString(m) }
+func (*FetchResponse) ProtoMessage()    {}
+func (*FetchResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_3f002df74f3862dd, []int{7}
+}
+func (m *FetchResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_FetchResponse.Unmarshal(m, b)
+}
+func (m *FetchResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_FetchResponse.Marshal(b, m, deterministic)
+}
+func (m *FetchResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_FetchResponse.Merge(m, src)
+}
+func (m *FetchResponse) XXX_Size() int {
+	return xxx_messageInfo_FetchResponse.Size(m)
+}
+func (m *FetchResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_FetchResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_FetchResponse proto.InternalMessageInfo
+
+func (m *FetchResponse) GetRecords() []*Record {
+	if m != nil {
+		return m.Records
+	}
+	return nil
+}
+
+func (m *FetchResponse) GetLastId() uint64 {
+	if m != nil {
+		return m.LastId
+	}
+	return 0
+}
+
+type ListSegmentsRequest struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ListSegmentsRequest) Reset()         { *m = ListSegmentsRequest{} }
+func (m *ListSegmentsRequest) String() string { return proto.CompactTextString(m) }
+func (*ListSegmentsRequest) ProtoMessage()    {}
+func (*ListSegmentsRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_3f002df74f3862dd, []int{8}
+}
+func (m *ListSegmentsRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListSegmentsRequest.Unmarshal(m, b)
+}
+func (m *ListSegmentsRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListSegmentsRequest.Marshal(b, m, deterministic)
+}
+func (m *ListSegmentsRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListSegmentsRequest.Merge(m, src)
+}
+func (m *ListSegmentsRequest) XXX_Size() int {
+	return xxx_messageInfo_ListSegmentsRequest.Size(m)
+}
+func (m *ListSegmentsRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListSegmentsRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ListSegmentsRequest proto.InternalMessageInfo
+
+type SegmentInfo struct {
+	BaseId               uint64   `protobuf:"varint,1,opt,name=base_id,json=baseId,proto3" json:"base_id,omitempty"`
+	LastId               uint64   `protobuf:"varint,2,opt,name=last_id,json=lastId,proto3" json:"last_id,omitempty"`
+	Size_                uint64   `protobuf:"varint,3,opt,name=size,proto3" json:"size,omitempty"`
+	MaxTimestamp         uint64   `protobuf:"varint,4,opt,name=max_timestamp,json=maxTimestamp,proto3" json:"max_timestamp,omitempty"`
+	CreatedAt            int64    `protobuf:"varint,5,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	SealedAt             int64    `protobuf:"varint,6,opt,name=sealed_at,json=sealedAt,proto3" json:"sealed_at,omitempty"`
+	Checksum             uint32   `protobuf:"varint,7,opt,name=checksum,proto3" json:"checksum,omitempty"`
+	State                string   `protobuf:"bytes,8,opt,name=state,proto3" json:"state,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *SegmentInfo) Reset()         { *m = SegmentInfo{} }
+func (m *SegmentInfo) String() string { return proto.CompactTextString(m) }
+func (*SegmentInfo) ProtoMessage()    {}
+func (*SegmentInfo) Descriptor() ([]byte, []int) {
+	return fileDescriptor_3f002df74f3862dd, []int{9}
+}
+func (m *SegmentInfo) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SegmentInfo.Unmarshal(m, b)
+}
+func (m *SegmentInfo) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SegmentInfo.Marshal(b, m, deterministic)
+}
+func (m *SegmentInfo) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SegmentInfo.Merge(m, src)
+}
+func (m *SegmentInfo) XXX_Size() int {
+	return xxx_messageInfo_SegmentInfo.Size(m)
+}
+func (m *SegmentInfo) XXX_DiscardUnknown() {
+	xxx_messageInfo_SegmentInfo.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SegmentInfo proto.InternalMessageInfo
+
+func (m *SegmentInfo) GetBaseId() uint64 {
+	if m != nil {
+		return m.BaseId
+	}
+	return 0
+}
+
+func (m *SegmentInfo) GetLastId() uint64 {
+	if m != nil {
+		return m.LastId
+	}
+	return 0
+}
+
+func (m *SegmentInfo) GetSize_() uint64 {
+	if m != nil {
+		return m.Size_
+	}
+	return 0
+}
+
+func (m *SegmentInfo) GetMaxTimestamp() uint64 {
+	if m != nil {
+		return m.MaxTimestamp
+	}
+	return 0
+}
+
+func (m *SegmentInfo) GetCreatedAt() int64 {
+	if m != nil {
+		return m.CreatedAt
+	}
+	return 0
+}
+
+func (m *SegmentInfo) GetSealedAt() int64 {
+	if m != nil {
+		return m.SealedAt
+	}
+	return 0
+}
+
+func (m *SegmentInfo) GetChecksum() uint32 {
+	if m != nil {
+		return m.Checksum
+	}
+	return 0
+}
+
+func (m *SegmentInfo) GetState() string {
+	if m != nil {
+		return m.State
+	}
+	return ""
+}
+
+type ListSegmentsResponse struct {
+	Segments             []*SegmentInfo `protobuf:"bytes,1,rep,name=segments,proto3" json:"segments,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}       `json:"-"`
+	XXX_unrecognized     []byte         `json:"-"`
+	XXX_sizecache        int32          `json:"-"`
+}
+
+func (m *ListSegmentsResponse) Reset()         { *m = ListSegmentsResponse{} }
+func (m *ListSegmentsResponse) String() string { return proto.CompactTextString(m) }
+func (*ListSegmentsResponse) ProtoMessage()    {}
+func (*ListSegmentsResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_3f002df74f3862dd, []int{10}
+}
+func (m *ListSegmentsResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListSegmentsResponse.Unmarshal(m, b)
+}
+func (m *ListSegmentsResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListSegmentsResponse.Marshal(b, m, deterministic)
+}
+func (m *ListSegmentsResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListSegmentsResponse.Merge(m, src)
+}
+func (m *ListSegmentsResponse) XXX_Size() int {
+	return xxx_messageInfo_ListSegmentsResponse.Size(m)
+}
+func (m *ListSegmentsResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListSegmentsResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ListSegmentsResponse proto.InternalMessageInfo
+
+func (m *ListSegmentsResponse) GetSegments() []*SegmentInfo {
+	if m != nil {
+		return m.Segments
+	}
+	return nil
+}
+
+type TruncateRequest struct {
+	BeforeId             uint64   `protobuf:"varint,1,opt,name=before_id,json=beforeId,proto3" json:"before_id,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *TruncateRequest) Reset()         { *m = TruncateRequest{} }
+func (m *TruncateRequest) String() string { return proto.CompactTextString(m) }
+func (*TruncateRequest) ProtoMessage()    {}
+func (*TruncateRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_3f002df74f3862dd, []int{11}
+}
+func (m *TruncateRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_TruncateRequest.Unmarshal(m, b)
+}
+func (m *TruncateRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_TruncateRequest.Marshal(b, m, deterministic)
+}
+func (m *TruncateRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_TruncateRequest.Merge(m, src)
+}
+func (m *TruncateRequest) XXX_Size() int {
+	return xxx_messageInfo_TruncateRequest.Size(m)
+}
+func (m *TruncateRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_TruncateRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_TruncateRequest proto.InternalMessageInfo
+
+func (m *TruncateRequest) GetBeforeId() uint64 {
+	if m != nil {
+		return m.BeforeId
+	}
+	return 0
+}
+
+type TruncateResponse struct {
+	FirstId              uint64   `protobuf:"varint,1,opt,name=first_id,json=firstId,proto3" json:"first_id,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *TruncateResponse) Reset()         { *m = TruncateResponse{} }
+func (m *TruncateResponse) String() string { return proto.CompactTextString(m) }
+func (*TruncateResponse) ProtoMessage()    {}
+func (*TruncateResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_3f002df74f3862dd, []int{12}
+}
+func (m *TruncateResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_TruncateResponse.Unmarshal(m, b)
+}
+func (m *TruncateResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_TruncateResponse.Marshal(b, m, deterministic)
+}
+func (m *TruncateResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_TruncateResponse.Merge(m, src)
+}
+func (m *TruncateResponse) XXX_Size() int {
+	return xxx_messageInfo_TruncateResponse.Size(m)
+}
+func (m *TruncateResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_TruncateResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_TruncateResponse proto.InternalMessageInfo
+
+func (m *TruncateResponse) GetFirstId() uint64 {
+	if m != nil {
+		return m.FirstId
+	}
+	return 0
+}
+
+func init() {
+	proto.RegisterType((*Request)(nil), "logpb.Request")
+	proto.RegisterType((*Response)(nil), "logpb.Response")
+	proto.RegisterType((*Header)(nil), "logpb.Header")
+	proto.RegisterType((*Record)(nil), "logpb.Record")
+	proto.RegisterType((*ProduceRequest)(nil), "logpb.ProduceRequest")
+	proto.RegisterType((*ProduceResponse)(nil), "logpb.ProduceResponse")
+	proto.RegisterType((*FetchRequest)(nil), "logpb.FetchRequest")
+	proto.RegisterType((*FetchResponse)(nil), "logpb.FetchResponse")
+	proto.RegisterType((*ListSegmentsRequest)(nil), "logpb.ListSegmentsRequest")
+	proto.RegisterType((*SegmentInfo)(nil), "logpb.SegmentInfo")
+	proto.RegisterType((*ListSegmentsResponse)(nil), "logpb.ListSegmentsResponse")
+	proto.RegisterType((*TruncateRequest)(nil), "logpb.TruncateRequest")
+	proto.RegisterType((*TruncateResponse)(nil), "logpb.TruncateResponse")
+}
+
+func init() { proto.RegisterFile("_Segmented-Log/logpb/log.proto", fileDescriptor_3f002df74f3862dd) }
+
+var fileDescriptor_3f002df74f3862dd = []byte{
+	// 722 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x8c, 0x55, 0x51, 0x6f, 0xda, 0x3a,
+	0x14, 0x56, 0x08, 0x84, 0x70, 0x80, 0xb6, 0x72, 0xdb, 0xdb, 0xdc, 0xf6, 0xde, 0x0a, 0xe5, 0x3e,
+	0x5c, 0x56, 0x09, 0xa8, 0xda, 0xa7, 0x3d, 0x6d, 0xad, 0xb6, 0x6e, 0x48, 0xdd, 0xd4, 0xb9, 0xd5,
+	0x36, 0xed, 0x05, 0x99, 0xc4, 0x40, 0x54, 0x12, 0x33, 0xdb, 0x6c, 0xb0, 0xf7, 0xfd, 0x94, 0xfd,
+	0xb5, 0x3d, 0xec, 0x57, 0x4c, 0xb6, 0x13, 0x20, 0x94, 0x4a, 0x7b, 0x41, 0x39, 0xdf, 0xf9, 0x3e,
+	0xfb, 0x9c, 0xcf, 0x3e, 0x06, 0x8e, 0x7b, 0xb7, 0x74, 0x18, 0xd3, 0x44, 0xd2, 0xb0, 0x75, 0xcd,
+	0x86, 0x9d, 0x31, 0x1b, 0x4e, 0xfa, 0xea, 0xb7, 0x3d, 0xe1, 0x4c, 0x32, 0x54, 0xd2, 0x80, 0xff,
+	0xcb, 0x82, 0x32, 0xa6, 0x9f, 0xa7, 0x54, 0x48, 0xb4, 0x05, 0x85, 0x28, 0xf4, 0xac, 0x86, 0xd5,
+	0x2c, 0xe2, 0x42, 0x14, 0xa2, 0x0e, 0x94, 0x27, 0x9c, 0x85, 0xd3, 0x80, 0x7a, 0x85, 0x86, 0xd5,
+	0xac, 0x9e, 0xed, 0xb7, 0xb5, 0xa8, 0x7d, 0x63, 0xd0, 0x54, 0x87, 0x33, 0x16, 0x7a, 0x02, 0xa5,
+	0x01, 0x95, 0xc1, 0xc8, 0xb3, 0x35, 0x7d, 0x37, 0xa5, 0x5f, 0x29, 0x2c, 0x23, 0x1b, 0x06, 0x7a,
+	0x06, 0xf5, 0x71, 0x24, 0x64, 0x4f, 0x98, 0x22, 0x85, 0x57, 0xd4, 0x92, 0xc3, 0x54, 0x72, 0x1d,
+	0x09, 0x99, 0xd6, 0x2f, 0x32, 0x65, 0x6d, 0xbc, 0x02, 0xa2, 0x33, 0x70, 0x25, 0x9f, 0x26, 0x01,
+	0x91, 0xd4, 0x2b, 0x69, 0xed, 0x5f, 0xa9, 0xf6, 0x2e, 0x85, 0x33, 0xdd, 0x82, 0xe7, 0x7f, 0x2f,
+	0x80, 0x8b, 0xa9, 0x98, 0xb0, 0x44, 0xd0, 0x07, 0xdd, 0xee, 0x41, 0x89, 0x72, 0xce, 0xb8, 0xee,
+	0xb5, 0x82, 0x4d, 0x80, 0x4e, 0x97, 0x1e, 0xd8, 0xb9, 0x5d, 0x16, 0x1e, 0x98, 0xe5, 0x96, 0x26,
+	0x9c, 0x64, 0x26, 0x98, 0x8e, 0xf6, 0xf2, 0x26, 0xa4, 0xec, 0xd4, 0x85, 0xe7, 0xeb, 0x2e, 0x98,
+	0x4e, 0x8e, 0x36, 0xba, 0x90, 0x4a, 0xf3, 0x36, 0x9c, 0xaf, 0xd8, 0xe0, 0x68, 0xf1, 0xc1, 0x03,
+	0x1b, 0x52, 0xe1, 0xd2, 0x87, 0x53, 0x70, 0x5e, 0x53, 0x12, 0x52, 0x8e, 0x76, 0xc0, 0xbe, 0xa7,
+	0x73, 0xed, 0x42, 0x05, 0xab, 0x4f, 0x65, 0xc3, 0x17, 0x32, 0x9e, 0x9a, 0x23, 0xaf, 0x61, 0x13,
+	0xf8, 0x3f, 0x2c, 0x70, 0x30, 0x0d, 0x18, 0x0f, 0x1f, 0xf8, 0xf6, 0x0f, 0x54, 0x64, 0x14, 0x53,
+	0x21, 0x49, 0x3c, 0xd1, 0x22, 0x1b, 0x2f, 0x81, 0x6c, 0x03, 0x5b, 0x2f, 0x96, 0xdf, 0xa0, 0xb8,
+	0xb2, 0x81, 0x5e, 0x85, 0xc5, 0x7d, 0x21, 0x59, 0x62, 0xce, 0xd3, 0xc5, 0x4b, 0x00, 0xfd, 0x0f,
+	0xe5, 0x91, 0x2e, 0x58, 0x78, 0x4e, 0xc3, 0x6e, 0x56, 0xcf, 0xea, 0x69, 0x93, 0xa6, 0x0d, 0x9c,
+	0x65, 0xfd, 0xa7, 0xb0, 0x95, 0xbf, 0x9c, 0x4a, 0xca, 0x75, 0xe1, 0xc2, 0xb3, 0x72, 0x52, 0xd3,
+	0x0e, 0xce, 0xb2, 0xfe, 0x09, 0x6c, 0xaf, 0x9d, 0x29, 0x3a, 0x80, 0x72, 0x9f, 0x08, 0xda, 0x5b,
+	0xf4, 0xeb, 0xa8, 0xb0, 0x1b, 0xfa, 0x21, 0xd4, 0x56, 0x2f, 0xb5, 0x22, 0x0e, 0x38, 0x8b, 0x57,
+	0x88, 0x2a, 0xec, 0x86, 0xe8, 0x08, 0x2a, 0x31, 0x99, 0xf5, 0xfa, 0x73, 0x49, 0x85, 0x36, 0xa7,
+	0x88, 0xdd, 0x98, 0xcc, 0x2e, 0x55, 0x8c, 0x8e, 0xa1, 0xaa, 0x92, 0x5f, 0x49, 0x24, 0x7b, 0xb1,
+	0xd0, 0x1e, 0xd9, 0x58, 0xf1, 0x3f, 0x90, 0x48, 0xbe, 0x11, 0xfe, 0x3b, 0xa8, 0xe7, 0x6e, 0xcd,
+	0x1f, 0xf7, 0xa2, 0xea, 0x19, 0x13, 0x21, 0x55, 0x3d, 0x66, 0x53, 0x47, 0x85, 0xdd, 0xd0, 0xdf,
+	0x87, 0xdd, 0x0d, 0xa3, 0xe5, 0xff, 0xb4, 0xa0, 0x9a, 0x62, 0xdd, 0x64, 0xc0, 0x1e, 0x6d, 0xfc,
+	0xd1, 0x85, 0x11, 0x82, 0xa2, 0x88, 0xbe, 0x99, 0x21, 0x29, 0x62, 0xfd, 0x8d, 0xfe, 0x83, 0xba,
+	0xea, 0x6f, 0x79, 0x3b, 0x8a, 0x3a, 0x59, 0x8b, 0xc9, 0xec, 0x2e, 0xc3, 0xd0, 0xbf, 0x00, 0x01,
+	0xa7, 0x44, 0xd2, 0xb0, 0x47, 0xa4, 0x3e, 0x79, 0x1b, 0x57, 0x52, 0xe4, 0x42, 0x2a, 0x03, 0x05,
+	0x25, 0x63, 0x93, 0x75, 0x74, 0xd6, 0x35, 0xc0, 0x85, 0x44, 0x87, 0xe0, 0x06, 0x23, 0x1a, 0xdc,
+	0x8b, 0x69, 0xec, 0x95, 0x1b, 0x56, 0xb3, 0x8e, 0x17, 0xb1, 0xba, 0x66, 0x42, 0xaa, 0xa9, 0x70,
+	0xcd, 0x38, 0xeb, 0xc0, 0xbf, 0x82, 0xbd, 0x4d, 0x43, 0x85, 0xda, 0xe0, 0x2e, 0x66, 0xd0, 0x58,
+	0x8b, 0x52, 0x6b, 0x57, 0x6c, 0xc1, 0x0b, 0x8e, 0xdf, 0x86, 0xed, 0xb5, 0x67, 0x46, 0x55, 0xda,
+	0xa7, 0x03, 0xc6, 0x57, 0x5c, 0x73, 0x0d, 0xd0, 0x0d, 0xfd, 0x16, 0xec, 0xac, 0xcf, 0x23, 0xfa,
+	0x1b, 0xdc, 0x41, 0xc4, 0x85, 0x5c, 0xf2, 0xcb, 0x3a, 0xee, 0x86, 0x97, 0x1f, 0x3f, 0xbd, 0x1f,
+	0x46, 0x72, 0x34, 0xed, 0xb7, 0x03, 0x16, 0x77, 0x5e, 0x26, 0x01, 0x9f, 0x4f, 0x24, 0xed, 0xbe,
+	0xe8, 0x60, 0x3a, 0x19, 0x47, 0x6a, 0x95, 0xd6, 0x0d, 0xe1, 0x32, 0x92, 0x11, 0x4b, 0x5a, 0x6f,
+	0xe9, 0x4c, 0xb6, 0x5e, 0xd1, 0x84, 0x72, 0xa2, 0xe3, 0xdb, 0xb9, 0x90, 0x34, 0x16, 0x9d, 0x4d,
+	0x7f, 0x00, 0x7d, 0x47, 0xbf, 0xfe, 0xe7, 0xbf, 0x07, 0x00, 0xba, 0x10, 0x68, 0x01, 0x1f, 0x06,
+	0x00, 0x00,
+}
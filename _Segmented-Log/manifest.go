@@ -0,0 +1,214 @@
+package segmentedlog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// manifestFile lists every segment of the log, local or offloaded. it is
+// always replaced as a whole through writeFileAtomic.
+const manifestFile = "manifest.json"
+
+var ErrChecksumMismatch = errors.New("segment store does not match its checksum")
+
+// SegmentState tells where a segment lives and whether it still takes
+// appends
+type SegmentState string
+
+const (
+	SegmentActive    SegmentState = "active"
+	SegmentSealed    SegmentState = "sealed"
+	SegmentOffloaded SegmentState = "offloaded"
+)
+
+// SegmentInfo is the manifest entry of a segment. LastID is BaseID-1 while
+// the segment is empty, Checksum is the crc32c of the whole store file and
+// is only set once the segment is sealed.
+type SegmentInfo struct {
+	BaseID       uint64            `json:"base_id"`
+	LastID       uint64            `json:"last_id"`
+	Size         uint64            `json:"size"`
+	MaxTimestamp uint64            `json:"max_timestamp"`
+	CreatedAt    time.Time         `json:"created_at"`
+	SealedAt     time.Time         `json:"sealed_at"`
+	Checksum     uint32            `json:"checksum"`
+	State        SegmentState      `json:"state"`
+	Objects      map[string]string `json:"objects,omitempty"`
+}
+
+// nextID returns the recordID following the last one in the segment
+func (i SegmentInfo) nextID() uint64 {
+	return i.LastID + 1
+}
+
+// loadManifest returns the segments listed in the manifest of the log in
+// dir ordered by base ID
+func loadManifest(dir string) ([]SegmentInfo, error) {
+	data, err := os.ReadFile(filepath.Join(dir, manifestFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []SegmentInfo
+	err = json.Unmarshal(data, &infos)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", manifestFile, err)
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].BaseID < infos[j].BaseID })
+	return infos, nil
+}
+
+// saveManifest writes the current segments of the log to the manifest. the
+// caller must hold l.mu.
+func (l *Log) saveManifest() error {
+	data, err := json.MarshalIndent(l.segmentInfos(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filepath.Join(l.dir, manifestFile), data)
+}
+
+// segmentInfos returns the manifest entries of every segment, the entry of
+// the active segment is refreshed first. the caller must hold l.mu.
+func (l *Log) segmentInfos() []SegmentInfo {
+	infos := append([]SegmentInfo(nil), l.remote...)
+	for _, s := range l.segments {
+		info := l.infos[s.baseID()]
+		if info.State == SegmentActive {
+			info = describeSegment(s, info)
+		}
+		infos = append(infos, info)
+	}
+
+	return infos
+}
+
+// describeSegment updates the parts of info that change with every append
+func describeSegment(s *segmentedlog, info SegmentInfo) SegmentInfo {
+	info.BaseID = s.baseID()
+	info.LastID = s.nextID() - 1
+	info.Size = s.store.size.Load()
+	info.MaxTimestamp = s.maxTimestamp.Load()
+	return info
+}
+
+// sealSegment seals s and records its final size and checksum in its
+// manifest entry, the manifest itself is not written. the caller must hold
+// l.mu.
+func (l *Log) sealSegment(s *segmentedlog) error {
+	err := s.seal()
+	if err != nil {
+		return err
+	}
+
+	info := l.infos[s.baseID()]
+	if info.SealedAt.IsZero() {
+		info.SealedAt = time.Now()
+	}
+
+	return l.updateChecksum(s, info)
+}
+
+// updateChecksum recomputes the checksum of a sealed segment after its
+// store was written, the manifest itself is not written. the caller must
+// hold l.mu.
+func (l *Log) updateChecksum(s *segmentedlog, info SegmentInfo) error {
+	sum, err := checksumFile(l.segmentPath(s.baseID()) + storeSuffix)
+	if err != nil {
+		return err
+	}
+
+	info = describeSegment(s, info)
+	info.State = SegmentSealed
+	info.Checksum = sum
+	l.infos[s.baseID()] = info
+	return nil
+}
+
+// checksumFile returns the crc32c of the whole file at path
+func checksumFile(path string) (uint32, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	return checksum(f)
+}
+
+// checksum returns the crc32c of everything read from r
+func checksum(r io.Reader) (uint32, error) {
+	h := crc32.New(crcTable)
+	_, err := io.Copy(h, r)
+	if err != nil {
+		return 0, err
+	}
+
+	return h.Sum32(), nil
+}
+
+// Segments returns the manifest entries of every segment of the log,
+// oldest first
+func (l *Log) Segments() []SegmentInfo {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.segmentInfos()
+}
+
+// Verify checks the store of every sealed local segment against the
+// checksum recorded when it was sealed. offloaded segments are left to the
+// object store.
+func (l *Log) Verify() error {
+	// only the segments and their entries are taken under the lock, the
+	// stores are read from the open files of the acquired segments so
+	// appends and maintenance go on and a compacted segment is still
+	// checked against the entry it was taken with
+	var segments []*segmentedlog
+	var infos []SegmentInfo
+	l.mu.Lock()
+	for _, s := range l.segments {
+		info := l.infos[s.baseID()]
+		if info.State != SegmentSealed || !s.acquire() {
+			continue
+		}
+		segments = append(segments, s)
+		infos = append(infos, info)
+	}
+	l.mu.Unlock()
+
+	defer func() {
+		for _, s := range segments {
+			s.release()
+		}
+	}()
+
+	for i, s := range segments {
+		st, err := s.store.file.Stat()
+		if err != nil {
+			return err
+		}
+
+		sum, err := checksum(io.NewSectionReader(s.store.file, 0, st.Size()))
+		if err != nil {
+			return err
+		}
+		if sum != infos[i].Checksum {
+			return fmt.Errorf("segment %d: %w", infos[i].BaseID, ErrChecksumMismatch)
+		}
+	}
+
+	return nil
+}
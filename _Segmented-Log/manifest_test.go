@@ -0,0 +1,146 @@
+package segmentedlog
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestManifest(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, &testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i <= 100; i++ {
+		_, err := l.Append([]byte(fmt.Sprintf("record-%d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	infos := l.Segments()
+	if len(infos) < 2 {
+		t.Fatalf("expected several segments, got %d", len(infos))
+	}
+
+	next := uint64(1)
+	for n, info := range infos {
+		if info.BaseID != next {
+			t.Errorf("expected segment %d to start at %d, got %d", n, next, info.BaseID)
+		}
+		next = info.LastID + 1
+
+		if n == len(infos)-1 {
+			if info.State != SegmentActive {
+				t.Errorf("expected the last segment to be active, got %s", info.State)
+			}
+			continue
+		}
+
+		if info.State != SegmentSealed || info.SealedAt.IsZero() || info.Checksum == 0 {
+			t.Errorf("expected segment %d to be sealed with a checksum, got %+v", info.BaseID, info)
+		}
+
+		st, err := os.Stat(l.segmentPath(info.BaseID) + indexSuffix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := int64(info.LastID-info.BaseID+1) * 16; st.Size() != want {
+			t.Errorf("expected sealed index of %d bytes, got %d", want, st.Size())
+		}
+	}
+	if next != 101 {
+		t.Errorf("expected the manifest to cover 100 records, ends at %d", next-1)
+	}
+
+	err = l.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = l.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err = Open(dir, &testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	reopened := l.Segments()
+	if len(reopened) != len(infos) {
+		t.Fatalf("expected %d segments after reopening, got %d", len(infos), len(reopened))
+	}
+	for n := range infos {
+		if !reopened[n].CreatedAt.Equal(infos[n].CreatedAt) || reopened[n].Checksum != infos[n].Checksum {
+			t.Errorf("expected segment %d to keep its manifest entry, got %+v", infos[n].BaseID, reopened[n])
+		}
+	}
+
+	for i := 1; i <= 100; i++ {
+		r, err := l.Read(uint64(i))
+		if err != nil || string(r.Value) != fmt.Sprintf("record-%d", i) {
+			t.Fatalf("reading %d: %s %v", i, r.Value, err)
+		}
+	}
+
+	f, err := os.OpenFile(l.segmentPath(1)+storeSuffix, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.WriteAt([]byte{0xff}, 20)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = l.Verify()
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("expected a checksum mismatch, got %v", err)
+	}
+}
+
+func TestManifestOffloaded(t *testing.T) {
+	objects, err := NewDirStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := newTestConfig()
+	cfg.Tiering.Store = objects
+
+	l, err := Open(t.TempDir(), &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	for i := 1; i <= 100; i++ {
+		_, err := l.Append([]byte(fmt.Sprintf("record-%d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = l.Offload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	infos := l.Segments()
+	for _, info := range infos[:len(infos)-1] {
+		if info.State != SegmentOffloaded || len(info.Objects) != len(segmentSuffixes) {
+			t.Errorf("expected segment %d to be offloaded, got %+v", info.BaseID, info)
+		}
+	}
+
+	r, err := l.Read(1)
+	if err != nil || string(r.Value) != "record-1" {
+		t.Errorf("expected to read an offloaded record, got %s %v", r.Value, err)
+	}
+}
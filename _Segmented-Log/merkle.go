@@ -0,0 +1,558 @@
+package segmentedlog
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// merkleDir is the directory, inside the log directory, holding the tree
+// nodes of a verifiable log
+const merkleDir = "merkle"
+
+const (
+	treeFile    = "tree.json"
+	signingFile = "signing.key"
+)
+
+var (
+	ErrTreeDisabled = errors.New("log keeps no merkle tree")
+	ErrTreeSize     = errors.New("tree size is out of range")
+)
+
+// merkleTree is an RFC 6962 Merkle tree over the records of the log, leaf i
+// is the record firstID+i. level k keeps the hash of every complete
+// subtree of 2^k leaves, so the nodes of any proof are a few reads away.
+//
+// the level files are only synced on flush, tree.json then records how
+// many leaves made it to disk. on open anything past that is dropped and
+// hashed again from the records.
+type merkleTree struct {
+	mu      sync.Mutex
+	dir     string
+	firstID uint64
+	levels  []*os.File
+	counts  []uint64
+}
+
+// treeState is the content of tree.json
+type treeState struct {
+	FirstID uint64 `json:"first_id"`
+	Size    uint64 `json:"size"`
+}
+
+// SignedTreeHead commits to the first TreeSize records of the log, RootHash
+// is their Merkle tree hash and Signature the ed25519 signature of the
+// RFC 6962 tree head over TreeSize, Timestamp and RootHash
+type SignedTreeHead struct {
+	TreeSize  uint64
+	Timestamp time.Time
+	RootHash  []byte
+	Signature []byte
+}
+
+// InclusionProof proves that the record ID is leaf LeafIndex of the tree
+// of TreeSize records
+type InclusionProof struct {
+	ID        uint64
+	LeafIndex uint64
+	TreeSize  uint64
+	Hashes    [][]byte
+}
+
+// openMerkleTree opens the tree in dir, a new tree starts at firstID
+func openMerkleTree(dir string, firstID uint64) (*merkleTree, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	state := treeState{FirstID: firstID}
+	data, err := os.ReadFile(filepath.Join(dir, treeFile))
+	if err == nil {
+		err = json.Unmarshal(data, &state)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", treeFile, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	t := &merkleTree{dir: dir, firstID: state.FirstID}
+	for k := 0; ; k++ {
+		count := state.Size >> k
+		path := t.levelPath(k)
+		if count == 0 {
+			// levels above the synced tree are rebuilt on append
+			err = os.Remove(path)
+			if errors.Is(err, os.ErrNotExist) {
+				break
+			}
+			if err != nil {
+				t.close()
+				return nil, err
+			}
+			continue
+		}
+
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			t.close()
+			return nil, err
+		}
+		t.levels = append(t.levels, f)
+		t.counts = append(t.counts, count)
+
+		fi, err := f.Stat()
+		if err == nil && uint64(fi.Size()) < count*sha256.Size {
+			err = fmt.Errorf("merkle level %d is shorter than %s says", k, treeFile)
+		}
+		if err == nil {
+			err = f.Truncate(int64(count * sha256.Size))
+		}
+		if err != nil {
+			t.close()
+			return nil, err
+		}
+	}
+
+	return t, t.saveState()
+}
+
+func (t *merkleTree) levelPath(k int) string {
+	return filepath.Join(t.dir, fmt.Sprintf("level-%02d.nodes", k))
+}
+
+// size returns the number of leaves in the tree
+func (t *merkleTree) size() uint64 {
+	if len(t.counts) == 0 {
+		return 0
+	}
+
+	return t.counts[0]
+}
+
+// nextID returns the recordID the next leaf stands for
+func (t *merkleTree) nextID() uint64 {
+	return t.firstID + t.size()
+}
+
+// append adds a leaf and every complete subtree it finishes
+func (t *merkleTree) append(leaf []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	h := leaf
+	for k := 0; ; k++ {
+		if k == len(t.levels) {
+			f, err := os.OpenFile(t.levelPath(k), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+			if err != nil {
+				return err
+			}
+			t.levels = append(t.levels, f)
+			t.counts = append(t.counts, 0)
+		}
+
+		_, err := t.levels[k].WriteAt(h, int64(t.counts[k]*sha256.Size))
+		if err != nil {
+			return err
+		}
+		t.counts[k]++
+
+		if t.counts[k]%2 != 0 {
+			return nil
+		}
+
+		left, err := t.node(k, t.counts[k]-2)
+		if err != nil {
+			return err
+		}
+		h = hashChildren(left, h)
+	}
+}
+
+// node returns the hash of the i-th complete subtree of 2^k leaves
+func (t *merkleTree) node(k int, i uint64) ([]byte, error) {
+	h := make([]byte, sha256.Size)
+	_, err := t.levels[k].ReadAt(h, int64(i*sha256.Size))
+	if err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// hash returns the Merkle tree hash of the n leaves from start on, start
+// is always a multiple of the largest power of two below n
+func (t *merkleTree) hash(start, n uint64) ([]byte, error) {
+	if n == 0 {
+		h := sha256.Sum256(nil)
+		return h[:], nil
+	}
+	if n&(n-1) == 0 {
+		return t.node(bits.TrailingZeros64(n), start/n)
+	}
+
+	k := splitPoint(n)
+	left, err := t.node(bits.TrailingZeros64(k), start/k)
+	if err != nil {
+		return nil, err
+	}
+
+	right, err := t.hash(start+k, n-k)
+	if err != nil {
+		return nil, err
+	}
+
+	return hashChildren(left, right), nil
+}
+
+// inclusion returns the audit path of leaf m in the tree of the n leaves
+// from start on, RFC 6962 section 2.1.1
+func (t *merkleTree) inclusion(m, start, n uint64) ([][]byte, error) {
+	if n <= 1 {
+		return nil, nil
+	}
+
+	k := splitPoint(n)
+	var path [][]byte
+	var sibling []byte
+	var err error
+	if m < k {
+		path, err = t.inclusion(m, start, k)
+		if err == nil {
+			sibling, err = t.hash(start+k, n-k)
+		}
+	} else {
+		path, err = t.inclusion(m-k, start+k, n-k)
+		if err == nil {
+			sibling, err = t.hash(start, k)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return append(path, sibling), nil
+}
+
+// consistency returns the proof that the tree of the first m leaves is a
+// prefix of the tree of the n leaves from start on, RFC 6962 section 2.1.2
+func (t *merkleTree) consistency(m, start, n uint64, complete bool) ([][]byte, error) {
+	if m == n {
+		if complete {
+			return nil, nil
+		}
+
+		h, err := t.hash(start, n)
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{h}, nil
+	}
+
+	k := splitPoint(n)
+	var proof [][]byte
+	var sibling []byte
+	var err error
+	if m <= k {
+		proof, err = t.consistency(m, start, k, complete)
+		if err == nil {
+			sibling, err = t.hash(start+k, n-k)
+		}
+	} else {
+		proof, err = t.consistency(m-k, start+k, n-k, false)
+		if err == nil {
+			sibling, err = t.hash(start, k)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return append(proof, sibling), nil
+}
+
+// truncate drops the leaves from size on, the log lost their records
+func (t *merkleTree) truncate(size uint64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for k := range t.levels {
+		t.counts[k] = size >> k
+		err := t.levels[k].Truncate(int64(t.counts[k] * sha256.Size))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// flush syncs the level files and then records their size in tree.json
+func (t *merkleTree) flush() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, f := range t.levels {
+		err := f.Sync()
+		if err != nil {
+			return err
+		}
+	}
+
+	return t.saveState()
+}
+
+func (t *merkleTree) saveState() error {
+	data, err := json.Marshal(treeState{FirstID: t.firstID, Size: t.size()})
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filepath.Join(t.dir, treeFile), data)
+}
+
+func (t *merkleTree) close() error {
+	var errs []error
+	for _, f := range t.levels {
+		errs = append(errs, f.Close())
+	}
+
+	return errors.Join(errs...)
+}
+
+// splitPoint returns the largest power of two below n, n > 1
+func splitPoint(n uint64) uint64 {
+	return 1 << (bits.Len64(n-1) - 1)
+}
+
+// LeafHash returns the Merkle leaf hash of r as it is stored in the log,
+// the SHA-256 of a zero byte followed by the ID, timestamp, key, value and
+// headers of the record
+func LeafHash(r Record) []byte {
+	buf := []byte{0}
+	buf = binary.BigEndian.AppendUint64(buf, r.ID)
+	buf = binary.BigEndian.AppendUint64(buf, timestamp(r.Timestamp))
+	buf = appendBytes(buf, r.Key)
+	buf = appendBytes(buf, r.Value)
+	buf = binary.AppendUvarint(buf, uint64(len(r.Headers)))
+	for _, h := range r.Headers {
+		buf = appendBytes(buf, []byte(h.Key))
+		buf = appendBytes(buf, h.Value)
+	}
+
+	h := sha256.Sum256(buf)
+	return h[:]
+}
+
+// hashChildren returns the hash of an interior node
+func hashChildren(left, right []byte) []byte {
+	buf := make([]byte, 0, 1+len(left)+len(right))
+	buf = append(buf, 1)
+	buf = append(buf, left...)
+	buf = append(buf, right...)
+
+	h := sha256.Sum256(buf)
+	return h[:]
+}
+
+// treeHeadInput returns the bytes a tree head signature covers, the
+// TreeHeadSignature of RFC 6962 section 3.5
+func treeHeadInput(sth SignedTreeHead) []byte {
+	buf := []byte{0, 1} // v1, tree_hash
+	buf = binary.BigEndian.AppendUint64(buf, uint64(sth.Timestamp.UnixMilli()))
+	buf = binary.BigEndian.AppendUint64(buf, sth.TreeSize)
+	return append(buf, sth.RootHash...)
+}
+
+// openTree opens the Merkle tree of the log and hashes the records it is
+// missing. the caller must hold l.mu.
+func (l *Log) openTree() error {
+	dir := filepath.Join(l.dir, merkleDir)
+	t, err := openMerkleTree(dir, l.firstID())
+	if err != nil {
+		return err
+	}
+
+	key := l.cfg.Merkle.SigningKey
+	if key == nil {
+		key, err = loadSigningKey(filepath.Join(dir, signingFile))
+		if err != nil {
+			t.close()
+			return err
+		}
+	}
+
+	// a crash may have cut records off the log that the tree still holds
+	if last := l.view.Load().active().lastID.Load(); t.nextID() > last+1 {
+		err = t.truncate(last + 1 - t.firstID)
+		if err != nil {
+			t.close()
+			return err
+		}
+	}
+
+	l.tree, l.signingKey = t, key
+	return l.extendTree()
+}
+
+// loadSigningKey reads the ed25519 seed stored at path, a new key is
+// generated the first time
+func loadSigningKey(path string) (ed25519.PrivateKey, error) {
+	seed, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		seed = make([]byte, ed25519.SeedSize)
+		_, err = rand.Read(seed)
+		if err == nil {
+			err = os.WriteFile(path, seed, 0600)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("%s does not hold an ed25519 seed", path)
+	}
+
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// firstID returns the first recordID still stored. the caller must hold
+// l.mu.
+func (l *Log) firstID() uint64 {
+	if len(l.remote) > 0 {
+		return l.remote[0].BaseID
+	}
+
+	return l.segments[0].baseID()
+}
+
+// extendTree adds the leaves of the records appended since the tree was
+// last extended, they are read back so the tree covers them exactly as
+// stored. the caller must hold l.mu.
+func (l *Log) extendTree() error {
+	last := l.LastID()
+	for id := l.tree.nextID(); id <= last; {
+		s, release, err := l.acquire(id)
+		if err != nil {
+			return err
+		}
+
+		b, _, err := s.batchFrom(id)
+		release()
+		if err != nil {
+			return err
+		}
+
+		for _, r := range b.records {
+			if r.ID < id {
+				continue
+			}
+			if r.ID != id {
+				return fmt.Errorf("merkle tree: record %d is missing", id)
+			}
+
+			err = l.tree.append(LeafHash(r))
+			if err != nil {
+				return err
+			}
+			id++
+		}
+	}
+
+	return nil
+}
+
+// PublicKey returns the key that verifies the signed tree heads of the log
+func (l *Log) PublicKey() (ed25519.PublicKey, error) {
+	if l.tree == nil {
+		return nil, ErrTreeDisabled
+	}
+
+	return l.signingKey.Public().(ed25519.PublicKey), nil
+}
+
+// TreeHead returns the signed head of the tree over every flushed record
+func (l *Log) TreeHead() (SignedTreeHead, error) {
+	if l.tree == nil {
+		return SignedTreeHead{}, ErrTreeDisabled
+	}
+
+	l.tree.mu.Lock()
+	defer l.tree.mu.Unlock()
+
+	var size uint64
+	if flushed := l.FlushedID(); flushed >= l.tree.firstID {
+		size = min(flushed+1-l.tree.firstID, l.tree.size())
+	}
+
+	root, err := l.tree.hash(0, size)
+	if err != nil {
+		return SignedTreeHead{}, err
+	}
+
+	sth := SignedTreeHead{
+		TreeSize:  size,
+		Timestamp: time.UnixMilli(time.Now().UnixMilli()),
+		RootHash:  root,
+	}
+	sth.Signature = ed25519.Sign(l.signingKey, treeHeadInput(sth))
+
+	return sth, nil
+}
+
+// InclusionProof returns the proof that the record id is part of the tree
+// of the first treeSize records
+func (l *Log) InclusionProof(id uint64, treeSize uint64) (InclusionProof, error) {
+	if l.tree == nil {
+		return InclusionProof{}, ErrTreeDisabled
+	}
+
+	l.tree.mu.Lock()
+	defer l.tree.mu.Unlock()
+
+	if treeSize > l.tree.size() {
+		return InclusionProof{}, ErrTreeSize
+	}
+	if id < l.tree.firstID || id-l.tree.firstID >= treeSize {
+		return InclusionProof{}, ErrRecordNotFound
+	}
+
+	m := id - l.tree.firstID
+	hashes, err := l.tree.inclusion(m, 0, treeSize)
+	if err != nil {
+		return InclusionProof{}, err
+	}
+
+	return InclusionProof{ID: id, LeafIndex: m, TreeSize: treeSize, Hashes: hashes}, nil
+}
+
+// ConsistencyProof returns the proof that the tree of the first oldSize
+// records is a prefix of the tree of the first newSize records
+func (l *Log) ConsistencyProof(oldSize, newSize uint64) ([][]byte, error) {
+	if l.tree == nil {
+		return nil, ErrTreeDisabled
+	}
+
+	l.tree.mu.Lock()
+	defer l.tree.mu.Unlock()
+
+	if oldSize > newSize || newSize > l.tree.size() {
+		return nil, ErrTreeSize
+	}
+	if oldSize == 0 || oldSize == newSize {
+		return nil, nil
+	}
+
+	return l.tree.consistency(oldSize, 0, newSize, true)
+}
@@ -0,0 +1,231 @@
+package segmentedlog
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// referenceRoot computes the Merkle tree hash of RFC 6962 straight from
+// its definition
+func referenceRoot(leaves [][]byte) []byte {
+	switch len(leaves) {
+	case 0:
+		h := sha256.Sum256(nil)
+		return h[:]
+	case 1:
+		return leaves[0]
+	}
+
+	k := int(splitPoint(uint64(len(leaves))))
+	return hashChildren(referenceRoot(leaves[:k]), referenceRoot(leaves[k:]))
+}
+
+func openVerifiable(t *testing.T, dir string) *Log {
+	t.Helper()
+
+	cfg := newTestConfig()
+	cfg.Merkle.Enabled = true
+
+	l, err := Open(dir, &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return l
+}
+
+func TestMerkleProofs(t *testing.T) {
+	l := openVerifiable(t, t.TempDir())
+	defer l.Close()
+
+	var records []Record
+	var leaves [][]byte
+	for len(records) < 40 {
+		batch := make([]Record, 1+len(records)%3)
+		for i := range batch {
+			batch[i].Key = []byte(fmt.Sprintf("key-%d", len(records)+i))
+			batch[i].Value = []byte(fmt.Sprintf("value-%d", len(records)+i))
+		}
+
+		_, err := l.AppendBatch(batch)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for range batch {
+			r, err := l.Read(uint64(len(records) + 1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			records = append(records, r)
+			leaves = append(leaves, LeafHash(r))
+		}
+	}
+
+	for n := uint64(1); n <= uint64(len(records)); n++ {
+		root, err := l.tree.hash(0, n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(root, referenceRoot(leaves[:n])) {
+			t.Fatalf("root of %d leaves differs from the reference", n)
+		}
+
+		for _, r := range records[:n] {
+			proof, err := l.InclusionProof(r.ID, n)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = VerifyInclusion(r, proof, root)
+			if err != nil {
+				t.Fatalf("record %d in tree of %d: %v", r.ID, n, err)
+			}
+
+			forged := r
+			forged.Value = []byte("forged")
+			if VerifyInclusion(forged, proof, root) == nil {
+				t.Fatalf("forged record %d verified", r.ID)
+			}
+		}
+
+		for m := uint64(0); m <= n; m++ {
+			proof, err := l.ConsistencyProof(m, n)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			oldRoot := referenceRoot(leaves[:m])
+			err = VerifyConsistency(m, n, oldRoot, root, proof)
+			if err != nil {
+				t.Fatalf("consistency of %d and %d: %v", m, n, err)
+			}
+
+			wrong := bytes.Repeat([]byte{1}, sha256.Size)
+			if m > 0 && m < n && VerifyConsistency(m, n, wrong, root, proof) == nil {
+				t.Fatalf("consistency of %d and %d verified with a wrong old root", m, n)
+			}
+		}
+	}
+
+	_, err := l.InclusionProof(uint64(len(records)+1), uint64(len(records)))
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("expected no proof for a record outside the tree, got %v", err)
+	}
+}
+
+func TestTreeHead(t *testing.T) {
+	dir := t.TempDir()
+	l := openVerifiable(t, dir)
+
+	for i := 0; i < 10; i++ {
+		_, err := l.Append([]byte(fmt.Sprintf("record-%d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	key, err := l.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sth, err := l.TreeHead()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sth.TreeSize != 10 {
+		t.Errorf("expected a tree of 10 records, got %d", sth.TreeSize)
+	}
+
+	err = VerifyTreeHead(key, sth)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forged := sth
+	forged.TreeSize = 9
+	if VerifyTreeHead(key, forged) == nil {
+		t.Error("expected a tree head with a changed size to fail")
+	}
+
+	err = l.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the tree lost the leaves that were not flushed before a crash, they
+	// are hashed again from the records
+	err = writeFileAtomic(filepath.Join(dir, merkleDir, treeFile), []byte(`{"first_id": 1, "size": 3}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l = openVerifiable(t, dir)
+	defer l.Close()
+
+	reopened, err := l.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !key.Equal(reopened) {
+		t.Error("expected the signing key to survive a reopen")
+	}
+
+	head, err := l.TreeHead()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.TreeSize != sth.TreeSize || !bytes.Equal(head.RootHash, sth.RootHash) {
+		t.Errorf("expected the same tree after reopening, got %d records", head.TreeSize)
+	}
+
+	_, err = l.Append([]byte("after reopen"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	head, err = l.TreeHead()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proof, err := l.ConsistencyProof(sth.TreeSize, head.TreeSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = VerifyConsistency(sth.TreeSize, head.TreeSize, sth.RootHash, head.RootHash, proof)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMerkleWithoutCompaction(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.Merkle.Enabled = true
+	cfg.Compaction.Enabled = true
+
+	_, err := Open(t.TempDir(), &cfg)
+	if err == nil {
+		t.Fatal("expected a verifiable log with compaction to be refused")
+	}
+
+	l := openVerifiable(t, t.TempDir())
+	defer l.Close()
+
+	err = l.Compact()
+	if !errors.Is(err, ErrCompactVerifiable) {
+		t.Errorf("expected compaction to be refused, got %v", err)
+	}
+
+	_, err = os.Stat(filepath.Join(l.dir, merkleDir, signingFile))
+	if err != nil {
+		t.Errorf("expected a generated signing key: %v", err)
+	}
+}
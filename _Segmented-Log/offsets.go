@@ -0,0 +1,145 @@
+package segmentedlog
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+)
+
+// offsetsDir is the directory, inside the log directory, of the internal
+// log holding committed consumer positions
+const offsetsDir = "__consumer_offsets"
+
+// the offsets log keeps small segments so compaction gets to the sealed
+// ones soon
+const (
+	offsetsSegmentSize = 1 << 20
+	offsetsIndexSize   = 64 << 10
+)
+
+var ErrNoCommittedID = errors.New("consumer group has not committed an id")
+
+// consumerOffsets keeps the last recordID every consumer group processed.
+// each commit is a record keyed by the group name in an internal log that
+// is always compacted, so it only grows with the number of groups. the
+// latest positions are kept in memory and rebuilt from the log on open.
+type consumerOffsets struct {
+	mu        sync.Mutex
+	log       *Log
+	committed map[string]uint64
+}
+
+func openConsumerOffsets(dir string, cfg *Config) (*consumerOffsets, error) {
+	// commits are small and must never expire, only compaction removes
+	// the ones that were superseded
+	var ocfg Config
+	ocfg.Segment.MaxStoreSizeBytes = offsetsSegmentSize
+	ocfg.Segment.MaxIndexSizeBytes = offsetsIndexSize
+	ocfg.Compaction = cfg.Compaction
+	ocfg.Compaction.Enabled = true
+	if ocfg.Compaction.Interval <= 0 {
+		// the log's own compaction may be off without an interval
+		ocfg.Compaction.Interval = defaultCompactionInterval
+	}
+
+	l, err := open(dir, &ocfg)
+	if err != nil {
+		return nil, err
+	}
+
+	o := &consumerOffsets{
+		log:       l,
+		committed: map[string]uint64{},
+	}
+
+	it := l.Iterator(1)
+	for it.Next() {
+		r := it.Record()
+		if r.Value == nil {
+			delete(o.committed, string(r.Key))
+			continue
+		}
+
+		if len(r.Value) != 8 {
+			l.Close()
+			return nil, ErrCorruptBatch
+		}
+		o.committed[string(r.Key)] = binary.BigEndian.Uint64(r.Value)
+	}
+	if it.Err() != nil {
+		l.Close()
+		return nil, it.Err()
+	}
+
+	l.start()
+	return o, nil
+}
+
+// Commit records that group has processed every record up to and
+// including id
+func (l *Log) Commit(group string, id uint64) error {
+	o := l.offsets
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, id)
+
+	_, err := o.log.AppendBatch([]Record{{Key: []byte(group), Value: value}})
+	if err != nil {
+		return err
+	}
+
+	o.committed[group] = id
+	return nil
+}
+
+// Committed returns the last recordID group committed
+func (l *Log) Committed(group string) (uint64, error) {
+	o := l.offsets
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	id, ok := o.committed[group]
+	if !ok {
+		return 0, ErrNoCommittedID
+	}
+
+	return id, nil
+}
+
+// DeleteGroup forgets the position of group, so retention no longer waits
+// for it
+func (l *Log) DeleteGroup(group string) error {
+	o := l.offsets
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if _, ok := o.committed[group]; !ok {
+		return nil
+	}
+
+	_, err := o.log.AppendBatch([]Record{{Key: []byte(group)}})
+	if err != nil {
+		return err
+	}
+
+	delete(o.committed, group)
+	return nil
+}
+
+// consumedID returns the highest recordID every consumer group has
+// processed. ok is false when there are no groups.
+func (o *consumerOffsets) consumedID() (id uint64, ok bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for _, c := range o.committed {
+		if !ok || c < id {
+			id = c
+		}
+		ok = true
+	}
+
+	return id, ok
+}
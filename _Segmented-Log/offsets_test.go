@@ -0,0 +1,179 @@
+package segmentedlog
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestCommit(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, &testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = l.Committed("billing")
+	if !errors.Is(err, ErrNoCommittedID) {
+		t.Errorf("expected ErrNoCommittedID, got %v", err)
+	}
+
+	for i := uint64(1); i <= 50; i++ {
+		err = l.Commit("billing", i)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = l.Commit("audit", 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = l.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err = Open(dir, &testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	id, err := l.Committed("billing")
+	if err != nil || id != 50 {
+		t.Errorf("expected billing at 50, got %d %v", id, err)
+	}
+	id, err = l.Committed("audit")
+	if err != nil || id != 7 {
+		t.Errorf("expected audit at 7, got %d %v", id, err)
+	}
+
+	err = l.DeleteGroup("audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = l.Committed("audit")
+	if !errors.Is(err, ErrNoCommittedID) {
+		t.Errorf("expected deleted group to be gone, got %v", err)
+	}
+}
+
+func TestOffsetsWithoutCompactionInterval(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.Compaction.Interval = 0
+
+	l, err := Open(t.TempDir(), &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	if got := l.offsets.log.cfg.Compaction.Interval; got != defaultCompactionInterval {
+		t.Errorf("expected the offsets log to compact every %v, got %v", defaultCompactionInterval, got)
+	}
+}
+
+func TestRetentionWaitsForConsumers(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.Retention.MaxBytes = 1
+
+	l, err := Open(t.TempDir(), &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	for i := 1; i <= 100; i++ {
+		_, err := l.Append([]byte(fmt.Sprintf("record-%d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = l.Commit("slow", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	segments := len(l.segments)
+	err = l.EnforceRetention()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l.segments) != segments {
+		t.Fatalf("expected unconsumed segments to be kept, had %d, got %d", segments, len(l.segments))
+	}
+
+	second := l.segments[1].baseID()
+	err = l.Commit("slow", second-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = l.EnforceRetention()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l.segments) != segments-1 || l.segments[0].baseID() != second {
+		t.Fatalf("expected only the consumed segment to be deleted, got %d segments", len(l.segments))
+	}
+
+	_, err = l.Read(1)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("expected deleted record to be gone, got %v", err)
+	}
+
+	err = l.DeleteGroup("slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = l.EnforceRetention()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l.segments) != 1 {
+		t.Errorf("expected everything but the active segment to be deleted, got %d segments", len(l.segments))
+	}
+}
+
+func TestCloseAfterOffsetsFailure(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, &testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 10; i++ {
+		_, err = l.Append([]byte(fmt.Sprintf("record-%d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// closing the offsets log a second time fails
+	err = l.offsets.log.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = l.Close()
+	if err == nil {
+		t.Fatal("expected closing the offsets log twice to fail")
+	}
+
+	infos, err := loadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) == 0 || infos[len(infos)-1].LastID != 10 {
+		t.Errorf("expected the manifest to be written up to 10, got %+v", infos)
+	}
+
+	l, err = Open(dir, &testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	checkTestLog(t, l, 10)
+}
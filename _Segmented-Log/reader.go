@@ -0,0 +1,301 @@
+package segmentedlog
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"net"
+	"os"
+)
+
+// section is a contiguous range of a store holding whole batches. release
+// hands the segment back once the section has been read
+type section struct {
+	store   *store
+	offset  uint64
+	size    uint64
+	release func()
+}
+
+// sections returns the store ranges holding the batches with records in
+// [fromID, toID). maxBytes limits the total size to whole batches, the
+// first batch is always included so consumers keep making progress. a
+// maxBytes of 0 means no limit. the caller has to release every section.
+func (l *Log) sections(fromID, toID uint64, maxBytes uint64) ([]section, error) {
+	if flushed := l.FlushedID(); toID > flushed+1 {
+		toID = flushed + 1
+	}
+
+	var sections []section
+	var total uint64
+	for id := fromID; id < toID; {
+		s, release, err := l.acquire(id)
+		if err == ErrRecordNotFound {
+			break
+		}
+		if err != nil {
+			releaseSections(sections)
+			return nil, err
+		}
+		id = s.lastID.Load() + 1
+
+		_, start, err := s.batchFrom(fromID)
+		if err == ErrRecordNotFound {
+			release()
+			continue
+		}
+		if err != nil {
+			release()
+			releaseSections(sections)
+			return nil, err
+		}
+
+		sec := section{store: s.store, offset: start, release: release}
+		full := false
+		for offset := start; offset < s.store.size.Load(); {
+			size, baseID, err := s.batchAt(offset)
+			if err != nil {
+				release()
+				releaseSections(sections)
+				return nil, err
+			}
+			if baseID >= toID {
+				break
+			}
+			if maxBytes > 0 && total > 0 && total+size > maxBytes {
+				full = true
+				break
+			}
+
+			sec.size += size
+			total += size
+			offset += size
+		}
+
+		if sec.size > 0 {
+			sections = append(sections, sec)
+		} else {
+			release()
+		}
+		if full {
+			break
+		}
+	}
+
+	return sections, nil
+}
+
+func releaseSections(sections []section) {
+	for _, sec := range sections {
+		sec.release()
+	}
+}
+
+// Reader reads the flushed batches holding the records in an ID range in
+// the format they are kept in the store, every batch is prefixed with its
+// 8 byte length. the first and the last batch may hold records outside
+// the range. DecodeRecords turns the bytes back into records.
+type Reader struct {
+	sections []section
+	current  *io.SectionReader
+	release  func()
+}
+
+// NewReader returns a Reader over the records in [fromID, toID)
+func (l *Log) NewReader(fromID, toID uint64) (*Reader, error) {
+	sections, err := l.sections(fromID, toID, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Reader{sections: sections}, nil
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.sections) == 0 {
+				return 0, io.EOF
+			}
+
+			sec := r.sections[0]
+			r.sections = r.sections[1:]
+			r.current = io.NewSectionReader(sec.store.file, int64(sec.offset), int64(sec.size))
+			r.release = sec.release
+		}
+
+		n, err := r.current.Read(p)
+		if err == io.EOF {
+			r.current = nil
+			r.release()
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+
+		return n, err
+	}
+}
+
+// Close releases the segments the Reader has not read to the end, it only
+// needs to be called when the Reader is abandoned before io.EOF
+func (r *Reader) Close() error {
+	if r.current != nil {
+		r.current = nil
+		r.release()
+	}
+
+	releaseSections(r.sections)
+	r.sections = nil
+
+	return nil
+}
+
+// DecodeRecords parses the bytes produced by Reader or SendTo, data must
+// hold whole batches
+func DecodeRecords(data []byte) ([]Record, error) {
+	var records []Record
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, ErrCorruptBatch
+		}
+
+		size := binary.BigEndian.Uint64(data[0:8])
+		if size > uint64(len(data)-8) {
+			return nil, ErrCorruptBatch
+		}
+
+		b, err := decodeBatch(data[8 : 8+size])
+		if err != nil {
+			return nil, err
+		}
+
+		records = append(records, b.records...)
+		data = data[8+size:]
+	}
+
+	return records, nil
+}
+
+// SendTo writes the flushed batches holding records from fromID onwards
+// to conn, stopping before maxBytes is exceeded. the bytes go straight
+// from the store files to the socket with sendfile where the platform
+// supports it. the number of bytes written is returned.
+func (l *Log) SendTo(conn net.Conn, fromID uint64, maxBytes int) (int64, error) {
+	sections, err := l.sections(fromID, math.MaxUint64, uint64(maxBytes))
+	if err != nil {
+		return 0, err
+	}
+
+	defer releaseSections(sections)
+
+	var written int64
+	for _, sec := range sections {
+		n, err := sendFile(conn, sec.store.file, int64(sec.offset), int64(sec.size))
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+// copyFile copies n bytes of f starting at offset to conn through a
+// buffer, it backs sendFile where sendfile is not available
+func copyFile(conn net.Conn, f *os.File, offset, n int64) (int64, error) {
+	return io.Copy(conn, io.NewSectionReader(f, offset, n))
+}
+
+// Iterator walks the records of the log in ID order up to FlushedID
+//
+//	it := l.Iterator(1)
+//	for it.Next() {
+//		process(it.Record())
+//	}
+//	return it.Err()
+//
+// Next returns false once the end of the log is reached, it can be called
+// again later to pick up records appended in the meantime
+type Iterator struct {
+	log     *Log
+	next    uint64
+	records []Record
+	record  Record
+	err     error
+}
+
+// Iterator returns an iterator starting at the first record at or after
+// fromID
+func (l *Log) Iterator(fromID uint64) *Iterator {
+	return &Iterator{log: l, next: fromID}
+}
+
+// Next advances to the next record
+func (it *Iterator) Next() bool {
+	for len(it.records) == 0 {
+		if it.err != nil {
+			return false
+		}
+
+		records, err := it.log.readBatchFrom(it.next)
+		if err == ErrRecordNotFound {
+			return false
+		}
+		if err != nil {
+			it.err = err
+			return false
+		}
+
+		for _, r := range records {
+			if r.ID >= it.next {
+				it.records = append(it.records, r)
+			}
+		}
+	}
+
+	it.record = it.records[0]
+	it.records = it.records[1:]
+	it.next = it.record.ID + 1
+
+	return true
+}
+
+// Record returns the record Next moved to
+func (it *Iterator) Record() Record {
+	return it.record
+}
+
+// Err returns the error that stopped the iterator, if any
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// readBatchFrom returns the records of the batch holding the first record
+// at or after id
+func (l *Log) readBatchFrom(id uint64) ([]Record, error) {
+	if id > l.FlushedID() {
+		return nil, ErrRecordNotFound
+	}
+
+	for {
+		s, release, err := l.acquire(id)
+		if err != nil {
+			return nil, err
+		}
+
+		b, _, err := s.batchFrom(id)
+		release()
+		if err == ErrRecordNotFound {
+			// the rest of the segment was compacted away
+			id = s.lastID.Load() + 1
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return b.records, nil
+	}
+}
@@ -0,0 +1,130 @@
+package segmentedlog
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"testing"
+)
+
+func newTestLog(t *testing.T, n int) *Log {
+	l, err := Open(t.TempDir(), &testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	for i := 1; i <= n; i++ {
+		_, err := l.Append([]byte(fmt.Sprintf("record-%d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return l
+}
+
+func TestIterator(t *testing.T) {
+	l := newTestLog(t, 100)
+
+	it := l.Iterator(10)
+	want := uint64(10)
+	for it.Next() {
+		r := it.Record()
+		if r.ID != want || string(r.Value) != fmt.Sprintf("record-%d", want) {
+			t.Fatalf("expected record-%d, got %s at %d", want, r.Value, r.ID)
+		}
+		want++
+	}
+	if it.Err() != nil {
+		t.Fatal(it.Err())
+	}
+	if want != 101 {
+		t.Errorf("expected to stop after 100, stopped at %d", want-1)
+	}
+
+	_, err := l.Append([]byte("record-101"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !it.Next() || it.Record().ID != 101 {
+		t.Error("expected iterator to pick up the new record")
+	}
+}
+
+func TestReader(t *testing.T) {
+	l := newTestLog(t, 100)
+
+	r, err := l.NewReader(20, 80)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := DecodeRecords(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records) != 60 {
+		t.Fatalf("expected 60 records, got %d", len(records))
+	}
+	for i, rec := range records {
+		if rec.ID != uint64(20+i) {
+			t.Errorf("expected id %d, got %d", 20+i, rec.ID)
+		}
+	}
+}
+
+func TestSendTo(t *testing.T) {
+	l := newTestLog(t, 100)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	received := make(chan []byte)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			close(received)
+			return
+		}
+		defer conn.Close()
+		data, _ := io.ReadAll(conn)
+		received <- data
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := l.SendTo(conn, 50, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	data := <-received
+	if int64(len(data)) != n {
+		t.Fatalf("expected %d bytes, received %d", n, len(data))
+	}
+	if n > 200 {
+		t.Errorf("expected at most 200 bytes, sent %d", n)
+	}
+
+	records, err := DecodeRecords(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) == 0 || records[0].ID != 50 {
+		t.Fatalf("expected records starting at 50, got %v", records)
+	}
+}
@@ -0,0 +1,181 @@
+package segmentedlog
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"time"
+)
+
+var (
+	ErrCorruptBatch = errors.New("record batch is corrupted")
+	ErrEmptyBatch   = errors.New("record batch has no records")
+	// ErrRecordTooLarge is returned for a batch that does not fit even an
+	// empty segment
+	ErrRecordTooLarge = errors.New("record batch is larger than a segment")
+	// ErrInvalidTimestamp is returned for a record timestamped before the
+	// unix epoch, the log cannot store it
+	ErrInvalidTimestamp = errors.New("record timestamp is before the unix epoch")
+)
+
+// batchHeaderSize is the size of the fixed part of a record batch
+//
+//	baseID         8 bytes
+//	crc            4 bytes, castagnoli checksum of everything that follows it
+//	attributes     2 bytes
+//	count          4 bytes
+//	baseTimestamp  8 bytes
+//	maxTimestamp   8 bytes
+const batchHeaderSize = 34
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Header is an application defined key/value pair attached to a record
+type Header struct {
+	Key   string
+	Value []byte
+}
+
+// Record is a single entry of the log. ID and Timestamp are assigned when
+// the record is appended, a zero Timestamp is replaced by the append time
+type Record struct {
+	ID        uint64
+	Timestamp time.Time
+	Key       []byte
+	Value     []byte
+	Headers   []Header
+}
+
+// batch is the unit written to the store, every append writes exactly
+// one batch. records carry their ID and timestamp as deltas from the
+// batch header so the header alone describes the ID and time range.
+type batch struct {
+	baseID        uint64
+	attributes    uint16
+	baseTimestamp uint64
+	maxTimestamp  uint64
+	records       []Record
+}
+
+// encodeBatch serializes b. baseID and baseTimestamp are the lowest ID and
+// timestamp of the batch, records are stored relative to them
+func encodeBatch(b *batch) []byte {
+	buf := make([]byte, batchHeaderSize, batchHeaderSize+64*len(b.records))
+	binary.BigEndian.PutUint64(buf[0:8], b.baseID)
+	binary.BigEndian.PutUint16(buf[12:14], b.attributes)
+	binary.BigEndian.PutUint32(buf[14:18], uint32(len(b.records)))
+	binary.BigEndian.PutUint64(buf[18:26], b.baseTimestamp)
+	binary.BigEndian.PutUint64(buf[26:34], b.maxTimestamp)
+
+	for _, r := range b.records {
+		buf = binary.AppendUvarint(buf, r.ID-b.baseID)
+		buf = binary.AppendUvarint(buf, timestamp(r.Timestamp)-b.baseTimestamp)
+		buf = appendBytes(buf, r.Key)
+		buf = appendBytes(buf, r.Value)
+		buf = binary.AppendUvarint(buf, uint64(len(r.Headers)))
+		for _, h := range r.Headers {
+			buf = appendBytes(buf, []byte(h.Key))
+			buf = appendBytes(buf, h.Value)
+		}
+	}
+
+	binary.BigEndian.PutUint32(buf[8:12], crc32.Checksum(buf[12:], crcTable))
+	return buf
+}
+
+// decodeBatch parses and verifies a batch produced by encodeBatch
+func decodeBatch(data []byte) (*batch, error) {
+	if len(data) < batchHeaderSize {
+		return nil, ErrCorruptBatch
+	}
+
+	if binary.BigEndian.Uint32(data[8:12]) != crc32.Checksum(data[12:], crcTable) {
+		return nil, ErrCorruptBatch
+	}
+
+	b := &batch{
+		baseID:        binary.BigEndian.Uint64(data[0:8]),
+		attributes:    binary.BigEndian.Uint16(data[12:14]),
+		baseTimestamp: binary.BigEndian.Uint64(data[18:26]),
+		maxTimestamp:  binary.BigEndian.Uint64(data[26:34]),
+	}
+
+	count := binary.BigEndian.Uint32(data[14:18])
+	d := &decoder{buf: data[batchHeaderSize:]}
+	for i := uint32(0); i < count; i++ {
+		r := Record{
+			ID:        b.baseID + d.uvarint(),
+			Timestamp: time.Unix(0, int64(b.baseTimestamp+d.uvarint())),
+			Key:       d.bytes(),
+			Value:     d.bytes(),
+		}
+		n := d.uvarint()
+		if n > uint64(len(d.buf)) {
+			return nil, ErrCorruptBatch
+		}
+		for j := uint64(0); j < n; j++ {
+			r.Headers = append(r.Headers, Header{Key: string(d.bytes()), Value: d.bytes()})
+		}
+		if d.err != nil {
+			return nil, ErrCorruptBatch
+		}
+		b.records = append(b.records, r)
+	}
+
+	return b, nil
+}
+
+// appendBytes writes a varint length followed by p, nil is stored as -1
+// so tombstones survive a round trip
+func appendBytes(buf []byte, p []byte) []byte {
+	if p == nil {
+		return binary.AppendVarint(buf, -1)
+	}
+
+	buf = binary.AppendVarint(buf, int64(len(p)))
+	return append(buf, p...)
+}
+
+// decoder reads the variable length part of a batch and remembers the
+// first error so callers can check once per record
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = ErrCorruptBatch
+		return 0
+	}
+	d.buf = d.buf[n:]
+
+	return v
+}
+
+func (d *decoder) bytes() []byte {
+	if d.err != nil {
+		return nil
+	}
+
+	l, n := binary.Varint(d.buf)
+	if n <= 0 || l < -1 || l > int64(len(d.buf)-n) {
+		d.err = ErrCorruptBatch
+		return nil
+	}
+	d.buf = d.buf[n:]
+
+	if l == -1 {
+		return nil
+	}
+
+	p := d.buf[:l:l]
+	d.buf = d.buf[l:]
+
+	return p
+}
@@ -0,0 +1,150 @@
+package segmentedlog
+
+import "errors"
+
+// RecoverSegment rebuilds the index and the time index of a segment from
+// its store. the store is scanned batch by batch, every batch is verified
+// and the scan stops at the first one that is torn or corrupted, which is
+// cut off together with everything after it. NewSegement runs the same
+// recovery on its own when the index and the store disagree.
+func RecoverSegment(indexFile string, storeFile string, startID uint64, cfg *Config) error {
+	s, err := openSegmentFiles(indexFile, storeFile, startID, cfg, false)
+	if err != nil {
+		return err
+	}
+
+	err = s.recover()
+	if err != nil {
+		s.close()
+		return err
+	}
+
+	return s.close()
+}
+
+// consistent reports whether the index and the store describe the same
+// records: the batches from the last indexed one on have to be intact and
+// end exactly where the store ends. a dense index has to end with the
+// last record of the last batch, a sparse one with the first record of a
+// batch. the tail of the segment is picked up on the way.
+func (s *segmentedlog) consistent() bool {
+	n := s.index.entries()
+	size := s.store.size.Load()
+	if n == 0 {
+		return size == 0
+	}
+
+	if s.indexInterval == 0 && s.timeIndex.size.Load() == 0 {
+		return false
+	}
+
+	id, offset := s.index.entry(n - 1)
+	start := offset
+	maxTimestamp := s.timeIndex.lastTimestamp.Load()
+	var last *batch
+	for offset < size {
+		data, err := s.store.read(offset)
+		if err != nil {
+			return false
+		}
+
+		b, err := decodeBatch(data)
+		if err != nil || len(b.records) == 0 {
+			return false
+		}
+
+		if last == nil && s.indexInterval > 0 && b.records[0].ID != id {
+			return false
+		}
+		if last != nil && (s.indexInterval == 0 || b.records[0].ID <= last.records[len(last.records)-1].ID) {
+			return false
+		}
+		if b.maxTimestamp > maxTimestamp {
+			maxTimestamp = b.maxTimestamp
+		}
+
+		last = b
+		offset += 8 + uint64(len(data))
+	}
+
+	if offset != size || last == nil {
+		return false
+	}
+
+	next := last.records[len(last.records)-1].ID + 1
+	if s.indexInterval == 0 && next-1 != id {
+		return false
+	}
+
+	s.next = next
+	s.sinceIndex = size - start
+	s.maxTimestamp.Store(maxTimestamp)
+	return true
+}
+
+// recover truncates the store after its last intact batch and writes the
+// index and the time index again from the batches that are left
+func (s *segmentedlog) recover() error {
+	var batches []*batch
+	var offsets []uint64
+
+	next := s.index.startID
+	offset := uint64(0)
+	for offset < s.store.size.Load() {
+		data, err := s.store.read(offset)
+		if err != nil {
+			break
+		}
+
+		b, err := decodeBatch(data)
+		if err != nil || len(b.records) == 0 || b.records[0].ID < next {
+			break
+		}
+
+		batches = append(batches, b)
+		offsets = append(offsets, offset)
+
+		next = b.records[len(b.records)-1].ID + 1
+		offset += 8 + uint64(len(data))
+	}
+
+	if offset < s.store.size.Load() {
+		err := s.store.truncate(offset)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := s.index.reset()
+	if err != nil {
+		return err
+	}
+
+	err = s.timeIndex.reset()
+	if err != nil {
+		return err
+	}
+
+	s.next = s.baseID()
+	s.sinceIndex = 0
+	s.latest = nil
+	s.maxTimestamp.Store(0)
+	for i, b := range batches {
+		end := offset
+		if i+1 < len(offsets) {
+			end = offsets[i+1]
+		}
+
+		err := s.indexBatch(b, offsets[i], end-offsets[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	if !s.consistent() {
+		return errors.New("segment is still inconsistent after recovery")
+	}
+
+	s.publishTail()
+	return nil
+}
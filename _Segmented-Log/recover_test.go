@@ -0,0 +1,157 @@
+package segmentedlog
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func writeTestLog(t *testing.T, dir string, n int) {
+	l, err := Open(dir, &testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i <= n; i++ {
+		_, err := l.Append([]byte(fmt.Sprintf("record-%d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = l.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkTestLog(t *testing.T, l *Log, n int) {
+	for i := 1; i <= n; i++ {
+		r, err := l.Read(uint64(i))
+		if err != nil {
+			t.Fatalf("reading %d: %v", i, err)
+		}
+		if string(r.Value) != fmt.Sprintf("record-%d", i) {
+			t.Errorf("expected record-%d, got %s", i, r.Value)
+		}
+	}
+
+	id, err := l.Append([]byte("next"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != uint64(n+1) {
+		t.Errorf("expected next id %d, got %d", n+1, id)
+	}
+}
+
+func TestRecoverTornStore(t *testing.T) {
+	dir := t.TempDir()
+	writeTestLog(t, dir, 10)
+
+	path := (&Log{dir: dir}).segmentPath(1)
+	f, err := os.OpenFile(path+storeSuffix, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a length prefix promising more bytes than were written
+	_, err = f.Write([]byte{0, 0, 0, 0, 0, 0, 1, 0, 'x', 'y'})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	l, err := Open(dir, &testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	checkTestLog(t, l, 10)
+}
+
+func TestRecoverLostIndex(t *testing.T) {
+	dir := t.TempDir()
+	writeTestLog(t, dir, 10)
+
+	path := (&Log{dir: dir}).segmentPath(1)
+	err := os.Truncate(path+indexSuffix, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Remove(path + timeIndexSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := Open(dir, &testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	checkTestLog(t, l, 10)
+}
+
+func TestRecoverDeletedIndex(t *testing.T) {
+	dir := t.TempDir()
+	writeTestLog(t, dir, 100)
+
+	l, err := Open(dir, &testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	segments := len(l.segments)
+	sealed := l.segments[1].baseID()
+	active := l.active().baseID()
+	err = l.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if segments < 3 {
+		t.Fatalf("expected at least 3 segments, got %d", segments)
+	}
+
+	// the index of a sealed segment and of the active one are lost
+	for _, base := range []uint64{sealed, active} {
+		path := (&Log{dir: dir}).segmentPath(base)
+		err = os.Remove(path + indexSuffix)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	l, err = Open(dir, &testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	if len(l.segments) != segments {
+		t.Errorf("expected %d segments, got %d", segments, len(l.segments))
+	}
+	err = l.Verify()
+	if err != nil {
+		t.Errorf("expected the rebuilt segment to match its checksum, got %v", err)
+	}
+	checkTestLog(t, l, 100)
+}
+
+func TestRecoverSegment(t *testing.T) {
+	dir := t.TempDir()
+	writeTestLog(t, dir, 10)
+
+	path := (&Log{dir: dir}).segmentPath(1)
+	err := RecoverSegment(path+indexSuffix, path+storeSuffix, 1, &testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := Open(dir, &testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	checkTestLog(t, l, 10)
+}
@@ -0,0 +1,149 @@
+package segmentedlog
+
+import "time"
+
+// EnforceRetention deletes the oldest sealed segments while the log is
+// larger than Retention.MaxBytes or their newest record is older than
+// Retention.MaxAge. it stops at the first segment holding records that a
+// consumer group has not committed yet, the active segment is never
+// deleted.
+func (l *Log) EnforceRetention() error {
+	r := l.cfg.Retention
+	if r.MaxBytes == 0 && r.MaxAge == 0 {
+		return nil
+	}
+
+	l.maintenanceMu.Lock()
+	defer l.maintenanceMu.Unlock()
+
+	consumed, limited := l.offsets.consumedID()
+	deadline := timestamp(time.Now().Add(-r.MaxAge))
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var total uint64
+	for _, rs := range l.remote {
+		total += rs.Size
+	}
+	for _, s := range l.segments {
+		total += s.store.size.Load()
+	}
+
+	// deletable reports whether a segment ending before nextID with the
+	// given newest timestamp falls outside the retention limits
+	deletable := func(nextID, maxTimestamp uint64) bool {
+		if limited && nextID-1 > consumed {
+			return false
+		}
+
+		expired := r.MaxAge > 0 && maxTimestamp < deadline
+		oversized := r.MaxBytes > 0 && total > r.MaxBytes
+		return expired || oversized
+	}
+
+	for len(l.remote) > 0 {
+		rs := l.remote[0]
+		if !deletable(rs.nextID(), rs.MaxTimestamp) {
+			return nil
+		}
+
+		err := l.deleteRemoteSegment(rs)
+		if err != nil {
+			return err
+		}
+		total -= rs.Size
+	}
+
+	for len(l.segments) > 1 {
+		s := l.segments[0]
+		if !deletable(s.nextID(), s.maxTimestamp.Load()) {
+			return nil
+		}
+
+		size := s.store.size.Load()
+		err := l.deleteLocalSegment(s)
+		if err != nil {
+			return err
+		}
+		total -= size
+	}
+
+	return nil
+}
+
+// Truncate deletes every sealed segment, offloaded or local, whose records
+// all come before beforeID. unlike EnforceRetention it does not wait for
+// consumer groups. the active segment is never deleted, so fewer records
+// may go than asked for. it returns the first recordID still stored.
+func (l *Log) Truncate(beforeID uint64) (uint64, error) {
+	l.maintenanceMu.Lock()
+	defer l.maintenanceMu.Unlock()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for len(l.remote) > 0 && l.remote[0].nextID() <= beforeID {
+		err := l.deleteRemoteSegment(l.remote[0])
+		if err != nil {
+			return 0, err
+		}
+	}
+	if len(l.remote) > 0 {
+		return l.remote[0].BaseID, nil
+	}
+
+	for len(l.segments) > 1 && l.segments[0].nextID() <= beforeID {
+		err := l.deleteLocalSegment(l.segments[0])
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return l.segments[0].baseID(), nil
+}
+
+// deleteLocalSegment deletes the oldest local segment, which has to be
+// sealed. the caller must hold l.mu.
+func (l *Log) deleteLocalSegment(s *segmentedlog) error {
+	err := s.remove()
+	if err != nil {
+		return err
+	}
+
+	l.segments = l.segments[1:]
+	delete(l.infos, s.baseID())
+	l.publish()
+	s.retire()
+
+	// a crash before this leaves an entry without files behind, which
+	// Open drops
+	return l.saveManifest()
+}
+
+// deleteRemoteSegment drops the oldest offloaded segment from the manifest
+// and then from the object store. the caller must hold l.mu.
+func (l *Log) deleteRemoteSegment(rs SegmentInfo) error {
+	remote := l.remote
+	l.remote = remote[1:]
+
+	err := l.saveManifest()
+	if err != nil {
+		l.remote = remote
+		return err
+	}
+	l.publish()
+
+	if l.cache != nil {
+		l.cache.forget(rs.BaseID)
+	}
+
+	for _, name := range rs.Objects {
+		err = l.cfg.Tiering.Store.Delete(name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -1,65 +1,539 @@
 package segmentedlog
 
 import (
-	"path/filepath"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+const (
+	indexSuffix     = ".index"
+	storeSuffix     = ".store"
+	timeIndexSuffix = ".timeindex"
+)
+
+// segmentedlog is written by one goroutine at a time and read by any
+// number of others without locking. readers never look past lastID, which
+// the writer moves forward once a whole batch is stored and indexed, so
+// they see either all of a batch or none of it. a reader holds a
+// reference while it uses the segment, the segment is only closed once
+// the log retired it and the last reader released it.
 type segmentedlog struct {
 	index      *Index
 	store      *store
+	timeIndex  *timeIndex
 	SegementID string
+	// lastID is the last recordID readers can see, flushedID the last one
+	// that is synced to disk
+	lastID       atomic.Uint64
+	flushedID    atomic.Uint64
+	maxTimestamp atomic.Uint64
+	// refs counts the readers using the segment plus one for the log
+	refs atomic.Int64
+
+	// next is the recordID the next append gets. with an indexInterval
+	// only the first batch after every indexInterval store bytes is
+	// indexed, sinceIndex counts the bytes stored since the last entry.
+	// 0 indexes every record.
+	next          uint64
+	indexInterval uint64
+	sinceIndex    uint64
+
+	// keys finds the latest record of a key once the segment is sealed,
+	// until then latest maps key hashes to their latest recordID
+	keysMu   sync.RWMutex
+	keys     *keyIndex
+	latest   map[uint64]uint64
+	keysFile string
+}
+
+// timeIndexFile returns the time index file that belongs to indexFile
+func timeIndexFile(indexFile string) string {
+	return strings.TrimSuffix(indexFile, indexSuffix) + timeIndexSuffix
 }
 
+// NewSegement opens a segment, if its index does not match its store the
+// index is rebuilt from the store first
 func NewSegement(indexFile string, storeFile string, startID uint64, cfg *Config) (*segmentedlog, error) {
-	index, err := newIndex(indexFile, cfg, startID)
+	return loadSegment(indexFile, storeFile, startID, cfg, false)
+}
+
+// loadSegment opens a segment and recovers it when needed. a sealed
+// segment that has to be recovered is opened for writing first and sealed
+// again once its indexes are rebuilt.
+func loadSegment(indexFile string, storeFile string, startID uint64, cfg *Config, sealed bool) (*segmentedlog, error) {
+	s, err := openSegmentFiles(indexFile, storeFile, startID, cfg, sealed)
 	if err != nil {
 		return nil, err
 	}
-	store, err := newStore(storeFile, cfg)
+
+	if s.consistent() {
+		s.publishTail()
+		if sealed {
+			err = s.loadKeys()
+		} else {
+			err = s.scanKeys()
+		}
+		if err != nil {
+			s.close()
+			return nil, err
+		}
+		return s, nil
+	}
+
+	if sealed {
+		err = s.close()
+		if err != nil {
+			return nil, err
+		}
+
+		s, err = openSegmentFiles(indexFile, storeFile, startID, cfg, false)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = s.recover()
+	if err == nil && sealed {
+		err = s.seal()
+	}
 	if err != nil {
+		s.close()
 		return nil, err
 	}
 
-	sp := strings.Split(filepath.Base(indexFile), "*")
-
-	return &segmentedlog{
-		index:      index,
-		store:      store,
-		SegementID: sp[0],
-	}, nil
+	return s, nil
 }
 
-func (s *segmentedlog) read(id uint64) ([]byte, error) {
-	offset, err := s.index.read(id)
+func openSegmentFiles(indexFile string, storeFile string, startID uint64, cfg *Config, sealed bool) (*segmentedlog, error) {
+	index, err := openIndex(indexFile, cfg, startID, sealed)
+	if err != nil {
+		return nil, err
+	}
+	store, err := newStore(storeFile, cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	data, err := s.store.read(offset)
+	timeIndex, err := openTimeIndex(timeIndexFile(indexFile), cfg, sealed)
 	if err != nil {
 		return nil, err
 	}
 
-	return data, nil
+	s := &segmentedlog{
+		index:         index,
+		store:         store,
+		timeIndex:     timeIndex,
+		SegementID:    fmt.Sprintf("%020d", startID),
+		next:          startID,
+		indexInterval: cfg.Segment.IndexIntervalBytes,
+		keysFile:      keyIndexFile(indexFile),
+	}
+	s.refs.Store(1)
+
+	return s, nil
+}
+
+// publishTail makes the records found when the segment was opened
+// visible to readers
+func (s *segmentedlog) publishTail() {
+	s.lastID.Store(s.next - 1)
+	s.flushedID.Store(s.next - 1)
 }
 
+// acquire takes a reference to the segment for a reader. it fails once
+// the segment is retired and its last reader is gone.
+func (s *segmentedlog) acquire() bool {
+	for {
+		n := s.refs.Load()
+		if n == 0 {
+			return false
+		}
+		if s.refs.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
+// release drops a reference, the last one closes the segment
+func (s *segmentedlog) release() {
+	if s.refs.Add(-1) != 0 {
+		return
+	}
+
+	err := s.close()
+	if err != nil {
+		log.Printf("segmentedlog: closing segment %s failed: %v", s.SegementID, err)
+	}
+}
+
+// retire drops the reference of the log once the segment is no longer part
+// of it, readers still using it keep it open until they release it
+func (s *segmentedlog) retire() {
+	s.release()
+}
+
+// read returns the record stored under id, the batch holding it is
+// verified against its checksum
+func (s *segmentedlog) read(id uint64) (Record, error) {
+	if id > s.lastID.Load() {
+		return Record{}, ErrRecordNotFound
+	}
+
+	b, _, err := s.batchFrom(id)
+	if err != nil {
+		return Record{}, err
+	}
+
+	for _, r := range b.records {
+		if r.ID == id {
+			return r, nil
+		}
+	}
+
+	return Record{}, ErrRecordNotFound
+}
+
+// write appends a single record holding data
 func (s *segmentedlog) write(data []byte) (uint64, error) {
-	offset, err := s.store.write(data)
+	return s.appendBatch([]Record{{Value: data}})
+}
+
+// appendBatch writes records as one batch with a single store write and
+// returns the ID of the first record. IDs are assigned in order and a zero
+// timestamp is replaced by the append time.
+func (s *segmentedlog) appendBatch(records []Record) (uint64, error) {
+	if len(records) == 0 {
+		return 0, ErrEmptyBatch
+	}
+
+	// a sparse index adds at most one entry per batch
+	entries := 1
+	if s.indexInterval == 0 {
+		entries = len(records)
+	}
+	if !s.index.hasRoom(entries) {
+		return 0, ErrMaxIndexSize
+	}
+
+	now := time.Now()
+	b := &batch{
+		baseID:  s.nextID(),
+		records: make([]Record, len(records)),
+	}
+	for i, r := range records {
+		r.ID = b.baseID + uint64(i)
+		if r.Timestamp.IsZero() {
+			r.Timestamp = now
+		}
+		if r.Timestamp.Before(time.Unix(0, 0)) {
+			return 0, ErrInvalidTimestamp
+		}
+
+		ts := timestamp(r.Timestamp)
+		if i == 0 || ts < b.baseTimestamp {
+			b.baseTimestamp = ts
+		}
+		if ts > b.maxTimestamp {
+			b.maxTimestamp = ts
+		}
+		b.records[i] = r
+	}
+
+	err := s.writeBatch(b)
 	if err != nil {
 		return 0, err
 	}
 
-	id, err := s.index.Write(offset)
+	s.lastID.Store(s.nextID() - 1)
+	if s.store.buf == nil {
+		s.flushedID.Store(s.nextID() - 1)
+	} else if s.store.needsFlush() {
+		err = s.flush()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return b.baseID, nil
+}
+
+// flush syncs the store and both indexes and moves flushedID up to the
+// last record written
+func (s *segmentedlog) flush() error {
+	err := s.store.flush()
+	if err != nil {
+		return err
+	}
+
+	err = s.index.flush()
+	if err != nil {
+		return err
+	}
+
+	err = s.timeIndex.flush()
+	if err != nil {
+		return err
+	}
+
+	s.flushedID.Store(s.nextID() - 1)
+	return nil
+}
+
+// seal flushes the segment, shrinks both indexes to their used length and
+// writes the key index. a sealed segment takes no more appends
+func (s *segmentedlog) seal() error {
+	err := s.flush()
+	if err != nil {
+		return err
+	}
+
+	err = s.index.seal()
+	if err != nil {
+		return err
+	}
+
+	err = s.timeIndex.seal()
+	if err != nil {
+		return err
+	}
+
+	return s.sealKeys()
+}
+
+// writeBatch stores b and indexes it under the IDs the records already
+// carry
+func (s *segmentedlog) writeBatch(b *batch) error {
+	data := encodeBatch(b)
+	offset, err := s.store.write(data)
+	if err != nil {
+		return err
+	}
+
+	return s.indexBatch(b, offset, 8+uint64(len(data)))
+}
+
+// indexBatch adds the entries for the batch of size bytes stored at
+// offset. a dense index gets an entry for every record and the time index
+// one for every record that moves the timestamp forward. a sparse index
+// only indexes the batch when indexInterval bytes were stored since the
+// last entry, the time index then gets the newest timestamp stored before
+// the batch, so every record before an entry is older than its timestamp.
+func (s *segmentedlog) indexBatch(b *batch, offset uint64, size uint64) error {
+	if s.indexInterval == 0 {
+		for _, r := range b.records {
+			err := s.index.writeID(r.ID, offset)
+			if err != nil {
+				return err
+			}
+
+			// a record older than one before it, from the caller or a
+			// wall clock stepping back, is indexed at the newest
+			// timestamp so far and keeps its own
+			ts := max(timestamp(r.Timestamp), s.timeIndex.lastTimestamp.Load())
+			err = s.timeIndex.write(ts, r.ID)
+			if err != nil {
+				return err
+			}
+		}
+	} else if s.index.entries() == 0 || s.sinceIndex >= s.indexInterval {
+		base := b.records[0].ID
+		err := s.index.writeID(base, offset)
+		if err != nil {
+			return err
+		}
+
+		err = s.timeIndex.write(s.maxTimestamp.Load(), base)
+		if err != nil {
+			return err
+		}
+
+		s.sinceIndex = 0
+	}
+
+	s.addKeys(b)
+	s.sinceIndex += size
+	s.next = b.records[len(b.records)-1].ID + 1
+	if b.maxTimestamp > s.maxTimestamp.Load() {
+		s.maxTimestamp.Store(b.maxTimestamp)
+	}
+
+	return nil
+}
+
+// seek returns the offset of the last batch starting at or before id, or
+// of the first batch when there is none. with a sparse index the batches
+// after the closest entry are walked.
+func (s *segmentedlog) seek(id uint64) (uint64, error) {
+	var offset uint64
+	if e := s.index.search(id+1) - 1; e >= 0 {
+		_, offset = s.index.entry(e)
+	}
+	if s.indexInterval == 0 {
+		return offset, nil
+	}
+
+	size := s.store.size.Load()
+	for offset < size {
+		n, _, err := s.batchAt(offset)
+		if err != nil {
+			return 0, err
+		}
+		if offset+n >= size {
+			break
+		}
+
+		_, next, err := s.batchAt(offset + n)
+		if err != nil {
+			return 0, err
+		}
+		if next > id {
+			break
+		}
+
+		offset += n
+	}
+
+	return offset, nil
+}
+
+// batchFrom returns the first batch holding a record at or after id and
+// its offset in the store
+func (s *segmentedlog) batchFrom(id uint64) (*batch, uint64, error) {
+	offset, err := s.seek(id)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for offset < s.store.size.Load() {
+		data, err := s.store.read(offset)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		b, err := decodeBatch(data)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(b.records) > 0 && b.records[len(b.records)-1].ID >= id {
+			return b, offset, nil
+		}
+
+		offset += 8 + uint64(len(data))
+	}
+
+	return nil, 0, ErrRecordNotFound
+}
+
+// batchAt returns the size in the store and the first recordID of the
+// batch written at offset without reading the whole batch
+func (s *segmentedlog) batchAt(offset uint64) (uint64, uint64, error) {
+	b, err := s.store.peek(offset, 16)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return 8 + binary.BigEndian.Uint64(b[0:8]), binary.BigEndian.Uint64(b[8:16]), nil
+}
+
+// scan calls fn for every batch in the store in append order
+func (s *segmentedlog) scan(fn func(b *batch) error) error {
+	for offset := uint64(0); offset < s.store.size.Load(); {
+		data, err := s.store.read(offset)
+		if err != nil {
+			return err
+		}
+
+		b, err := decodeBatch(data)
+		if err != nil {
+			return err
+		}
+
+		err = fn(b)
+		if err != nil {
+			return err
+		}
+
+		offset += 8 + uint64(len(data))
+	}
+
+	return nil
+}
+
+// readFromTime returns the first recordID appended at or after t
+func (s *segmentedlog) readFromTime(t time.Time) (uint64, error) {
+	ts := timestamp(t)
+	if s.indexInterval == 0 {
+		id, ok := s.timeIndex.lookup(ts)
+		if !ok || id > s.lastID.Load() {
+			return 0, ErrRecordNotFound
+		}
+
+		return id, nil
+	}
+
+	// every record before the last entry older than ts is older as well,
+	// the record is found by walking the batches from there
+	last := s.lastID.Load()
+	from := s.baseID()
+	if id, ok := s.timeIndex.before(ts); ok {
+		from = id
+	}
+
+	offset, err := s.seek(from)
 	if err != nil {
 		return 0, err
 	}
 
-	return id, nil
+	for offset < s.store.size.Load() {
+		data, err := s.store.read(offset)
+		if err != nil {
+			return 0, err
+		}
+
+		b, err := decodeBatch(data)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, r := range b.records {
+			if r.ID > last {
+				return 0, ErrRecordNotFound
+			}
+			if r.ID >= from && timestamp(r.Timestamp) >= ts {
+				return r.ID, nil
+			}
+		}
+
+		offset += 8 + uint64(len(data))
+	}
+
+	return 0, ErrRecordNotFound
+}
+
+// baseID returns the first recordID stored in the segment
+func (s *segmentedlog) baseID() uint64 {
+	return s.index.startID
+}
+
+// nextID returns the recordID the next write will get
+func (s *segmentedlog) nextID() uint64 {
+	return s.next
 }
 
 func (s *segmentedlog) close() error {
-	err := s.index.close()
+	err := s.flush()
+	if err != nil {
+		return err
+	}
+
+	err = s.index.close()
 	if err != nil {
 		return err
 	}
@@ -69,7 +543,14 @@ func (s *segmentedlog) close() error {
 		return err
 	}
 
-	return nil
+	if s.keys != nil {
+		err = s.keys.close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.timeIndex.close()
 }
 
 func (s *segmentedlog) remove() error {
@@ -78,5 +559,15 @@ func (s *segmentedlog) remove() error {
 		return err
 	}
 
-	return s.store.remove()
+	err = s.store.remove()
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(s.keysFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return s.timeIndex.remove()
 }
@@ -21,7 +21,7 @@ func TestSegmentReadWrite(t *testing.T) {
 	}
 	defer os.Remove(storeFile.Name())
 
-	segment, err := NewSegement(idxFile.Name(), storeFile.Name(), 1, &defaultConfig)
+	segment, err := NewSegement(idxFile.Name(), storeFile.Name(), 1, &testConfig)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -44,11 +44,11 @@ func TestSegmentReadWrite(t *testing.T) {
 	}
 
 	for i, id := range ids {
-		data, err := segment.read(id)
+		r, err := segment.read(id)
 		if err != nil {
 			t.Error(err)
 		}
-		if string(data) != messages[i] {
+		if string(r.Value) != messages[i] {
 			t.Error("data is not the same")
 		}
 	}
@@ -60,7 +60,7 @@ func TestRemoveSegment(t *testing.T) {
 	_ = i.Close()
 	_ = s.Close()
 
-	seg, _ := NewSegement(i.Name(), s.Name(), 1, &defaultConfig)
+	seg, _ := NewSegement(i.Name(), s.Name(), 1, &testConfig)
 	err := seg.remove()
 	if err != nil {
 		t.Error(err)
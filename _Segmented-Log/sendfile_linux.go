@@ -0,0 +1,65 @@
+//go:build linux
+
+package segmentedlog
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+// sendFile copies n bytes of f starting at offset to conn using sendfile,
+// so the data never passes through user space
+func sendFile(conn net.Conn, f *os.File, offset, n int64) (int64, error) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return copyFile(conn, f, offset, n)
+	}
+
+	dst, err := sc.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	src, err := f.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	var written int64
+	var werr error
+	err = src.Control(func(srcFd uintptr) {
+		err := dst.Write(func(dstFd uintptr) bool {
+			for written < n {
+				off := offset + written
+				m, err := syscall.Sendfile(int(dstFd), int(srcFd), &off, int(n-written))
+				if m > 0 {
+					written += int64(m)
+				}
+
+				switch {
+				case err == syscall.EAGAIN:
+					// socket buffer is full, wait until it is writable again
+					return false
+				case err == syscall.EINTR:
+					continue
+				case err != nil:
+					werr = os.NewSyscallError("sendfile", err)
+					return true
+				case m == 0:
+					werr = syscall.EIO
+					return true
+				}
+			}
+			return true
+		})
+		if werr == nil {
+			werr = err
+		}
+	})
+	if err != nil {
+		return written, err
+	}
+
+	return written, werr
+}
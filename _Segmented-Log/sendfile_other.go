@@ -0,0 +1,13 @@
+//go:build !linux
+
+package segmentedlog
+
+import (
+	"net"
+	"os"
+)
+
+// sendFile copies n bytes of f starting at offset to conn
+func sendFile(conn net.Conn, f *os.File, offset, n int64) (int64, error) {
+	return copyFile(conn, f, offset, n)
+}
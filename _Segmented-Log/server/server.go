@@ -0,0 +1,295 @@
+// Package server serves a segmented log over TCP. every connection carries
+// length prefixed logpb frames, requests are handled concurrently so a
+// client can pipeline them and a long polling Fetch does not hold up the
+// requests behind it.
+package server
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	segmentedlog "github.com/EncrypteID/Replicate-Partition-Next-Generation-Systems/_Segmented-Log"
+	"github.com/EncrypteID/Replicate-Partition-Next-Generation-Systems/_Segmented-Log/logpb"
+)
+
+const (
+	// maxInflight bounds the requests of one connection handled at once,
+	// the connection is not read any further while it is reached
+	maxInflight = 64
+	// maxWait caps how long a Fetch may wait for new records
+	maxWait = 30 * time.Second
+	// defaultFetchBytes is used when a Fetch does not set MaxBytes
+	defaultFetchBytes = 1 << 20
+)
+
+var ErrServerClosed = errors.New("server closed")
+
+type Server struct {
+	log *segmentedlog.Log
+
+	mu       sync.Mutex
+	appended chan struct{}
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// New returns a server for l, l is not closed with the server
+func New(l *segmentedlog.Log) *Server {
+	return &Server{
+		log:      l,
+		appended: make(chan struct{}),
+		conns:    map[net.Conn]struct{}{},
+		done:     make(chan struct{}),
+	}
+}
+
+// Serve accepts connections on ln until Close is called, it always
+// returns a non nil error
+func (s *Server) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		ln.Close()
+		return ErrServerClosed
+	}
+	s.listener = ln
+	s.mu.Unlock()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			select {
+			case <-s.done:
+				return ErrServerClosed
+			default:
+				return err
+			}
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return ErrServerClosed
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go s.serveConn(conn)
+	}
+}
+
+// Close stops accepting connections, closes the open ones and waits for
+// their requests to finish
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+// serveConn reads the requests of one connection and answers each from its
+// own goroutine, responses are written in the order they are ready
+func (s *Server) serveConn(conn net.Conn) {
+	defer s.wg.Done()
+
+	var writeMu sync.Mutex
+	var requests sync.WaitGroup
+	inflight := make(chan struct{}, maxInflight)
+	closed := make(chan struct{})
+
+	defer func() {
+		close(closed)
+		conn.Close()
+		requests.Wait()
+
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	}()
+
+	for {
+		req := &logpb.Request{}
+		err := logpb.ReadFrame(conn, req)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				log.Printf("logserver: reading from %s failed: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+
+		inflight <- struct{}{}
+		requests.Add(1)
+		go func() {
+			defer requests.Done()
+			defer func() { <-inflight }()
+
+			resp := s.handle(req, closed)
+			resp.Id = req.Id
+
+			writeMu.Lock()
+			err := logpb.WriteFrame(conn, resp)
+			writeMu.Unlock()
+			if err != nil {
+				conn.Close()
+			}
+		}()
+	}
+}
+
+// handle answers a single request, closed is closed once the connection
+// the request came on is gone
+func (s *Server) handle(req *logpb.Request, closed <-chan struct{}) *logpb.Response {
+	resp := &logpb.Response{}
+
+	var err error
+	switch {
+	case req.Produce != nil:
+		resp.Produce, err = s.produce(req.Produce)
+	case req.Fetch != nil:
+		resp.Fetch, err = s.fetch(req.Fetch, closed)
+	case req.ListSegments != nil:
+		resp.ListSegments = s.listSegments()
+	case req.Truncate != nil:
+		resp.Truncate, err = s.truncate(req.Truncate)
+	default:
+		err = errors.New("empty request")
+	}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+
+	return resp
+}
+
+// produce appends the records as one batch and answers once they are on
+// disk, so an acknowledged record survives a crash of the server
+func (s *Server) produce(req *logpb.ProduceRequest) (*logpb.ProduceResponse, error) {
+	records := make([]segmentedlog.Record, len(req.Records))
+	for i, r := range req.Records {
+		records[i] = r.ToRecord()
+	}
+
+	base, err := s.log.AppendBatch(records)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.log.FlushedID() < base+uint64(len(records))-1 {
+		err = s.log.Flush()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	s.mu.Lock()
+	close(s.appended)
+	s.appended = make(chan struct{})
+	s.mu.Unlock()
+
+	return &logpb.ProduceResponse{BaseId: base}, nil
+}
+
+// fetch returns the records from FromId on, waiting up to MaxWaitMs for
+// the first one to be produced
+func (s *Server) fetch(req *logpb.FetchRequest, closed <-chan struct{}) (*logpb.FetchResponse, error) {
+	wait := time.Duration(req.MaxWaitMs) * time.Millisecond
+	if wait > maxWait {
+		wait = maxWait
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		// take the channel before reading so an append in between is not
+		// missed
+		s.mu.Lock()
+		appended := s.appended
+		s.mu.Unlock()
+
+		resp, err := s.read(req)
+		if err != nil || len(resp.Records) > 0 || wait <= 0 {
+			return resp, err
+		}
+
+		select {
+		case <-appended:
+		case <-timer.C:
+			return resp, nil
+		case <-closed:
+			return resp, nil
+		case <-s.done:
+			return resp, nil
+		}
+	}
+}
+
+// read collects whole records from FromId on until MaxBytes of keys and
+// values are reached, the first record is always included
+func (s *Server) read(req *logpb.FetchRequest) (*logpb.FetchResponse, error) {
+	maxBytes := req.MaxBytes
+	if maxBytes == 0 {
+		maxBytes = defaultFetchBytes
+	}
+
+	resp := &logpb.FetchResponse{LastId: s.log.FlushedID()}
+
+	var total uint64
+	it := s.log.Iterator(req.FromId)
+	for it.Next() {
+		r := it.Record()
+		size := uint64(len(r.Key) + len(r.Value))
+		if len(resp.Records) > 0 && total+size > maxBytes {
+			break
+		}
+
+		resp.Records = append(resp.Records, logpb.FromRecord(r))
+		total += size
+	}
+	if it.Err() != nil {
+		return nil, it.Err()
+	}
+
+	return resp, nil
+}
+
+func (s *Server) listSegments() *logpb.ListSegmentsResponse {
+	resp := &logpb.ListSegmentsResponse{}
+	for _, info := range s.log.Segments() {
+		resp.Segments = append(resp.Segments, logpb.FromSegmentInfo(info))
+	}
+
+	return resp
+}
+
+func (s *Server) truncate(req *logpb.TruncateRequest) (*logpb.TruncateResponse, error) {
+	first, err := s.log.Truncate(req.BeforeId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logpb.TruncateResponse{FirstId: first}, nil
+}
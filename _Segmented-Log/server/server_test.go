@@ -0,0 +1,73 @@
+package server
+
+import (
+	"net"
+	"testing"
+
+	segmentedlog "github.com/EncrypteID/Replicate-Partition-Next-Generation-Systems/_Segmented-Log"
+	"github.com/EncrypteID/Replicate-Partition-Next-Generation-Systems/_Segmented-Log/logpb"
+)
+
+func startServer(t *testing.T) string {
+	t.Helper()
+
+	l, err := segmentedlog.Open(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := New(l)
+	go srv.Serve(ln)
+	t.Cleanup(func() {
+		srv.Close()
+		l.Close()
+	})
+
+	return ln.Addr().String()
+}
+
+func TestPipelinedRequests(t *testing.T) {
+	conn, err := net.Dial("tcp", startServer(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// the fetch waits for the produce sent after it on the same connection
+	requests := []*logpb.Request{
+		{Id: 1, Fetch: &logpb.FetchRequest{FromId: 1, MaxWaitMs: 5000}},
+		{Id: 2, Produce: &logpb.ProduceRequest{Records: []*logpb.Record{{Value: []byte("hello")}}}},
+		{Id: 3},
+	}
+	for _, req := range requests {
+		err = logpb.WriteFrame(conn, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	responses := map[uint64]*logpb.Response{}
+	for range requests {
+		resp := &logpb.Response{}
+		err = logpb.ReadFrame(conn, resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		responses[resp.Id] = resp
+	}
+
+	if resp := responses[2]; resp.Error != "" || resp.Produce.BaseId != 1 {
+		t.Errorf("expected the produce to get id 1, got %v", resp)
+	}
+	if resp := responses[1]; resp.Error != "" || len(resp.Fetch.Records) != 1 || string(resp.Fetch.Records[0].Value) != "hello" {
+		t.Errorf("expected the fetch to return the produced record, got %v", resp)
+	}
+	if resp := responses[3]; resp.Error == "" {
+		t.Errorf("expected an empty request to fail, got %v", resp)
+	}
+}
@@ -1,23 +1,41 @@
 package segmentedlog
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"os"
+	"sync"
+	"sync/atomic"
 )
 
 var (
 	errNoStoreSpaceLeft = errors.New("no store space left")
 	errNoIndexSpaceLeft = errors.New("no index space left")
+	errTruncatedRecord  = errors.New("record runs past the end of the store")
 )
 
 // store defines a storage abstraction for the log
 // log is append only file
+//
+// when Flush.MaxBufferedBytes is set appends are collected in memory and
+// only written and synced once the buffer is full or flush is called,
+// otherwise every append is synced before write returns. size counts the
+// buffered bytes as well, written only the ones handed to the file.
+//
+// only one goroutine writes to a store, any number may read from it. size
+// is published after the bytes it covers, so a reader never reads past
+// it. mu guards the buffer, readers take it when the bytes they need are
+// still buffered.
 type store struct {
+	mu      sync.Mutex
 	file    *os.File
-	size    uint64
+	buf     *bufio.Writer
+	size    atomic.Uint64
+	written uint64
+	synced  uint64
 	maxSize uint64
 }
 
@@ -33,18 +51,35 @@ func newStore(file string, cfg *Config) (*store, error) {
 		return nil, err
 	}
 
-	return &store{
+	s := &store{
 		file:    f,
-		size:    uint64(st.Size()),
+		written: uint64(st.Size()),
+		synced:  uint64(st.Size()),
 		maxSize: cfg.Segment.MaxStoreSizeBytes,
-	}, nil
+	}
+	s.size.Store(uint64(st.Size()))
+	if cfg.Flush.MaxBufferedBytes > 0 {
+		s.buf = bufio.NewWriterSize(f, int(cfg.Flush.MaxBufferedBytes))
+	}
+
+	return s, nil
 }
 
 // read takes an offset in a file and returns a record
 func (s *store) read(offset uint64) ([]byte, error) {
+	end := s.size.Load()
+	if offset+8 > end {
+		return nil, errTruncatedRecord
+	}
+
+	err := s.ensureWritten(offset + 8)
+	if err != nil {
+		return nil, err
+	}
+
 	// read the first 8 bytes to determine the size of the record
 	b := make([]byte, 8)
-	_, err := s.file.ReadAt(b, int64(offset))
+	_, err = s.file.ReadAt(b, int64(offset))
 	if err != nil {
 		return nil, err
 	}
@@ -55,6 +90,16 @@ func (s *store) read(offset uint64) ([]byte, error) {
 		return nil, err
 	}
 
+	// a torn or corrupted length must not turn into a huge allocation
+	if size > end-offset-8 {
+		return nil, errTruncatedRecord
+	}
+
+	err = s.ensureWritten(offset + 8 + size)
+	if err != nil {
+		return nil, err
+	}
+
 	b = make([]byte, size)
 	_, err = s.file.ReadAt(b, int64(offset)+8)
 	if err != nil {
@@ -64,9 +109,27 @@ func (s *store) read(offset uint64) ([]byte, error) {
 	return b, nil
 }
 
+// peek returns the first n bytes stored at offset, including the length
+// prefix of the record
+func (s *store) peek(offset uint64, n int) ([]byte, error) {
+	err := s.ensureWritten(offset + uint64(n))
+	if err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, n)
+	_, err = s.file.ReadAt(b, int64(offset))
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
 // write append the record to the log and return
 func (s *store) write(data []byte) (uint64, error) {
-	if s.size+uint64(len(data)+8) > s.maxSize {
+	offset := s.size.Load()
+	if offset+uint64(len(data)+8) > s.maxSize {
 		return 0, errNoStoreSpaceLeft
 	}
 
@@ -74,6 +137,21 @@ func (s *store) write(data []byte) (uint64, error) {
 	binary.BigEndian.PutUint64(b[0:8], uint64(len(data)))
 	copy(b[8:], data)
 
+	if s.buf != nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		_, err := s.buf.Write(b)
+		if err != nil {
+			return 0, err
+		}
+
+		s.written = offset + uint64(len(b)) - uint64(s.buf.Buffered())
+		s.size.Store(offset + uint64(len(b)))
+
+		return offset, nil
+	}
+
 	n, err := s.file.Write(b)
 	if err != nil {
 		return 0, err
@@ -88,13 +166,86 @@ func (s *store) write(data []byte) (uint64, error) {
 		return 0, err
 	}
 
-	offset := s.size
-	s.size += uint64(n)
+	s.written = offset + uint64(n)
+	s.synced = s.written
+	s.size.Store(s.written)
 
 	return offset, nil
 }
 
+// needsFlush reports whether the bytes appended since the last sync have
+// filled the buffer
+func (s *store) needsFlush() bool {
+	return s.buf != nil && s.size.Load()-s.synced >= uint64(s.buf.Size())
+}
+
+// ensureWritten hands buffered bytes to the file if a read needs the data
+// up to end, the data is not synced
+func (s *store) ensureWritten(end uint64) error {
+	if s.buf == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if end <= s.written {
+		return nil
+	}
+
+	err := s.buf.Flush()
+	if err != nil {
+		return err
+	}
+
+	s.written = s.size.Load()
+	return nil
+}
+
+// flush writes the buffered bytes and syncs the file
+func (s *store) flush() error {
+	size := s.size.Load()
+	if s.buf != nil {
+		s.mu.Lock()
+		err := s.buf.Flush()
+		if err == nil {
+			s.written = size
+		}
+		s.mu.Unlock()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	err := s.file.Sync()
+	if err != nil {
+		return err
+	}
+
+	s.synced = size
+	return nil
+}
+
+// truncate drops everything stored at or after offset
+func (s *store) truncate(offset uint64) error {
+	err := s.file.Truncate(int64(offset))
+	if err != nil {
+		return err
+	}
+
+	s.size.Store(offset)
+	s.written = offset
+	s.synced = offset
+	return s.file.Sync()
+}
+
 func (s *store) close() error {
+	err := s.flush()
+	if err != nil {
+		return err
+	}
+
 	return s.file.Close()
 }
 
@@ -0,0 +1,514 @@
+package segmentedlog
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// cacheDir holds the offloaded segments fetched back for reads, keysDir
+// inside it their key indexes
+const (
+	cacheDir = "cache"
+	keysDir  = "keys"
+)
+
+// ObjectStore keeps offloaded segment files outside of the log directory.
+// names are flat, Put replaces an existing object
+type ObjectStore interface {
+	Put(name string, r io.Reader) error
+	Get(name string) (io.ReadCloser, error)
+	Delete(name string) error
+}
+
+// DirStore is an ObjectStore keeping every object as a file in one
+// directory, it is meant for tests and for on-prem deployments that mount
+// their cold storage
+type DirStore struct {
+	dir string
+}
+
+// NewDirStore returns a DirStore rooted at dir, creating it if needed
+func NewDirStore(dir string) (*DirStore, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DirStore{dir: dir}, nil
+}
+
+// Put writes the object to a temporary file first so a failed upload never
+// leaves a partial object behind
+func (d *DirStore) Put(name string, r io.Reader) error {
+	f, err := os.CreateTemp(d.dir, name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = io.Copy(f, r)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), filepath.Join(d.dir, name))
+}
+
+func (d *DirStore) Get(name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(d.dir, name))
+}
+
+func (d *DirStore) Delete(name string) error {
+	return os.Remove(filepath.Join(d.dir, name))
+}
+
+// writeFileAtomic replaces path with data through a synced temporary file
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// Offload uploads the oldest sealed segments to Tiering.Store until only
+// Tiering.KeepLocalSegments sealed segments are left on disk. the local
+// files are deleted once the upload is recorded in the manifest.
+func (l *Log) Offload() error {
+	if l.cfg.Tiering.Store == nil {
+		return errors.New("no object store configured")
+	}
+
+	l.maintenanceMu.Lock()
+	defer l.maintenanceMu.Unlock()
+
+	for {
+		v := l.view.Load()
+		sealed := len(v.segments) - 1
+		s := v.segments[0]
+
+		if sealed <= l.cfg.Tiering.KeepLocalSegments {
+			return nil
+		}
+
+		err := l.offloadSegment(s)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// offloadSegment uploads a sealed segment and swaps it out of the log
+func (l *Log) offloadSegment(s *segmentedlog) error {
+	base := s.baseID()
+
+	l.mu.Lock()
+	info := l.infos[base]
+	l.mu.Unlock()
+
+	info.Objects = map[string]string{}
+	path := l.segmentPath(base)
+	for _, suffix := range segmentSuffixes {
+		name := l.cfg.Tiering.Prefix + filepath.Base(path+suffix)
+		f, err := os.Open(path + suffix)
+		if err != nil {
+			return err
+		}
+
+		err = l.cfg.Tiering.Store.Put(name, f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("uploading %s: %w", name, err)
+		}
+
+		info.Objects[suffix] = name
+	}
+	info.State = SegmentOffloaded
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	remote, segments, sealed := l.remote, l.segments, l.infos[base]
+	l.remote = append(append([]SegmentInfo(nil), remote...), info)
+	l.segments = segments[1:]
+	delete(l.infos, base)
+
+	err := l.saveManifest()
+	if err != nil {
+		l.remote, l.segments, l.infos[base] = remote, segments, sealed
+		return err
+	}
+	l.publish()
+
+	// readers still using the local copy keep their open files
+	err = s.remove()
+	if err != nil {
+		return err
+	}
+
+	s.retire()
+	return nil
+}
+
+// segmentCache holds offloaded segments fetched back from the object
+// store. segments are reference counted so one that is evicted while a
+// reader still uses it is only closed once that reader is done.
+type segmentCache struct {
+	mu      sync.Mutex
+	dir     string
+	cfg     *Config
+	max     int
+	tick    uint64
+	entries map[uint64]*cachedSegment
+	// keyIndexes holds the key indexes of offloaded segments, they are
+	// fetched on their own so LatestByKey can skip a segment without
+	// downloading it
+	keyIndexes map[uint64]*keyIndex
+}
+
+type cachedSegment struct {
+	base    uint64
+	path    string
+	seg     *segmentedlog
+	refs    int
+	used    uint64
+	evicted bool
+	// loaded is closed once the download finished, seg or err are set
+	// then
+	loaded chan struct{}
+	err    error
+}
+
+func newSegmentCache(dir string, cfg *Config) (*segmentCache, error) {
+	// cached files are only copies, start from an empty cache
+	err := os.RemoveAll(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(filepath.Join(dir, keysDir), 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	max := cfg.Tiering.CacheSegments
+	if max <= 0 {
+		max = 1
+	}
+
+	return &segmentCache{
+		dir:        dir,
+		cfg:        cfg,
+		max:        max,
+		entries:    map[uint64]*cachedSegment{},
+		keyIndexes: map[uint64]*keyIndex{},
+	}, nil
+}
+
+// get returns the segment described by rs, downloading it if it is not
+// cached. the download runs without c.mu, so it only holds up the readers
+// of the same segment. release has to be called once the segment is no
+// longer used.
+func (c *segmentCache) get(rs SegmentInfo) (*segmentedlog, func(), error) {
+	c.mu.Lock()
+	c.tick++
+	e, cached := c.entries[rs.BaseID]
+	if !cached {
+		// an evicted copy of the segment may still be read and is
+		// removed once its readers are done, each download gets its own
+		// files
+		e = &cachedSegment{
+			base:   rs.BaseID,
+			path:   filepath.Join(c.dir, fmt.Sprintf("%020d-%d", rs.BaseID, c.tick)),
+			loaded: make(chan struct{}),
+		}
+		c.entries[rs.BaseID] = e
+	}
+	e.used = c.tick
+	e.refs++
+	c.mu.Unlock()
+
+	if cached {
+		<-e.loaded
+	} else {
+		e.seg, e.err = c.fetch(rs, e.path)
+		close(e.loaded)
+	}
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+
+			e.refs--
+			if e.evicted && e.refs == 0 {
+				c.drop(e)
+			}
+		})
+	}
+
+	c.mu.Lock()
+	if e.err != nil && c.entries[rs.BaseID] == e {
+		// the next reader tries the download again
+		delete(c.entries, rs.BaseID)
+	}
+	c.evict()
+	c.mu.Unlock()
+
+	if e.err != nil {
+		release()
+		return nil, nil, e.err
+	}
+	return e.seg, release, nil
+}
+
+// fetch downloads the segment rs to path and opens it, a failed download
+// leaves no partial files behind
+func (c *segmentCache) fetch(rs SegmentInfo, path string) (*segmentedlog, error) {
+	seg, err := c.download(rs, path)
+	if err != nil {
+		for _, suffix := range segmentSuffixes {
+			_ = os.Remove(path + suffix)
+		}
+	}
+	return seg, err
+}
+
+func (c *segmentCache) download(rs SegmentInfo, path string) (*segmentedlog, error) {
+	for _, suffix := range segmentSuffixes {
+		if _, ok := rs.Objects[suffix]; !ok && suffix == keyIndexSuffix {
+			// offloaded before segments had key indexes, it is built
+			// again when the segment is opened
+			continue
+		}
+
+		r, err := c.cfg.Tiering.Store.Get(rs.Objects[suffix])
+		if err != nil {
+			return nil, fmt.Errorf("fetching %s: %w", rs.Objects[suffix], err)
+		}
+
+		f, err := os.Create(path + suffix)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+
+		_, err = io.Copy(f, r)
+		r.Close()
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return openSealedSegment(path, rs.BaseID, c.cfg)
+}
+
+// keys returns the key index of the offloaded segment rs, downloading it
+// the first time. the download runs without c.mu like the ones of get. it
+// is nil for segments offloaded without one.
+func (c *segmentCache) keys(rs SegmentInfo) (*keyIndex, error) {
+	name, ok := rs.Objects[keyIndexSuffix]
+	if !ok {
+		return nil, nil
+	}
+
+	c.mu.Lock()
+	k, ok := c.keyIndexes[rs.BaseID]
+	c.tick++
+	tick := c.tick
+	c.mu.Unlock()
+	if ok {
+		return k, nil
+	}
+
+	// kept apart from the segment files, fetching the whole segment
+	// writes its own copy. readers downloading the same index at once
+	// each get their own file.
+	path := filepath.Join(c.dir, keysDir, fmt.Sprintf("%020d-%d", rs.BaseID, tick)+keyIndexSuffix)
+	k, err := c.downloadKeys(name, path)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if existing, ok := c.keyIndexes[rs.BaseID]; ok {
+		_ = k.close()
+		_ = os.Remove(path)
+		return existing, nil
+	}
+	c.keyIndexes[rs.BaseID] = k
+	return k, nil
+}
+
+// downloadKeys downloads the key index object name to path and opens it
+func (c *segmentCache) downloadKeys(name, path string) (*keyIndex, error) {
+	r, err := c.cfg.Tiering.Store.Get(name)
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s: %w", name, err)
+	}
+	data, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	err = writeFileAtomic(path, data)
+	if err != nil {
+		return nil, err
+	}
+
+	k, err := openKeyIndex(path)
+	if err != nil {
+		_ = os.Remove(path)
+		return nil, err
+	}
+	return k, nil
+}
+
+// evict drops the least recently used segments above the cache limit
+func (c *segmentCache) evict() {
+	for len(c.entries) > c.max {
+		var oldest *cachedSegment
+		for _, e := range c.entries {
+			if oldest == nil || e.used < oldest.used {
+				oldest = e
+			}
+		}
+
+		delete(c.entries, oldest.base)
+		oldest.evicted = true
+		if oldest.refs == 0 {
+			c.drop(oldest)
+		}
+	}
+}
+
+// forget evicts the segment starting at baseID, if it is cached
+func (c *segmentCache) forget(baseID uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if k, ok := c.keyIndexes[baseID]; ok {
+		delete(c.keyIndexes, baseID)
+		_ = k.close()
+		_ = os.Remove(k.file.Name())
+	}
+
+	e, ok := c.entries[baseID]
+	if !ok {
+		return
+	}
+
+	delete(c.entries, baseID)
+	e.evicted = true
+	if e.refs == 0 {
+		c.drop(e)
+	}
+}
+
+func (c *segmentCache) drop(e *cachedSegment) {
+	if e.seg == nil {
+		return
+	}
+	_ = e.seg.close()
+	_ = e.seg.remove()
+}
+
+func (c *segmentCache) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for base, e := range c.entries {
+		delete(c.entries, base)
+		e.evicted = true
+		if e.refs == 0 {
+			c.drop(e)
+		}
+	}
+
+	for base, k := range c.keyIndexes {
+		delete(c.keyIndexes, base)
+		_ = k.close()
+	}
+}
+
+// remoteFor returns the offloaded segment holding id, offloaded segments
+// are always older than the local ones
+func remoteFor(remote []SegmentInfo, id uint64) (SegmentInfo, bool) {
+	i := sort.Search(len(remote), func(i int) bool {
+		return remote[i].nextID() > id
+	})
+	if i == len(remote) {
+		return SegmentInfo{}, false
+	}
+
+	return remote[i], true
+}
+
+// deleted reports whether retention removed the offloaded segment rs
+// since the view it was taken from, fetching it fails in that case
+func (l *Log) deleted(rs SegmentInfo) bool {
+	remote := l.view.Load().remote
+	return len(remote) == 0 || remote[0].BaseID > rs.BaseID
+}
+
+// acquire returns the first segment, local or offloaded, holding records
+// at or after id that readers can see. release has to be called once the
+// segment is no longer used.
+func (l *Log) acquire(id uint64) (*segmentedlog, func(), error) {
+	for {
+		v := l.view.Load()
+		if rs, ok := remoteFor(v.remote, id); ok {
+			s, release, err := l.cache.get(rs)
+			if err != nil && l.deleted(rs) {
+				continue
+			}
+			return s, release, err
+		}
+
+		i := sort.Search(len(v.segments), func(i int) bool {
+			return v.segments[i].lastID.Load() >= id
+		})
+		if i == len(v.segments) {
+			return nil, nil, ErrRecordNotFound
+		}
+
+		s := v.segments[i]
+		if s.acquire() {
+			return s, s.release, nil
+		}
+		// s was retired after the view was loaded, the current view no
+		// longer holds it
+	}
+}
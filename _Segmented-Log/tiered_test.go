@@ -0,0 +1,366 @@
+package segmentedlog
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOffload(t *testing.T) {
+	dir := t.TempDir()
+	objects, err := NewDirStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := newTestConfig()
+	cfg.Tiering.Store = objects
+	cfg.Tiering.KeepLocalSegments = 1
+	cfg.Tiering.CacheSegments = 1
+
+	l, err := Open(dir, &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	for i := 1; i <= 100; i++ {
+		_, err := l.Append([]byte(fmt.Sprintf("record-%d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	segments := len(l.segments)
+	err = l.Offload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(l.segments) != 2 {
+		t.Fatalf("expected the active and one sealed segment to stay local, got %d", len(l.segments))
+	}
+	if len(l.remote) != segments-2 {
+		t.Fatalf("expected %d offloaded segments, got %d", segments-2, len(l.remote))
+	}
+
+	_, err = os.Stat(l.segmentPath(1) + storeSuffix)
+	if !os.IsNotExist(err) {
+		t.Error("expected the local copy of an offloaded segment to be deleted")
+	}
+
+	check := func(l *Log) {
+		for i := 1; i <= 100; i++ {
+			r, err := l.Read(uint64(i))
+			if err != nil {
+				t.Fatalf("reading %d: %v", i, err)
+			}
+			if string(r.Value) != fmt.Sprintf("record-%d", i) {
+				t.Errorf("expected record-%d, got %s", i, r.Value)
+			}
+		}
+	}
+	check(l)
+
+	cached, _ := filepath.Glob(filepath.Join(dir, cacheDir, "*"+storeSuffix))
+	if len(cached) > cfg.Tiering.CacheSegments {
+		t.Errorf("expected at most %d cached segments, got %d", cfg.Tiering.CacheSegments, len(cached))
+	}
+
+	id, err := l.ReadFromTime(start)
+	if err != nil || id != 1 {
+		t.Errorf("expected time lookup to reach offloaded segments, got %d %v", id, err)
+	}
+
+	r, err := l.NewReader(1, 101)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := DecodeRecords(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 100 {
+		t.Errorf("expected 100 records through the reader, got %d", len(records))
+	}
+
+	err = l.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err = Open(dir, &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	check(l)
+
+	id, err = l.Append([]byte("next"))
+	if err != nil || id != 101 {
+		t.Errorf("expected next id 101, got %d %v", id, err)
+	}
+}
+
+func TestOffloadPrefixes(t *testing.T) {
+	objects, err := NewDirStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logs := map[string]*Log{}
+	for _, name := range []string{"orders", "payments"} {
+		cfg := newTestConfig()
+		cfg.Tiering.Store = objects
+		cfg.Tiering.Prefix = name + "-"
+
+		l, err := Open(t.TempDir(), &cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+
+		for i := 1; i <= 50; i++ {
+			_, err := l.Append([]byte(fmt.Sprintf("%s-%d", name, i)))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		err = l.Offload()
+		if err != nil {
+			t.Fatal(err)
+		}
+		logs[name] = l
+	}
+
+	for name, l := range logs {
+		for i := 1; i <= 50; i++ {
+			r, err := l.Read(uint64(i))
+			if err != nil {
+				t.Fatalf("reading %d of %s: %v", i, name, err)
+			}
+			if string(r.Value) != fmt.Sprintf("%s-%d", name, i) {
+				t.Errorf("expected %s-%d, got %s", name, i, r.Value)
+			}
+		}
+	}
+}
+
+// gatedStore holds up the downloads of one object until gate is closed
+type gatedStore struct {
+	ObjectStore
+	name    string
+	gate    chan struct{}
+	waiting chan struct{}
+}
+
+func (s *gatedStore) Get(name string) (io.ReadCloser, error) {
+	if name == s.name {
+		close(s.waiting)
+		<-s.gate
+	}
+	return s.ObjectStore.Get(name)
+}
+
+func TestCacheDownloadsWithoutLock(t *testing.T) {
+	objects, err := NewDirStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &gatedStore{ObjectStore: objects, gate: make(chan struct{}), waiting: make(chan struct{})}
+
+	cfg := newTestConfig()
+	cfg.Tiering.Store = store
+	cfg.Tiering.CacheSegments = 2
+
+	l, err := Open(t.TempDir(), &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	for i := 1; i <= 100; i++ {
+		_, err := l.Append([]byte("record"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = l.Offload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l.remote) < 2 {
+		t.Fatalf("expected at least 2 offloaded segments, got %d", len(l.remote))
+	}
+
+	// the first segment is cached, the download of the second one hangs
+	first, second := l.remote[0], l.remote[1]
+	_, err = l.Read(first.BaseID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.name = second.Objects[storeSuffix]
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := l.Read(second.BaseID)
+		done <- err
+	}()
+	<-store.waiting
+
+	read := make(chan error, 1)
+	go func() {
+		_, err := l.Read(first.BaseID)
+		read <- err
+	}()
+	select {
+	case err := <-read:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a cached read not to wait for another download")
+	}
+
+	close(store.gate)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCacheDownloadsKeysWithoutLock(t *testing.T) {
+	objects, err := NewDirStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &gatedStore{ObjectStore: objects, gate: make(chan struct{}), waiting: make(chan struct{})}
+
+	cfg := newTestConfig()
+	cfg.Tiering.Store = store
+	cfg.Tiering.CacheSegments = 2
+
+	l, err := Open(t.TempDir(), &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	for i := 1; i <= 100; i++ {
+		_, err := l.AppendBatch([]Record{{Key: []byte(fmt.Sprintf("key-%d", i)), Value: []byte("record")}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = l.Offload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l.remote) < 2 {
+		t.Fatalf("expected at least 2 offloaded segments, got %d", len(l.remote))
+	}
+
+	// the first segment is cached, the key index download of the second
+	// one hangs
+	first, second := l.remote[0], l.remote[1]
+	_, err = l.Read(first.BaseID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.name = second.Objects[keyIndexSuffix]
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := l.cache.keys(second)
+		done <- err
+	}()
+	<-store.waiting
+
+	read := make(chan error, 1)
+	go func() {
+		_, err := l.Read(first.BaseID)
+		read <- err
+	}()
+	select {
+	case err := <-read:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a cached read not to wait for a key index download")
+	}
+
+	close(store.gate)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// failingStore fails the downloads of one object
+type failingStore struct {
+	ObjectStore
+	name string
+}
+
+func (s *failingStore) Get(name string) (io.ReadCloser, error) {
+	if name == s.name {
+		return nil, errors.New("object store unavailable")
+	}
+	return s.ObjectStore.Get(name)
+}
+
+func TestCacheRemovesFailedDownloads(t *testing.T) {
+	dir := t.TempDir()
+	objects, err := NewDirStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &failingStore{ObjectStore: objects}
+
+	cfg := newTestConfig()
+	cfg.Tiering.Store = store
+
+	l, err := Open(dir, &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	for i := 1; i <= 100; i++ {
+		_, err := l.Append([]byte("record"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = l.Offload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the index is downloaded before the store
+	first := l.remote[0]
+	store.name = first.Objects[storeSuffix]
+	_, err = l.Read(first.BaseID)
+	if err == nil {
+		t.Fatal("expected the read of a segment that cannot be downloaded to fail")
+	}
+
+	partial, _ := filepath.Glob(filepath.Join(dir, cacheDir, "*"+indexSuffix))
+	if len(partial) != 0 {
+		t.Errorf("expected a failed download to leave no files, got %v", partial)
+	}
+
+	store.name = ""
+	_, err = l.Read(first.BaseID)
+	if err != nil {
+		t.Errorf("expected the download to be tried again, got %v", err)
+	}
+}
@@ -0,0 +1,180 @@
+package segmentedlog
+
+import (
+	"encoding/binary"
+	"os"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/edsrzf/mmap-go"
+)
+
+// timeIndex stores mapping between append timestamp and recordID
+// an entry is only added when the timestamp moves forward, so both
+// columns stay sorted and the file can be binary searched. like Index it
+// has a single writer, size is published after the entry it covers.
+type timeIndex struct {
+	mm            mmap.MMap
+	file          *os.File
+	maxsize       uint64
+	size          atomic.Uint64
+	lastTimestamp atomic.Uint64
+	lazy          bool
+}
+
+// timestamp converts t into the unsigned nanoseconds kept in the time index,
+// times before the unix epoch map to zero
+func timestamp(t time.Time) uint64 {
+	if t.Before(time.Unix(0, 0)) {
+		return 0
+	}
+
+	return uint64(t.UnixNano())
+}
+
+func newTimeIndex(file string, cfg *Config) (*timeIndex, error) {
+	return openTimeIndex(file, cfg, false)
+}
+
+// openTimeIndex opens the time index of a segment, sealed ones are mapped
+// as they are
+func openTimeIndex(file string, cfg *Config, sealed bool) (*timeIndex, error) {
+	if cfg.Segment.MaxIndexSizeBytes == 0 || cfg.Segment.MaxIndexSizeBytes%16 != 0 {
+		return nil, ErrMaxIndexSize
+	}
+
+	f, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	mm, err := mapIndexFile(f, cfg.Segment.MaxIndexSizeBytes, sealed)
+	if err != nil {
+		return nil, err
+	}
+
+	var size, last uint64
+	for i := 0; i < len(mm); i += 16 {
+		ts := binary.BigEndian.Uint64(mm[i : i+8])
+		id := binary.BigEndian.Uint64(mm[i+8 : i+16])
+
+		if ts == 0 && id == 0 {
+			break
+		}
+
+		size += 16
+		last = ts
+	}
+
+	t := &timeIndex{
+		mm:      mm,
+		file:    f,
+		maxsize: uint64(len(mm)),
+		lazy:    cfg.Flush.MaxBufferedBytes > 0,
+	}
+	t.size.Store(size)
+	t.lastTimestamp.Store(last)
+
+	return t, nil
+}
+
+// write records that id is the first record appended at timestamp ts.
+// timestamps that do not move forward are skipped.
+func (t *timeIndex) write(ts uint64, id uint64) error {
+	if ts <= t.lastTimestamp.Load() {
+		return nil
+	}
+
+	size := t.size.Load()
+	if size >= t.maxsize {
+		return ErrMaxIndexSize
+	}
+
+	binary.BigEndian.PutUint64(t.mm[size:size+8], ts)
+	binary.BigEndian.PutUint64(t.mm[size+8:size+16], id)
+
+	t.size.Store(size + 16)
+	t.lastTimestamp.Store(ts)
+
+	if t.lazy {
+		return nil
+	}
+
+	return t.flush()
+}
+
+// flush syncs the time index to disk
+func (t *timeIndex) flush() error {
+	if len(t.mm) == 0 {
+		return nil
+	}
+
+	return t.mm.Flush()
+}
+
+// seal shrinks the time index file to the entries written so far
+func (t *timeIndex) seal() error {
+	size := t.size.Load()
+	err := shrinkIndexFile(t.file, t.mm, size)
+	if err != nil {
+		return err
+	}
+
+	t.maxsize = size
+	return nil
+}
+
+// lookup returns the first recordID whose timestamp is at or after ts
+func (t *timeIndex) lookup(ts uint64) (uint64, bool) {
+	n := int(t.size.Load() / 16)
+	i := sort.Search(n, func(i int) bool {
+		return binary.BigEndian.Uint64(t.mm[i*16:i*16+8]) >= ts
+	})
+	if i == n {
+		return 0, false
+	}
+
+	return binary.BigEndian.Uint64(t.mm[i*16+8 : i*16+16]), true
+}
+
+// before returns the recordID of the last entry with a timestamp before
+// ts
+func (t *timeIndex) before(ts uint64) (uint64, bool) {
+	i := sort.Search(int(t.size.Load()/16), func(i int) bool {
+		return binary.BigEndian.Uint64(t.mm[i*16:i*16+8]) >= ts
+	})
+	if i == 0 {
+		return 0, false
+	}
+
+	return binary.BigEndian.Uint64(t.mm[i*16-8 : i*16]), true
+}
+
+// reset drops every entry of the time index
+func (t *timeIndex) reset() error {
+	for b := range t.mm {
+		t.mm[b] = 0
+	}
+
+	t.size.Store(0)
+	t.lastTimestamp.Store(0)
+
+	return t.flush()
+}
+
+func (t *timeIndex) close() error {
+	if t.mm != nil {
+		err := t.mm.Unmap()
+		if err != nil {
+			return err
+		}
+		t.mm = nil
+	}
+
+	return t.file.Close()
+}
+
+func (t *timeIndex) remove() error {
+	return os.Remove(t.file.Name())
+}
@@ -0,0 +1,121 @@
+package segmentedlog
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+)
+
+// the verifiers only need the proofs and tree heads handed out by a log,
+// an auditor runs them without access to the log itself. they follow RFC
+// 9162 sections 2.1.3.2 and 2.1.4.2.
+
+var (
+	ErrBadSignature = errors.New("tree head signature does not verify")
+	ErrBadProof     = errors.New("merkle proof does not verify")
+)
+
+// VerifyTreeHead checks the signature of sth against the public key of the
+// log
+func VerifyTreeHead(key ed25519.PublicKey, sth SignedTreeHead) error {
+	if len(key) != ed25519.PublicKeySize || !ed25519.Verify(key, treeHeadInput(sth), sth.Signature) {
+		return ErrBadSignature
+	}
+
+	return nil
+}
+
+// VerifyInclusion checks that r is the record proof was made for and that
+// it is part of the tree with the given root hash
+func VerifyInclusion(r Record, proof InclusionProof, root []byte) error {
+	if r.ID != proof.ID || proof.LeafIndex >= proof.TreeSize {
+		return ErrBadProof
+	}
+
+	fn, sn := proof.LeafIndex, proof.TreeSize-1
+	h := LeafHash(r)
+	for _, p := range proof.Hashes {
+		if sn == 0 {
+			return ErrBadProof
+		}
+
+		if fn&1 == 1 || fn == sn {
+			h = hashChildren(p, h)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			h = hashChildren(h, p)
+		}
+
+		fn >>= 1
+		sn >>= 1
+	}
+
+	if sn != 0 || !bytes.Equal(h, root) {
+		return ErrBadProof
+	}
+
+	return nil
+}
+
+// VerifyConsistency checks that the tree of oldSize records with oldRoot
+// is a prefix of the tree of newSize records with newRoot
+func VerifyConsistency(oldSize, newSize uint64, oldRoot, newRoot []byte, proof [][]byte) error {
+	switch {
+	case oldSize > newSize:
+		return ErrBadProof
+	case oldSize == newSize:
+		if len(proof) != 0 || !bytes.Equal(oldRoot, newRoot) {
+			return ErrBadProof
+		}
+		return nil
+	case oldSize == 0:
+		// the empty tree is a prefix of every tree
+		if len(proof) != 0 {
+			return ErrBadProof
+		}
+		return nil
+	}
+
+	if oldSize&(oldSize-1) == 0 {
+		proof = append([][]byte{oldRoot}, proof...)
+	}
+	if len(proof) == 0 {
+		return ErrBadProof
+	}
+
+	fn, sn := oldSize-1, newSize-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+
+	fr, sr := proof[0], proof[0]
+	for _, c := range proof[1:] {
+		if sn == 0 {
+			return ErrBadProof
+		}
+
+		if fn&1 == 1 || fn == sn {
+			fr = hashChildren(c, fr)
+			sr = hashChildren(c, sr)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			sr = hashChildren(sr, c)
+		}
+
+		fn >>= 1
+		sn >>= 1
+	}
+
+	if sn != 0 || !bytes.Equal(fr, oldRoot) || !bytes.Equal(sr, newRoot) {
+		return ErrBadProof
+	}
+
+	return nil
+}
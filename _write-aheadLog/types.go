@@ -5,7 +5,8 @@ import (
 	"context"
 	"os"
 	"sync"
-	"time"
+
+	"github.com/EncrypteID/Replicate-Partition-Next-Generation-Systems/_Clock"
 )
 
 type WAL struct {
@@ -29,34 +30,26 @@ type WAL struct {
 	directory           string
 	lock                sync.Mutex
 	lastSequenceNo      uint64
-	syncTimer           *time.Timer
-	shouldSync         bool
+	syncTimer           clock.Timer
+	shouldSync          bool
 	maxFileSize         int64
 	ctx                 context.Context
 	cancel              context.CancelFunc
-	currentSegment *os.File
+	currentSegment      *os.File
 	currentSegmentIndex int
 }
 
 type segments struct {
 	maxSegments         int
-	logPath        string
-	indexPath      string
-	indexSpace     int
-	offset         uint64
-	len            uint64
-	currentSegment *os.File
+	logPath             string
+	indexPath           string
+	indexSpace          int
+	offset              uint64
+	len                 uint64
+	currentSegment      *os.File
 	currentSegmentIndex int
-	indexFile      *os.File
-	indexMmap      []byte
-	logFile        *os.File
-	indexBuffer    []byte
+	indexFile           *os.File
+	indexMmap           []byte
+	logFile             *os.File
+	indexBuffer         []byte
 }
-
-
-type WAL_Entry struct{
-    logSequenceNumber int
-    datan []byte
-    CRC uint64
-	isCheckPoint bool
-}
\ No newline at end of file
@@ -0,0 +1,112 @@
+// Code generated by protoc-gen-gogo. DO NOT EDIT.
+// source: _write-aheadLog/types.proto
+
+package writeaheadlog
+
+import (
+	fmt "fmt"
+	proto "github.com/gogo/protobuf/proto"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.GoGoProtoPackageIsVersion3 // please upgrade the proto package
+
+// every entry on disk is a 4 byte little-endian size followed by a
+// WAL_Entry. crc is the CRC-32 of data followed by the low byte of the
+// sequence number.
+type WAL_Entry struct {
+	LogSequenceNumber    uint64   `protobuf:"varint,1,opt,name=log_sequence_number,json=logSequenceNumber,proto3" json:"log_sequence_number,omitempty"`
+	Data                 []byte   `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	Crc                  uint32   `protobuf:"varint,3,opt,name=crc,proto3" json:"crc,omitempty"`
+	IsCheckpoint         bool     `protobuf:"varint,4,opt,name=is_checkpoint,json=isCheckpoint,proto3" json:"is_checkpoint,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *WAL_Entry) Reset()         { *m = WAL_Entry{} }
+func (m *WAL_Entry) String() string { return proto.CompactTextString(m) }
+func (*WAL_Entry) ProtoMessage()    {}
+func (*WAL_Entry) Descriptor() ([]byte, []int) {
+	return fileDescriptor_ff1b8afdda76b391, []int{0}
+}
+func (m *WAL_Entry) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_WAL_Entry.Unmarshal(m, b)
+}
+func (m *WAL_Entry) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_WAL_Entry.Marshal(b, m, deterministic)
+}
+func (m *WAL_Entry) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_WAL_Entry.Merge(m, src)
+}
+func (m *WAL_Entry) XXX_Size() int {
+	return xxx_messageInfo_WAL_Entry.Size(m)
+}
+func (m *WAL_Entry) XXX_DiscardUnknown() {
+	xxx_messageInfo_WAL_Entry.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_WAL_Entry proto.InternalMessageInfo
+
+func (m *WAL_Entry) GetLogSequenceNumber() uint64 {
+	if m != nil {
+		return m.LogSequenceNumber
+	}
+	return 0
+}
+
+func (m *WAL_Entry) GetData() []byte {
+	if m != nil {
+		return m.Data
+	}
+	return nil
+}
+
+func (m *WAL_Entry) GetCrc() uint32 {
+	if m != nil {
+		return m.Crc
+	}
+	return 0
+}
+
+func (m *WAL_Entry) GetIsCheckpoint() bool {
+	if m != nil {
+		return m.IsCheckpoint
+	}
+	return false
+}
+
+func init() {
+	proto.RegisterType((*WAL_Entry)(nil), "writeaheadlog.WAL_Entry")
+}
+
+func init() { proto.RegisterFile("_write-aheadLog/types.proto", fileDescriptor_ff1b8afdda76b391) }
+
+var fileDescriptor_ff1b8afdda76b391 = []byte{
+	// 241 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x5c, 0xd0, 0x3f, 0x4b, 0xc5, 0x30,
+	0x14, 0x05, 0x70, 0xe2, 0x2b, 0xa2, 0xe1, 0x15, 0x34, 0x2e, 0x05, 0x97, 0xa2, 0x4b, 0x97, 0xb6,
+	0x83, 0xa3, 0x93, 0x7f, 0x1e, 0x22, 0x3c, 0x1e, 0x92, 0x37, 0x08, 0x2e, 0x21, 0x4d, 0x2f, 0x69,
+	0xb0, 0x4d, 0x62, 0x72, 0x8b, 0xf6, 0x0b, 0xf8, 0xb9, 0xc5, 0x20, 0x82, 0x6e, 0xe7, 0xde, 0xdf,
+	0x74, 0x0e, 0x3d, 0x17, 0xef, 0xc1, 0x20, 0xd4, 0x72, 0x00, 0xd9, 0x6f, 0x9d, 0x6e, 0x71, 0xf1,
+	0x10, 0x1b, 0x1f, 0x1c, 0x3a, 0x96, 0x27, 0x4b, 0x34, 0x3a, 0x7d, 0xf1, 0x49, 0xe8, 0xf1, 0xf3,
+	0xcd, 0x56, 0x6c, 0x2c, 0x86, 0x85, 0x35, 0xf4, 0x6c, 0x74, 0x5a, 0x44, 0x78, 0x9b, 0xc1, 0x2a,
+	0x10, 0x76, 0x9e, 0x3a, 0x08, 0x05, 0x29, 0x49, 0x95, 0xf1, 0xd3, 0xd1, 0xe9, 0xfd, 0x8f, 0xec,
+	0x12, 0x30, 0x46, 0xb3, 0x5e, 0xa2, 0x2c, 0x0e, 0x4a, 0x52, 0xad, 0x79, 0xca, 0xec, 0x84, 0xae,
+	0x54, 0x50, 0xc5, 0xaa, 0x24, 0x55, 0xce, 0xbf, 0x23, 0xbb, 0xa4, 0xb9, 0x89, 0x42, 0x0d, 0xa0,
+	0x5e, 0xbd, 0x33, 0x16, 0x8b, 0xac, 0x24, 0xd5, 0x11, 0x5f, 0x9b, 0x78, 0xf7, 0xfb, 0xbb, 0x95,
+	0x2f, 0x42, 0x1b, 0x1c, 0xe6, 0xae, 0x51, 0x6e, 0x6a, 0x37, 0x56, 0x85, 0xc5, 0x23, 0x3c, 0xde,
+	0xb7, 0x1c, 0xfc, 0x68, 0x94, 0x44, 0xa8, 0x9f, 0x64, 0x40, 0x83, 0xc6, 0xd9, 0x7a, 0x07, 0x1f,
+	0x58, 0x3f, 0x80, 0x85, 0x20, 0xd3, 0xbd, 0x5f, 0x22, 0xc2, 0x14, 0xdb, 0x7f, 0xc5, 0xaf, 0xff,
+	0x74, 0xed, 0x0e, 0xd3, 0x02, 0x57, 0x5f, 0x03, 0x00, 0xf7, 0xc3, 0x16, 0xf7, 0x20, 0x01, 0x00,
+	0x00,
+}
@@ -45,7 +45,7 @@ func verifyCRC(entry *WAL_Entry) bool {
 	// Reset the entry CRC for the verification.
 	actualCRC := crc32.ChecksumIEEE(append(entry.GetData(), byte(entry.GetLogSequenceNumber())))
 
-	return entry.CRC == actualCRC
+	return entry.GetCrc() == actualCRC
 }
 
 // Finds the last segment ID from the given list of files.
@@ -14,6 +14,9 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/EncrypteID/Replicate-Partition-Next-Generation-Systems/_Clock"
+	"github.com/gogo/protobuf/proto"
 )
 
 const (
@@ -25,6 +28,12 @@ const (
 // If the directory exists, the last log segment will be opened and the last
 // sequence number will be read from it.
 func OpenWal(directory string, enableSync bool, maxFileSize int64, maxSegment int) (*WAL, error) {
+	return OpenWalWithClock(directory, enableSync, maxFileSize, maxSegment, clock.Real)
+}
+
+// OpenWalWithClock opens a WAL whose periodic sync is timed by clk, tests
+// pass a fake clock and advance it instead of sleeping.
+func OpenWalWithClock(directory string, enableSync bool, maxFileSize int64, maxSegment int, clk clock.Clock) (*WAL, error) {
 	//Create The directory if it doesn't exit
 	if err := os.MkdirAll(directory, 0777); err != nil {
 		return nil, err
@@ -65,12 +74,12 @@ func OpenWal(directory string, enableSync bool, maxFileSize int64, maxSegment in
 
 	ctx, cancel := context.WithCancel(context.Background())
 
-	WAL := *&WAL{
+	w := &WAL{
 		directory:           directory,
 		currentSegment:      file,
 		lastSequenceNo:      0,
 		witeBuffer:          *bufio.NewWriter(file),
-		syncTimer:           time.NewTimer(syncInterval), // syncInterval is a predefined duratio
+		syncTimer:           clk.NewTimer(syncInterval), // syncInterval is a predefined duratio
 		shouldSync:          enableSync,
 		maxFileSize:         maxFileSize,
 		maxSegments:         maxSegment,
@@ -78,7 +87,7 @@ func OpenWal(directory string, enableSync bool, maxFileSize int64, maxSegment in
 		ctx:                 ctx,
 		cancel:              cancel,
 	}
-	if WAL.lastSequenceNo, err = w.getLastSequenceNo(); err != nil {
+	if w.lastSequenceNo, err = w.getLastSequenceNo(); err != nil {
 		return nil, err
 	}
 
@@ -109,7 +118,7 @@ func readAllEntriesFromFile(file *os.File, readFromCheckpoint bool) ([]*WAL_Entr
 		// If we are reading from checkpoint and we find a checkpoint entry, we
 		// we should return the entries from the last checkpoint. So we empty the
 		// entries slice and start appending entries from the checkpoint.
-		if entry.isCheckPoint != nil && entry.GetIsChechPoint() {
+		if readFromCheckpoint && entry.GetIsCheckpoint() {
 			checkPointLogSequenceNo = entry.GetLogSequenceNumber()
 			// Empty the entries slice
 			entries = entries[:0]
@@ -145,12 +154,12 @@ func readAllEntriesFromFile(file *os.File, readFromCheckpoint bool) ([]*WAL_Entr
 
 // WriteEntry writes an entry to teh WAL.
 func (w *WAL) WriteEntry(data []byte) error {
-	return w.WriteEntry(data)
+	return w.writeEntry(data, false)
 }
 
 // CreateCheckPoint creates a checkPoint enrty in teh WAL.
 func (w *WAL) CreateCheckPoint(data []byte) error {
-	return w.CreateCheckPoint(data)
+	return w.writeEntry(data, true)
 }
 
 func (w *WAL) writeEntry(data []byte, isCheckPoint bool) error {
@@ -163,16 +172,16 @@ func (w *WAL) writeEntry(data []byte, isCheckPoint bool) error {
 
 	w.lastSequenceNo++
 	entry := &WAL_Entry{
-		logSequenceNumber: int(w.lastSequenceNo),
-		datan:             data,
-		CRC:               uint64(crc32.ChecksumIEEE(append(data, byte(w.lastSequenceNo)))),
+		LogSequenceNumber: w.lastSequenceNo,
+		Data:              data,
+		Crc:               crc32.ChecksumIEEE(append(data, byte(w.lastSequenceNo))),
 	}
 
 	if isCheckPoint {
 		if err := w.Sync(); err != nil {
 			return fmt.Errorf("could not create checkpoint, err while syncing: %v", err)
 		}
-		entry.isCheckPoint = isCheckPoint
+		entry.IsCheckpoint = isCheckPoint
 	}
 	return w.WriteEntryToBufer(entry)
 }
@@ -275,6 +284,9 @@ func (w *WAL) findOldestSegemetFile(files []string) (string, error) {
 // Close Th wal file. It also calls Sync() on the Wal()
 func (w *WAL) Close() error {
 	w.cancel()
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
 	if err := w.Sync(); err != nil {
 		return err
 	}
@@ -336,6 +348,7 @@ func (w *WAL) ReadFromOfsset(offset int, readFromCheckPoint bool) ([]*WAL_Entry,
 		}
 
 		entries_from_scratch, checkpoint, err := readAllEntriesFromFile(file, readFromCheckPoint)
+		file.Close()
 		if err != nil {
 			return entries, err
 		}
@@ -375,7 +388,7 @@ func (w *WAL) resetTimer() {
 func (w *WAL) keepSyncing() {
 	for {
 		select {
-		case <-w.syncTimer.C:
+		case <-w.syncTimer.C():
 			w.lock.Lock()
 			err := w.Sync()
 			w.lock.Unlock()
@@ -455,12 +468,12 @@ func (w *WAL) Repair() ([]*WAL_Entry, error) {
 
 		// Deserialize the entry.
 		var entry WAL_Entry
-		// if err := w.Unmarshal(data, &entry); err != nil {
-		// 	if err := wal.replaceWithFixedFile(entries); err != nil {
-		// 		return entries, err
-		// 	}
-		// 	return entries, nil
-		// }
+		if err := proto.Unmarshal(data, &entry); err != nil {
+			if err := w.replaceWithFixedFile(entries); err != nil {
+				return entries, err
+			}
+			return entries, nil
+		}
 
 		if !verifyCRC(&entry) {
 			log.Printf("CRC mismatch: data may be corrupted")
@@ -583,4 +596,4 @@ func (w *WAL) getLastEntryInLog() (*WAL_Entry, error) {
 			return nil, err
 		}
 	}
-}
\ No newline at end of file
+}
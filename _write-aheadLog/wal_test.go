@@ -1,2 +1,93 @@
 package writeaheadlog
 
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/EncrypteID/Replicate-Partition-Next-Generation-Systems/_Clock"
+)
+
+func TestWriteAndReadAll(t *testing.T) {
+	dir := t.TempDir()
+	w, err := OpenWal(dir, true, 1<<20, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, data := range []string{"one", "two", "three"} {
+		if err := w.WriteEntry([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.CreateCheckPoint([]byte("checkpoint")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteEntry([]byte("four")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err = OpenWal(dir, true, 1<<20, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if w.lastSequenceNo != 5 {
+		t.Errorf("expected the last sequence number 5 after reopening, got %d", w.lastSequenceNo)
+	}
+
+	entries, err := w.ReadAll(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 5 || string(entries[0].GetData()) != "one" || entries[4].GetLogSequenceNumber() != 5 {
+		t.Errorf("expected the 5 entries written, got %v", entries)
+	}
+
+	entries, err = w.ReadAll(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || !entries[0].GetIsCheckpoint() || string(entries[1].GetData()) != "four" {
+		t.Errorf("expected the entries from the checkpoint on, got %v", entries)
+	}
+}
+
+func TestSyncTimer(t *testing.T) {
+	fake := clock.NewFake(time.Unix(0, 0))
+	w, err := OpenWalWithClock(t.TempDir(), false, 1<<20, 5, fake)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	size := func() int64 {
+		info, err := os.Stat(w.currentSegment.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info.Size()
+	}
+
+	if err := w.WriteEntry([]byte("buffered")); err != nil {
+		t.Fatal(err)
+	}
+	fake.Advance(syncInterval - time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	if size() != 0 {
+		t.Fatal("expected the entry to stay buffered until the sync interval passed")
+	}
+
+	fake.Advance(time.Millisecond)
+	deadline := time.Now().Add(time.Second)
+	for size() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the entry to be synced once the sync interval passed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
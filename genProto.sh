@@ -1,3 +1,6 @@
 #!/bin/bash
 
-protoc --go_out=. --go_opt=paths=source_relative types.proto
\ No newline at end of file
+# the messages are encoded with gogo/protobuf, generating them needs
+# protoc-gen-gogo: go install github.com/gogo/protobuf/protoc-gen-gogo
+protoc --gogo_out=paths=source_relative:. _write-aheadLog/types.proto
+protoc --gogo_out=paths=source_relative:. _Segmented-Log/logpb/log.proto
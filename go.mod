@@ -5,6 +5,7 @@ go 1.22.3
 require (
 	github.com/edsrzf/mmap-go v1.1.0
 	github.com/gogo/protobuf v1.3.2
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require golang.org/x/sys v0.0.0-20211216021012-1d35b9e2eb4e // indirect